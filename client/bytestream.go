@@ -0,0 +1,35 @@
+package client
+
+import (
+	"context"
+
+	"github.com/givensuman/go-sockets/internal/mux"
+)
+
+// ByteStream is a raw byte stream tunnelled through a socket's connection, as
+// binary frames alongside its events. It is an io.ReadWriteCloser: writes wait
+// while the peer's window is full, reads return io.EOF once the peer closes
+// the stream, and both fail with io.ErrUnexpectedEOF if the connection drops
+// first. Close ends the stream in both directions.
+type ByteStream = mux.Stream
+
+// ErrByteStreamRefused is returned by OpenStream when the server does not
+// accept byte streams.
+var ErrByteStreamRefused = mux.ErrRefused
+
+// OpenStream opens a byte stream to the server with the given name, waiting
+// until the server accepts it with AcceptStream or ctx is done.
+func (s *Socket) OpenStream(ctx context.Context, name string) (*ByteStream, error) {
+	return s.mux.Open(ctx, name)
+}
+
+// AcceptStream sets the function receiving the byte streams the server opens,
+// each on a goroutine of its own. Streams are refused until it is called.
+func (s *Socket) AcceptStream(fn func(stream *ByteStream)) {
+	s.mux.SetAccept(fn)
+}
+
+// sendFrame queues a byte stream frame, waiting while the write buffer is full.
+func (s *Socket) sendFrame(ctx context.Context, frame []byte) error {
+	return s.queueWait(ctx, outgoing{frame: frame})
+}
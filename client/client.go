@@ -3,17 +3,46 @@
 package client
 
 import (
+	"context"
+	"io"
+	"net/http"
 	"net/url"
+	"time"
 
-	"github.com/givensuman/go-sockets"
+	"github.com/givensuman/go-sockets/internal/dispatch"
 	"github.com/givensuman/go-sockets/internal/emitter"
+	"github.com/givensuman/go-sockets/internal/mux"
+	"github.com/givensuman/go-sockets/internal/reliable"
 	"github.com/gorilla/websocket"
 )
 
+// Option configures a Socket created by Connect.
+type Option func(*Socket)
+
+// WithReconnect makes the socket reconnect when its connection drops, waiting
+// between attempts with exponential backoff from minDelay up to maxDelay.
+// The socket emits "disconnect" with the reason "transport close" when the
+// connection drops, and "reconnect" followed by "connect" once it is back.
+// A socket closed by the server or by Close does not reconnect.
+func WithReconnect(minDelay, maxDelay time.Duration) Option {
+	return func(s *Socket) {
+		s.reconnectMin = max(minDelay, time.Millisecond)
+		s.reconnectMax = max(maxDelay, s.reconnectMin)
+	}
+}
+
+// WithHeader sends header with the WebSocket handshake, and again on every
+// reconnect, for example to pass credentials to connection middleware.
+func WithHeader(header http.Header) Option {
+	return func(s *Socket) {
+		s.header = header.Clone()
+	}
+}
+
 // Connect establishes a WebSocket connection to the Socket.IO server at the given URL and namespace.
 // It calls onConnect with the socket once connected, then emits a "connect" event.
 // Namespace defaults to "/" if empty.
-func Connect(serverURL string, namespace string, onConnect func(*Socket)) (*Socket, error) {
+func Connect(serverURL string, namespace string, onConnect func(*Socket), opts ...Option) (*Socket, error) {
 	if namespace == "" {
 		namespace = "/"
 	}
@@ -24,27 +53,171 @@ func Connect(serverURL string, namespace string, onConnect func(*Socket)) (*Sock
 	}
 	u.Path = namespace
 
+	socket := &Socket{
+		EventEmitter: emitter.EventEmitter{},
+		writeChan:    make(chan outgoing, 10),
+		done:         make(chan struct{}),
+		Namespace:    namespace,
+		url:          u.String(),
+		outbox:       reliable.NewOutbox(),
+		seen:         reliable.NewWindow(reliable.DefaultWindow),
+		streamWindow: DefaultStreamWindow,
+		chunkSize:    DefaultChunkSize,
+		ackTimeout:   DefaultAckTimeout,
+	}
+	socket.ctx, socket.cancel = context.WithCancel(context.Background())
+	socket.mux = mux.New(socket.ctx, socket.sendFrame, true, 0)
+	for _, opt := range opts {
+		opt(socket)
+	}
+	if socket.resume {
+		// An empty session ID asks the server for a session token
+		query := u.Query()
+		query.Set("sid", "")
+		u.RawQuery = query.Encode()
+		socket.url = u.String()
+	}
+
 	dialer := websocket.Dialer{}
-	conn, _, err := dialer.Dial(u.String(), nil)
+	conn, _, err := dialer.Dial(u.String(), socket.header)
 	if err != nil {
 		return nil, err
 	}
-
-	socket := &Socket{
-		EventEmitter: emitter.EventEmitter{},
-		Conn:         conn,
-		writeChan:    make(chan sockets.Packet, 10),
-		Namespace:    namespace,
+	socket.Conn = conn
+	socket.conn = conn
+	socket.lost = make(chan struct{})
+	if socket.dispatcher == nil {
+		socket.dispatcher = dispatch.New(Dispatch{})
 	}
+	socket.handlers = socket.dispatcher.Queue()
+	socket.Catch(socket.reportError)
 
-	go socket.readLoop()
+	// Let onConnect register listeners before any packet is read
 	go socket.writeLoop()
-
 	if onConnect != nil {
 		onConnect(socket)
 	}
+	socket.attach(conn)
 
 	socket.EventEmitter.Emit("connect")
 
 	return socket, nil
 }
+
+// attach starts reading from conn and resends unacknowledged reliable messages over it.
+func (s *Socket) attach(conn *websocket.Conn) {
+	go s.readLoop(conn)
+
+	outbox := s.outbox
+	outbox.Attach(func(id string, payload []any) {
+		args := append([]any{id}, payload...)
+		args = append(args, func() { outbox.Ack(id) })
+		s.emit(reliableEvent, args...)
+	})
+}
+
+// currentConn returns the current connection, waiting while the socket
+// reconnects. It returns nil once the socket is closed.
+func (s *Socket) currentConn() *websocket.Conn {
+	for {
+		s.connMu.Lock()
+		conn, ready := s.conn, s.ready
+		s.connMu.Unlock()
+
+		if conn != nil {
+			return conn
+		}
+		select {
+		case <-ready:
+		case <-s.done:
+			return nil
+		}
+	}
+}
+
+// currentConnNoWait returns the current connection, or nil while reconnecting.
+func (s *Socket) currentConnNoWait() *websocket.Conn {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	return s.conn
+}
+
+// connectionLost closes the socket, or starts reconnecting if enabled.
+func (s *Socket) connectionLost(conn *websocket.Conn) {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	if s.reconnectMax == 0 {
+		s.handlers.Run(func() {
+			s.EventEmitter.Emit("disconnect", "transport close")
+		})
+		s.Close()
+		return
+	}
+
+	s.connMu.Lock()
+	if s.conn != conn {
+		s.connMu.Unlock()
+		return
+	}
+	s.conn = nil
+	s.ready = make(chan struct{})
+	close(s.lost)
+	s.connMu.Unlock()
+
+	conn.Close()
+	s.outbox.Detach()
+	s.endStreams()
+	s.mux.Reset(io.ErrUnexpectedEOF)
+	s.handlers.Run(func() {
+		s.EventEmitter.Emit("disconnect", "transport close")
+	})
+
+	go s.reconnect()
+}
+
+// reconnect dials the server until it succeeds or the socket is closed.
+func (s *Socket) reconnect() {
+	delay := s.reconnectMin
+	for {
+		select {
+		case <-time.After(delay):
+		case <-s.done:
+			return
+		}
+
+		s.connMu.Lock()
+		u := s.url
+		s.connMu.Unlock()
+
+		dialer := websocket.Dialer{}
+		conn, _, err := dialer.Dial(u, s.header)
+		if err != nil {
+			delay = min(delay*2, s.reconnectMax)
+			continue
+		}
+
+		s.connMu.Lock()
+		select {
+		case <-s.done:
+			s.connMu.Unlock()
+			conn.Close()
+			return
+		default:
+		}
+		s.Conn = conn
+		s.conn = conn
+		s.lost = make(chan struct{})
+		close(s.ready)
+		s.connMu.Unlock()
+
+		s.attach(conn)
+		s.handlers.Run(func() {
+			s.EventEmitter.Emit("reconnect")
+			s.EventEmitter.Emit("connect")
+		})
+		return
+	}
+}
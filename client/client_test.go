@@ -1,7 +1,16 @@
 package client
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -113,3 +122,680 @@ func TestClientAckE2E(t *testing.T) {
 		t.Fatal("ack response not received")
 	}
 }
+
+func TestClientConnectError(t *testing.T) {
+	server := srv.NewServer()
+	server.Of("/").Use(func(s *srv.Socket) error {
+		return errors.New("unauthorized")
+	})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	connectErr := make(chan string, 1)
+	clientSocket, err := Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), "/", func(s *Socket) {
+		s.On("connect_error", func(msg string) {
+			connectErr <- msg
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientSocket.Close()
+
+	select {
+	case msg := <-connectErr:
+		if msg != "unauthorized" {
+			t.Errorf("expected 'unauthorized', got %s", msg)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("connect_error event not received")
+	}
+}
+
+func TestClientInbox(t *testing.T) {
+	server := srv.NewServer()
+	ns := server.Of("/")
+	store := srv.NewMemoryInbox()
+	ns.SetInbox(store, 0, 0)
+	ns.Use(func(s *srv.Socket) error {
+		s.SetUser("alice")
+		return nil
+	})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	ns.ToUser("alice").Persistent().Emit("notify", "while you were away")
+
+	received := make(chan string, 1)
+	clientSocket, err := Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), "/", func(s *Socket) {
+		s.On("notify", func(msg string) {
+			received <- msg
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientSocket.Close()
+
+	select {
+	case msg := <-received:
+		if msg != "while you were away" {
+			t.Errorf("unexpected message %q", msg)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("inbox item not delivered")
+	}
+
+	deadline := time.Now().Add(1 * time.Second)
+	for time.Now().Before(deadline) {
+		if items, _ := store.List("alice"); len(items) == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("inbox item not acknowledged")
+}
+
+func TestClientReliableReconnect(t *testing.T) {
+	server := srv.NewServer()
+	charges := make(chan float64, 10)
+	server.Of("/").On("connection", func(s *srv.Socket) {
+		s.On("charge", func(amount float64) {
+			charges <- amount
+		})
+		s.On("ready", func() {
+			s.EmitReliable("paid", "inv-1")
+		})
+	})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	paid := make(chan string, 10)
+	reconnected := make(chan bool, 1)
+	clientSocket, err := Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), "/", func(s *Socket) {
+		s.On("paid", func(invoice string) {
+			paid <- invoice
+			// Drop the connection before the ack goes out
+			if len(paid) == 1 {
+				s.currentConnNoWait().Close()
+			}
+		})
+		s.On("reconnect", func() {
+			reconnected <- true
+		})
+	}, WithReconnect(10*time.Millisecond, 50*time.Millisecond), WithResume())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientSocket.Close()
+
+	clientSocket.Emit("ready")
+	select {
+	case <-reconnected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("client did not reconnect")
+	}
+
+	// The server resends the unacknowledged message, which the client drops
+	time.Sleep(200 * time.Millisecond)
+	if len(paid) != 1 {
+		t.Errorf("expected paid to be handled once, got %d", len(paid))
+	}
+
+	clientSocket.EmitReliable("charge", 5)
+	select {
+	case amount := <-charges:
+		if amount != 5 {
+			t.Errorf("expected 5, got %v", amount)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("reliable event not received")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for clientSocket.outbox.Len() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if clientSocket.outbox.Len() != 0 {
+		t.Error("expected reliable event to be acknowledged")
+	}
+}
+
+func TestClientAnyListeners(t *testing.T) {
+	server := srv.NewServer()
+	server.Of("/").On("connection", func(s *srv.Socket) {
+		s.On("doc:patch", func(patch string) {
+			s.Emit("doc:patched", patch)
+		})
+	})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	received := make(chan string, 2)
+	sent := make(chan string, 2)
+	c, err := Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), "/", func(s *Socket) {
+		s.On("doc:*", func(event string, patch string) {
+			received <- event + " " + patch
+		})
+		s.OnAnyOutgoing(func(event string, args ...any) {
+			sent <- event
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Emit("doc:patch", "+1")
+	for name, ch := range map[string]chan string{"doc:patch": sent, "doc:patched +1": received} {
+		select {
+		case got := <-ch:
+			if got != name {
+				t.Errorf("expected %q, got %q", name, got)
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatalf("%q not observed", name)
+		}
+	}
+}
+
+func TestClientErrorHandler(t *testing.T) {
+	server := srv.NewServer()
+	answers := make(chan any, 1)
+	server.Of("/").On("connection", func(s *srv.Socket) {
+		s.Emit("task", func(answer any) { answers <- answer })
+		s.On("greet", func(ack func(any)) { ack(1) })
+	})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	failures := make(chan error, 1)
+	c, err := Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), "/", func(s *Socket) {
+		s.On("task", func(ack func(args ...any)) {
+			panic("broken")
+		})
+		s.OnError(func(event string, err error, stack []byte) {
+			var he *HandlerError
+			if errors.As(err, &he) {
+				he.Ack("failed")
+			}
+			failures <- err
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	select {
+	case err := <-failures:
+		var p *PanicError
+		if !errors.As(err, &p) || p.Value != "broken" {
+			t.Errorf("expected the panic, got %v", err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("error handler not called")
+	}
+	select {
+	case answer := <-answers:
+		if answer != "failed" {
+			t.Errorf("expected an error ack, got %v", answer)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("error ack not received")
+	}
+
+	// An ack that does not fit its callback is reported, not a crash
+	c.Emit("greet", func(name string) {})
+	select {
+	case err := <-failures:
+		if !errors.Is(err, ErrListenerArguments) {
+			t.Errorf("expected an argument error, got %v", err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("ack failure not reported")
+	}
+}
+
+func TestEmitWithAck(t *testing.T) {
+	server := srv.NewServer(srv.WithDispatch(srv.Dispatch{Mode: srv.DispatchSerial}))
+	cancelled := make(chan error, 1)
+	server.Of("/").On("connection", func(s *srv.Socket) {
+		s.On("add", func(a, b float64, ack func(float64)) { ack(a + b) })
+		s.On("work", func(ctx context.Context, ack func()) {
+			<-ctx.Done()
+			cancelled <- ctx.Err()
+		})
+	})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	c, err := Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := c.EmitWithAck(context.Background(), "add", 1, 2)
+	if err != nil || len(reply) != 1 || reply[0] != 3.0 {
+		t.Errorf("expected [3], got %v, %v", reply, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.EmitWithAck(ctx, "work"); err != context.DeadlineExceeded {
+		t.Errorf("expected the deadline to pass, got %v", err)
+	}
+	select {
+	case err := <-cancelled:
+		if err != context.Canceled {
+			t.Errorf("expected the server listener to be cancelled, got %v", err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("server listener not cancelled")
+	}
+
+	c.Close()
+	if _, err := c.EmitWithAck(context.Background(), "add", 1, 2); err != ErrNotSent {
+		t.Errorf("expected ErrNotSent after Close, got %v", err)
+	}
+	if c.Context().Err() == nil {
+		t.Error("expected the socket context to be cancelled by Close")
+	}
+}
+
+func TestStream(t *testing.T) {
+	server := srv.NewServer()
+	cancelled := make(chan error, 1)
+	server.Of("/").On("connection", func(s *srv.Socket) {
+		s.On("tokens", func(prompt string, w *srv.StreamWriter) {
+			for _, word := range strings.Fields(prompt) {
+				w.Send(word)
+			}
+			w.Close()
+		})
+		s.On("fail", func(w *srv.StreamWriter) {
+			w.Send("partial")
+			w.Error(errors.New("model overloaded"))
+		})
+		s.On("forever", func(ctx context.Context, w *srv.StreamWriter) {
+			for w.Send("tick") == nil {
+			}
+			cancelled <- ctx.Err()
+		})
+	})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	c, err := Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), "/", nil, WithStreamWindow(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	prompt := strings.Repeat("a b c d e ", 10)
+	st, err := c.Stream(context.Background(), "tokens", prompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var words []string
+	for chunk, err := range st.All() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		words = append(words, chunk.(string))
+	}
+	if strings.Join(words, " ") != strings.Join(strings.Fields(prompt), " ") {
+		t.Errorf("unexpected chunks %v", words)
+	}
+
+	st, _ = c.Stream(context.Background(), "fail")
+	if chunk, err := st.Recv(); chunk != "partial" || err != nil {
+		t.Errorf("expected a partial chunk, got %v, %v", chunk, err)
+	}
+	var streamErr *StreamError
+	if _, err := st.Recv(); !errors.As(err, &streamErr) || streamErr.Message != "model overloaded" {
+		t.Errorf("expected the server error, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	st, _ = c.Stream(ctx, "forever")
+	st.Recv()
+	cancel()
+	if _, err := st.Recv(); err != context.Canceled {
+		t.Errorf("expected the stream to be cancelled, got %v", err)
+	}
+	select {
+	case err := <-cancelled:
+		if err != context.Canceled {
+			t.Errorf("expected the server context to be cancelled, got %v", err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("server stream not cancelled")
+	}
+}
+
+func TestByteStreams(t *testing.T) {
+	server := srv.NewServer()
+	serverSockets := make(chan *srv.Socket, 1)
+	server.Of("/").On("connection", func(s *srv.Socket) {
+		s.AcceptStream(func(stream *srv.ByteStream) {
+			defer stream.Close()
+			if stream.Name() == "upper" {
+				data, _ := io.ReadAll(io.LimitReader(stream, 1<<20))
+				stream.Write(bytes.ToUpper(data))
+			}
+		})
+		serverSockets <- s
+	})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	logs := make(chan string, 1)
+	c, err := Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), "/", func(s *Socket) {
+		s.AcceptStream(func(stream *ByteStream) {
+			data, err := io.ReadAll(stream)
+			if err != nil {
+				logs <- err.Error()
+				return
+			}
+			logs <- stream.Name() + ": " + string(data)
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	s := <-serverSockets
+
+	stream, err := c.OpenStream(context.Background(), "upper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("tunnel "), 1<<20/7) // several windows
+	data = append(data, bytes.Repeat([]byte("x"), 1<<20-len(data))...)
+	go stream.Write(data)
+	got, err := io.ReadAll(stream)
+	if err != nil || !bytes.Equal(got, bytes.ToUpper(data)) {
+		t.Errorf("expected the data in upper case, got %d bytes, %v", len(got), err)
+	}
+
+	out, err := s.OpenStream(context.Background(), "tail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Write([]byte("line 1\nline 2\n"))
+	out.Close()
+	select {
+	case log := <-logs:
+		if log != "tail: line 1\nline 2\n" {
+			t.Errorf("unexpected stream from the server %q", log)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("server stream not received")
+	}
+
+	stuck, err := s.OpenStream(context.Background(), "stuck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stuck.Write([]byte("partial"))
+	s.Disconnect()
+	select {
+	case log := <-logs:
+		if log != io.ErrUnexpectedEOF.Error() {
+			t.Errorf("expected the stream to fail on disconnect, got %q", log)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("stream not closed on disconnect")
+	}
+	if _, err := stuck.Write([]byte("more")); err == nil {
+		t.Error("expected writes to fail after disconnect")
+	}
+}
+
+func TestSendFile(t *testing.T) {
+	server := srv.NewServer()
+	var written atomic.Int32
+	var dropped atomic.Bool
+	var serverReceived atomic.Int64
+	done := make(chan error, 2)
+	dir := t.TempDir()
+	server.Of("/").On("connection", func(s *srv.Socket) {
+		s.On("file:progress", func(name string, received, size int64) {
+			if name == "artifact.bin" {
+				serverReceived.Store(received)
+			}
+		})
+		s.AcceptFiles(srv.FileOptions{
+			Quota: func(s *srv.Socket, info srv.FileInfo) error {
+				if info.Size > 1<<20 {
+					return srv.ErrQuotaExceeded
+				}
+				return nil
+			},
+			Open: func(s *srv.Socket, info srv.FileInfo) (io.WriterAt, error) {
+				return os.Create(dir + "/" + info.Name)
+			},
+			Progress: func(s *srv.Socket, info srv.FileInfo, received int64) {
+				// Drop the connection once, after the third chunk
+				if written.Add(1) == 3 && dropped.CompareAndSwap(false, true) {
+					s.Conn.Close()
+				}
+			},
+			Done: func(s *srv.Socket, info srv.FileInfo, err error) {
+				done <- err
+			},
+		})
+	})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	var progress []int64
+	c, err := Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), "/", func(s *Socket) {
+		s.On("file:progress", func(name string, sent, size int64) {
+			progress = append(progress, sent)
+		})
+	}, WithReconnect(10*time.Millisecond, 50*time.Millisecond), WithResume(), WithChunkSize(64<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	data := make([]byte, 700<<10)
+	rand.Read(data)
+	if err := c.SendFile(context.Background(), "artifact.bin", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("expected the server to verify the file, got %v", err)
+	}
+	received, _ := os.ReadFile(dir + "/artifact.bin")
+	if !bytes.Equal(received, data) {
+		t.Error("received file differs")
+	}
+	if !dropped.Load() || written.Load() != 11 {
+		t.Errorf("expected 11 chunks written once each across a reconnect, got %d", written.Load())
+	}
+	if len(progress) == 0 || progress[len(progress)-1] != int64(len(data)) {
+		t.Errorf("expected progress up to %d, got %v", len(data), progress)
+	}
+	if n := serverReceived.Load(); n != int64(len(data)) {
+		t.Errorf("expected the server to report progress up to %d, got %d", len(data), n)
+	}
+
+	big := make([]byte, 2<<20)
+	err = c.SendFile(context.Background(), "big.bin", bytes.NewReader(big), int64(len(big)))
+	var fileErr *FileError
+	if !errors.As(err, &fileErr) || fileErr.Message != srv.ErrQuotaExceeded.Error() {
+		t.Errorf("expected the quota to refuse the file, got %v", err)
+	}
+
+	// Transfers are private to their client, so another client can neither
+	// pre-claim an ID nor write chunks into the transfer using it
+	other, err := Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	claim := map[string]any{"id": "shared", "name": "claim.bin", "size": 4, "chunkSize": 4, "sha256": "00"}
+	if reply, err := other.fileCall(context.Background(), "file:start", claim); err != nil || reply.Error != "" {
+		t.Fatalf("expected the claim to start, got %+v %v", reply, err)
+	}
+	info := map[string]any{"id": "shared", "name": "mine.bin", "size": 4, "chunkSize": 4, "sha256": "01"}
+	if reply, err := c.fileCall(context.Background(), "file:start", info); err != nil || reply.Error != "" || reply.Next != 0 {
+		t.Errorf("expected the ID to be free for this client, got %+v %v", reply, err)
+	}
+	if reply, err := other.sendChunk(context.Background(), "shared", 0, []byte("evil")); err != nil || reply.Error == "" {
+		t.Errorf("expected the chunk to go to the other client's own transfer and fail its checksum, got %+v %v", reply, err)
+	}
+	if data, _ := os.ReadFile(dir + "/mine.bin"); len(data) != 0 {
+		t.Errorf("expected nothing written to this client's file, got %q", data)
+	}
+}
+
+func TestSendFileNotAccepted(t *testing.T) {
+	httpServer := httptest.NewServer(srv.NewServer())
+	defer httpServer.Close()
+
+	c, err := Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), "/", nil, WithAckTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// Nothing answers "file:start", so SendFile gives up rather than retrying
+	result := make(chan error, 1)
+	go func() {
+		result <- c.SendFile(context.Background(), "lost.bin", bytes.NewReader([]byte("data")), 4)
+	}()
+	select {
+	case err := <-result:
+		var fileErr *FileError
+		if !errors.As(err, &fileErr) {
+			t.Errorf("expected a FileError, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected SendFile to give up")
+	}
+}
+
+type addReq struct {
+	A, B int
+}
+
+type addResp struct {
+	Sum int
+}
+
+type mathService struct {
+	expired chan error
+}
+
+func (mathService) Add(ctx context.Context, req addReq) (addResp, error) {
+	return addResp{Sum: req.A + req.B}, nil
+}
+
+func (m mathService) Slow(ctx context.Context, req addReq) (addResp, error) {
+	<-ctx.Done()
+	m.expired <- ctx.Err()
+	return addResp{}, ctx.Err()
+}
+
+func (mathService) Sleep(ctx context.Context, req addReq) (addResp, error) {
+	select {
+	case <-time.After(time.Duration(req.A) * time.Millisecond):
+		return addResp{}, nil
+	case <-ctx.Done():
+		return addResp{}, ctx.Err()
+	}
+}
+
+func (mathService) Reset(ctx context.Context, req addReq) (addResp, error) {
+	return addResp{}, nil
+}
+
+func TestCall(t *testing.T) {
+	server := srv.NewServer(srv.WithAckTimeout(100*time.Millisecond), srv.WithCallTimeout(time.Second))
+	expired := make(chan error, 1)
+	err := server.Of("/").RegisterService("math", mathService{expired}, func(next srv.ServiceHandler) srv.ServiceHandler {
+		return func(ctx context.Context, call *srv.Call) (any, error) {
+			if call.Method == "math.Reset" {
+				return nil, &srv.RPCError{Code: srv.CodePermissionDenied, Message: "admins only"}
+			}
+			return next(ctx, call)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Of("/").RegisterService("empty", struct{}{}); err == nil {
+		t.Error("expected a service without methods to be refused")
+	}
+	if err := server.Of("/").RegisterService("shapeless", time.Time{}); err == nil {
+		t.Error("expected a service without methods of the right form to be refused")
+	}
+	for _, svc := range []any{nil, (*mathService)(nil)} {
+		if err := server.Of("/").RegisterService("nil", svc); err == nil {
+			t.Errorf("expected a nil service %T to be refused", svc)
+		}
+	}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	c, err := Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var resp addResp
+	if err := c.Call(context.Background(), "math.Add", addReq{A: 2, B: 3}, &resp); err != nil || resp.Sum != 5 {
+		t.Errorf("expected a sum of 5, got %d, %v", resp.Sum, err)
+	}
+
+	for _, tc := range []struct {
+		method string
+		req    any
+		code   RPCCode
+	}{
+		{"math.Sub", addReq{}, CodeNotFound},
+		{"math.Add", "two and three", CodeInvalidArgument},
+		{"math.Reset", addReq{}, CodePermissionDenied},
+	} {
+		var rpcErr *RPCError
+		if err := c.Call(context.Background(), tc.method, tc.req, nil); !errors.As(err, &rpcErr) || rpcErr.Code != tc.code {
+			t.Errorf("%s: expected %s, got %v", tc.method, tc.code, err)
+		}
+	}
+
+	// Calls are not bound by the ack timeout
+	if err := c.Call(context.Background(), "math.Sleep", addReq{A: 300}, nil); err != nil {
+		t.Errorf("expected a call longer than the ack timeout to succeed, got %v", err)
+	}
+
+	// The server's call timeout caps a later deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	var rpcErr *RPCError
+	if err := c.Call(ctx, "math.Sleep", addReq{A: 3000}, nil); !errors.As(err, &rpcErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the server to end the call, got %v", err)
+	} else if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the call timeout to end the call, took %v", elapsed)
+	}
+
+	// The deadline is passed to the server
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := c.Call(ctx, "math.Slow", addReq{}, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+	select {
+	case err := <-expired:
+		// The client's cancellation may arrive just before the deadline
+		if err != context.DeadlineExceeded && err != context.Canceled {
+			t.Errorf("expected the method's context to expire, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected the method's context to expire with the deadline")
+	}
+}
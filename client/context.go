@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/givensuman/go-sockets/internal/emitter"
+)
+
+// cancelEvent tells the server that an acknowledgment is no longer waited
+// for, so that the context of the event's listeners is cancelled.
+const cancelEvent = "ack:cancel"
+
+// DefaultAckTimeout is how long acknowledgments are waited for by default.
+const DefaultAckTimeout = 10 * time.Second
+
+// WithAckTimeout sets how long acknowledgments are waited for by Emit, by
+// SendFile for each step of a transfer and by Call without a deadline, and
+// how long the listeners of an event the server wants acknowledged may take
+// before their context is cancelled.
+func WithAckTimeout(d time.Duration) Option {
+	return func(s *Socket) {
+		s.ackTimeout = d
+	}
+}
+
+// Errors returned by EmitWithAck.
+var (
+	// ErrNotSent is returned when the socket is closed or its write buffer is full.
+	ErrNotSent = errors.New("event not sent")
+	// ErrClosed is returned when the socket is closed before the acknowledgment arrives.
+	ErrClosed = errors.New("socket closed")
+	// ErrConnectionLost is returned when the connection drops before the
+	// acknowledgment arrives. The server may or may not have received the event.
+	ErrConnectionLost = errors.New("connection lost")
+)
+
+// Context returns a context cancelled once the socket is closed.
+//
+// Listeners whose first parameter is a context.Context receive a context
+// derived from it. When the server asked for an acknowledgment, that context
+// is also cancelled once the listener acknowledges or after 10 seconds.
+func (s *Socket) Context() context.Context {
+	return s.ctx
+}
+
+// EmitWithAck sends an event to the server and waits for its acknowledgment,
+// returning the acknowledgment's arguments. While the socket reconnects, it
+// first waits for the connection. If ctx is done first, the server is told to
+// cancel the context of the event's listeners and ctx.Err() is returned.
+func (s *Socket) EmitWithAck(ctx context.Context, event string, args ...any) ([]any, error) {
+	lost, err := s.connected(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply := make(chan []any, 1)
+	id := atomic.AddUint64(&s.ackCounter, 1)
+	s.ackMap.Store(id, emitter.NewCallback(event, func(args ...any) { reply <- args }))
+
+	if !s.send(s.eventPacket(event, args, &id)) {
+		s.ackMap.Delete(id)
+		return nil, ErrNotSent
+	}
+
+	select {
+	case args := <-reply:
+		return args, nil
+	case <-ctx.Done():
+		if _, waiting := s.ackMap.LoadAndDelete(id); waiting {
+			s.emit(cancelEvent, id)
+		}
+		return nil, ctx.Err()
+	case <-s.done:
+		s.ackMap.Delete(id)
+		return nil, ErrClosed
+	case <-lost:
+		s.ackMap.Delete(id)
+		return nil, ErrConnectionLost
+	}
+}
+
+// connected waits until the socket has a connection, and returns a channel
+// closed once that connection is lost.
+func (s *Socket) connected(ctx context.Context) (<-chan struct{}, error) {
+	for {
+		s.connMu.Lock()
+		conn, ready, lost := s.conn, s.ready, s.lost
+		s.connMu.Unlock()
+
+		if conn != nil {
+			return lost, nil
+		}
+		select {
+		case <-ready:
+		case <-s.done:
+			return nil, ErrClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// eventContext returns the context of the listeners of an event, which
+// expires with the ack timeout if the server asked for an acknowledgment.
+func (s *Socket) eventContext(ack bool) (context.Context, context.CancelFunc) {
+	if !ack {
+		return s.ctx, func() {}
+	}
+	return context.WithTimeout(s.ctx, s.ackTimeout)
+}
@@ -0,0 +1,43 @@
+package client
+
+import "github.com/givensuman/go-sockets/internal/dispatch"
+
+// DispatchMode selects where the listeners of incoming events run.
+type DispatchMode = dispatch.Mode
+
+// Dispatch modes.
+const (
+	// DispatchInline runs listeners on the socket's reader, the default. A slow
+	// listener delays every later packet, acknowledgments included.
+	DispatchInline = dispatch.Inline
+	// DispatchSerial runs listeners in order on a goroutine of their own.
+	DispatchSerial = dispatch.Serial
+	// DispatchPool is accepted for symmetry with the server. As a socket's
+	// listeners run in order, it is the same as DispatchSerial on a client.
+	DispatchPool = dispatch.Pool
+)
+
+// Dispatch configures how the listeners of incoming events run. Workers is
+// the pool size in DispatchPool mode. QueueSize bounds the events waiting,
+// 256 by default; the socket stops reading while its queue is full.
+// Acknowledgments are handled as they arrive, whatever the mode.
+type Dispatch = dispatch.Config
+
+// DispatchStats reports the number of events waiting for their listeners and
+// how long the listeners took.
+type DispatchStats = dispatch.Stats
+
+// WithDispatch sets how the listeners of incoming events run.
+func WithDispatch(cfg Dispatch) Option {
+	if cfg.Mode == DispatchPool {
+		cfg.Mode = DispatchSerial
+	}
+	return func(s *Socket) {
+		s.dispatcher = dispatch.New(cfg)
+	}
+}
+
+// DispatchStats returns statistics on the listeners of incoming events.
+func (s *Socket) DispatchStats() DispatchStats {
+	return s.dispatcher.Stats()
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"log"
+
+	"github.com/givensuman/go-sockets/internal/emitter"
+)
+
+// ErrorHandler receives the failures of event listeners: a *PanicError, whose
+// stack is also passed, or an error wrapping ErrListenerArguments. Failures of
+// listeners of server events and of acknowledgment callbacks, reported under the
+// event they acknowledge, are wrapped in a *HandlerError.
+type ErrorHandler func(event string, err error, stack []byte)
+
+// ErrListenerArguments is reported when the arguments of an event do not fit
+// the parameters of a listener, which is then not called.
+var ErrListenerArguments = emitter.ErrArguments
+
+// PanicError is reported when a listener panics.
+type PanicError = emitter.PanicError
+
+// HandlerError is the failure of a listener of an event received from the server.
+type HandlerError struct {
+	Err error
+	ack func(args ...any)
+}
+
+func (e *HandlerError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+// Ack acknowledges the event with args, typically an error description, if
+// the server asked for an acknowledgment. It reports whether it did.
+func (e *HandlerError) Ack(args ...any) bool {
+	if e.ack == nil {
+		return false
+	}
+	e.ack(args...)
+	return true
+}
+
+// OnError sets the handler receiving the failures of the socket's listeners.
+// Without a handler, failures are logged.
+func (s *Socket) OnError(fn ErrorHandler) {
+	s.errorHandler.Store(&fn)
+}
+
+// reportError passes a listener failure to the error handler, or logs it.
+func (s *Socket) reportError(event string, err error, stack []byte) {
+	if fn := s.errorHandler.Load(); fn != nil {
+		(*fn)(event, err, stack)
+		return
+	}
+	log.Printf("listener error on %q: %v\n%s", event, err, stack)
+}
+
+// callAck calls an acknowledgment callback with the peer's arguments,
+// reporting a panic or arguments that do not fit instead of crashing the
+// read loop.
+func (s *Socket) callAck(callback *emitter.Callback, args []any) {
+	if err := callback.Call(s.ctx, args); err != nil {
+		var stack []byte
+		if p, ok := err.(*PanicError); ok {
+			stack = p.Stack
+		}
+		s.reportError(callback.Event, &HandlerError{Err: err}, stack)
+	}
+}
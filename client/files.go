@@ -0,0 +1,181 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultChunkSize is the size of the chunks SendFile sends by default.
+const DefaultChunkSize = 256 << 10
+
+// chunkRetries is how many times a chunk the server rejected is sent again,
+// and how many times in a row SendFile resumes after a failed call.
+const chunkRetries = 3
+
+// WithChunkSize sets the size of the chunks SendFile sends. The server
+// refuses chunks larger than its limit, 1MB by default.
+func WithChunkSize(n int) Option {
+	return func(s *Socket) {
+		s.chunkSize = max(n, 1)
+	}
+}
+
+// FileError is a file transfer refused or failed by the server.
+type FileError struct {
+	Message string
+}
+
+func (e *FileError) Error() string {
+	return e.Message
+}
+
+// fileReply is the server's acknowledgment of a file event.
+type fileReply struct {
+	Next  int64  `json:"next"`
+	Error string `json:"error"`
+	Retry bool   `json:"retry"`
+}
+
+// SendFile sends size bytes of file to the server under name, for a socket
+// accepting files with server.Socket.AcceptFiles, and returns once the server
+// has checked the whole file against its SHA-256.
+//
+// The file is sent in chunks, each with its own SHA-256, one at a time over
+// byte streams, so the server's AcceptFiles takes the place of any function
+// set with server.Socket.AcceptStream for them. After each chunk is
+// acknowledged the socket emits "file:progress" with the name, the bytes sent
+// so far and size. If the connection drops, SendFile waits for the socket to
+// reconnect and resume its session, see WithReconnect and WithResume, and
+// continues from the last chunk acknowledged; it gives up after a few failed
+// attempts in a row. It returns a *FileError if the server refuses the file,
+// or never answers because the socket does not accept files.
+func (s *Socket) SendFile(ctx context.Context, name string, file io.ReaderAt, size int64) error {
+	sum := sha256.New()
+	if _, err := io.Copy(sum, io.NewSectionReader(file, 0, size)); err != nil {
+		return err
+	}
+
+	chunkSize := int64(s.chunkSize)
+	info := map[string]any{
+		"id":        uuid.New().String(),
+		"name":      name,
+		"size":      size,
+		"chunkSize": chunkSize,
+		"sha256":    hex.EncodeToString(sum.Sum(nil)),
+	}
+	chunks := (size + chunkSize - 1) / chunkSize
+	buf := make([]byte, chunkSize)
+
+	next := int64(-1) // unknown until the transfer is started or resumed
+	retries := 0
+	failures := 0 // failed calls in a row
+	started := false
+	for next < chunks {
+		var reply fileReply
+		var err error
+		if next < 0 {
+			reply, err = s.fileCall(ctx, "file:start", info)
+		} else {
+			chunk := buf[:min(chunkSize, size-next*chunkSize)]
+			if _, err := file.ReadAt(chunk, next*chunkSize); err != nil && err != io.EOF {
+				return err
+			}
+			reply, err = s.sendChunk(ctx, info["id"].(string), next, chunk)
+		}
+
+		if err != nil {
+			// The connection may have dropped or its write buffer be full:
+			// wait a moment, then ask the server where to resume
+			switch {
+			case ctx.Err() != nil:
+				return ctx.Err()
+			case errors.Is(err, ErrClosed), errors.Is(err, ErrByteStreamRefused):
+				return err
+			case !started && errors.Is(err, context.DeadlineExceeded):
+				// A socket not accepting files never answers "file:start"
+				return &FileError{Message: "no answer to file:start; the server may not accept files"}
+			case failures == chunkRetries:
+				return err
+			}
+			failures++
+			select {
+			case <-time.After(100 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-s.done:
+				return ErrClosed
+			}
+			next = -1
+			continue
+		}
+		failures = 0
+		started = true
+		if reply.Error != "" {
+			if !reply.Retry || retries == chunkRetries {
+				return &FileError{Message: reply.Error}
+			}
+			retries++
+		}
+
+		if reply.Next > next {
+			retries = 0
+			if next >= 0 {
+				s.EventEmitter.Emit("file:progress", name, min(reply.Next*chunkSize, size), size)
+			}
+		}
+		next = reply.Next
+	}
+	return nil
+}
+
+// sendChunk sends a chunk on a byte stream of its own and returns the
+// server's reply, read from the same stream.
+func (s *Socket) sendChunk(ctx context.Context, id string, index int64, chunk []byte) (fileReply, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.ackTimeout)
+	defer cancel()
+
+	var reply fileReply
+	digest := sha256.Sum256(chunk)
+	name := "file:" + id + ":" + strconv.FormatInt(index, 10) + ":" + hex.EncodeToString(digest[:])
+	st, err := s.OpenStream(ctx, name)
+	if err != nil {
+		return reply, err
+	}
+	defer st.Close()
+	// Streams do not take a context, so closing it ends a write or read in progress
+	stop := context.AfterFunc(ctx, func() { st.Close() })
+	defer stop()
+
+	if _, err := st.Write(chunk); err != nil {
+		return reply, err
+	}
+	if err := json.NewDecoder(st).Decode(&reply); err != nil {
+		return reply, err
+	}
+	return reply, nil
+}
+
+// fileCall sends a file event and returns the server's reply.
+func (s *Socket) fileCall(ctx context.Context, event string, args ...any) (fileReply, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.ackTimeout)
+	defer cancel()
+
+	var reply fileReply
+	ack, err := s.EmitWithAck(ctx, event, args...)
+	if err != nil {
+		return reply, err
+	}
+	if len(ack) > 0 {
+		data, _ := json.Marshal(ack[0])
+		json.Unmarshal(data, &reply)
+	}
+	return reply, nil
+}
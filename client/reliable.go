@@ -0,0 +1,94 @@
+package client
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/google/uuid"
+)
+
+// reliableEvent is the envelope carrying at-least-once messages as
+// ["reliable", messageID, event, args...]. The receiver acknowledges the
+// envelope once its listeners have run and drops IDs it has already seen.
+const reliableEvent = "reliable"
+
+// sessionEvent carries the token the server issued for resuming the socket's
+// session, which is sent back as the "sid" query parameter on reconnect.
+const sessionEvent = "reliable:session"
+
+// WithResume makes the socket ask the server for a session, which it resumes
+// after reconnecting, see WithReconnect. Reliable messages the server had not
+// yet delivered are then resent, and SendFile continues its transfers. The
+// server keeps the session for a while after the connection drops.
+func WithResume() Option {
+	return func(s *Socket) {
+		s.resume = true
+	}
+}
+
+// EmitReliable sends an event with at-least-once delivery and returns its message ID.
+// The message is resent with exponential backoff until the server acknowledges
+// it, including after a reconnect (see WithReconnect). The server drops
+// duplicates within a session, so listeners run once per message as long as
+// the socket resumes its session (see WithResume). Listeners of reliable events
+// cannot take an acknowledgment callback.
+func (s *Socket) EmitReliable(event string, args ...any) string {
+	id := uuid.New().String()
+	s.outbox.Add(id, append([]any{event}, args...))
+	return id
+}
+
+// receiveReliable handles an incoming reliable envelope, emitting the wrapped
+// event unless it was seen before. The envelope is acknowledged and its ID
+// recorded once the listeners have run without failing, so the sender resends
+// it otherwise.
+func (s *Socket) receiveReliable(ctx context.Context, ack func(args ...any), args []any) {
+	if len(args) < 2 {
+		return
+	}
+	id, ok := args[0].(string)
+	if !ok {
+		return
+	}
+	event, ok := args[1].(string)
+	if !ok {
+		return
+	}
+
+	if !s.seen.Has(id) {
+		failed := false
+		s.EventEmitter.EmitContext(ctx, event, func(event string, err error, stack []byte) {
+			failed = true
+			s.reportError(event, &HandlerError{Err: err}, stack)
+		}, args[2:]...)
+		if failed {
+			return
+		}
+		s.seen.Add(id)
+	}
+	if ack != nil {
+		ack()
+	}
+}
+
+// resumeWith makes reconnections resume the session of the token in args.
+func (s *Socket) resumeWith(args []any) {
+	if len(args) == 0 {
+		return
+	}
+	token, ok := args[0].(string)
+	if !ok {
+		return
+	}
+
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	u, err := url.Parse(s.url)
+	if err != nil {
+		return
+	}
+	query := u.Query()
+	query.Set("sid", token)
+	u.RawQuery = query.Encode()
+	s.url = u.String()
+}
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/givensuman/go-sockets/internal/rpc"
+)
+
+// RPCError is the error of a call refused or failed by the server.
+type RPCError = rpc.Error
+
+// RPCCode classifies an RPCError.
+type RPCCode = rpc.Code
+
+// RPC error codes.
+const (
+	CodeInvalidArgument  = rpc.InvalidArgument
+	CodeNotFound         = rpc.NotFound
+	CodePermissionDenied = rpc.PermissionDenied
+	CodeDeadlineExceeded = rpc.DeadlineExceeded
+	CodeCanceled         = rpc.Canceled
+	CodeInternal         = rpc.Internal
+)
+
+// Call calls a method of a service registered with
+// server.Namespace.RegisterService, such as "math.Add", and decodes its
+// response into resp, which may be nil. The request and response are encoded
+// as JSON.
+//
+// The deadline of ctx, or the ack timeout if ctx has none (see
+// WithAckTimeout), is passed to the server, which cancels the method's context once it or the
+// server's call timeout passes. Call returns an *RPCError if the server refuses the call or the
+// method fails, and the errors of EmitWithAck otherwise. An expired or
+// cancelled call matches context.DeadlineExceeded or context.Canceled with
+// errors.Is, whether it expired on the client or the server.
+func (s *Socket) Call(ctx context.Context, method string, req, resp any) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.ackTimeout)
+		defer cancel()
+	}
+
+	deadline, _ := ctx.Deadline()
+	meta := map[string]any{"timeout": time.Until(deadline).Milliseconds()}
+	reply, err := s.EmitWithAck(ctx, method, req, meta)
+	if err != nil {
+		return err
+	}
+
+	if len(reply) > 1 && reply[1] != nil {
+		rpcErr := &RPCError{}
+		data, _ := json.Marshal(reply[1])
+		if err := json.Unmarshal(data, rpcErr); err != nil {
+			return err
+		}
+		return rpcErr
+	}
+	if resp == nil || len(reply) == 0 {
+		return nil
+	}
+	data, _ := json.Marshal(reply[0])
+	return json.Unmarshal(data, resp)
+}
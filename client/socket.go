@@ -1,39 +1,79 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
+	"io"
 	"log"
+	"net/http"
 	"reflect"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/givensuman/go-sockets"
+	"github.com/givensuman/go-sockets/internal/dispatch"
 	"github.com/givensuman/go-sockets/internal/emitter"
+	"github.com/givensuman/go-sockets/internal/mux"
 	"github.com/givensuman/go-sockets/internal/parser"
+	"github.com/givensuman/go-sockets/internal/reliable"
 	"github.com/gorilla/websocket"
 )
 
+// Subscription is a listener registered with On or Once on a Socket.
+// Its Unsubscribe method removes the listener.
+type Subscription = emitter.Subscription
+
 // Socket represents a client-side WebSocket connection to a Socket.IO server.
 // It embeds EventEmitter for event handling and manages acknowledgments.
 type Socket struct {
 	emitter.EventEmitter
-	Conn       *websocket.Conn
-	writeChan  chan sockets.Packet
+	Conn       *websocket.Conn // the current connection, replaced when reconnecting
+	writeChan  chan outgoing
 	closeOnce  sync.Once
+	closeMu    sync.RWMutex
+	closed     bool
+	done       chan struct{}
 	Namespace  string
 	ackCounter uint64
-	ackMap     sync.Map // uint64 -> reflect.Value
-}
+	ackMap     sync.Map // uint64 -> *emitter.Callback
 
-func (s *Socket) readLoop() {
-	defer s.Close()
+	url          string
+	reconnectMin time.Duration
+	reconnectMax time.Duration
+	connMu       sync.Mutex
+	conn         *websocket.Conn // nil while reconnecting
+	ready        chan struct{}   // closed once a lost connection is replaced
+	lost         chan struct{}   // closed once the current connection is lost
+	header       http.Header
+	resume       bool // ask the server for a session to resume after reconnecting
+	outbox       *reliable.Outbox
+	seen         *reliable.Window
+	relay        RelayFunc
+	outgoing     emitter.EventEmitter // OnAnyOutgoing listeners
+	dispatcher   *dispatch.Dispatcher
+	handlers     *dispatch.Queue // runs the listeners of incoming events
+	errorHandler atomic.Pointer[ErrorHandler]
+	ctx          context.Context // cancelled by Close
+	cancel       context.CancelFunc
+	streams      sync.Map // stream ID -> *Stream
+	streamWindow int
+	mux          *mux.Session // byte streams
+	chunkSize    int          // of the files sent with SendFile
+	ackTimeout   time.Duration
+}
 
+func (s *Socket) readLoop(conn *websocket.Conn) {
 	for {
-		_, data, err := s.Conn.ReadMessage()
+		messageType, data, err := conn.ReadMessage()
 		if err != nil {
+			s.connectionLost(conn)
 			return
 		}
+		if messageType == websocket.BinaryMessage {
+			s.mux.Receive(data)
+			continue
+		}
 
 		packet, err := parser.Decode(data)
 		if err != nil {
@@ -53,79 +93,208 @@ func (s *Socket) readLoop() {
 				continue
 			}
 
-			if packet.ID != nil {
-				ackFunc := func(args ...any) {
-					ackData, _ := json.Marshal(args)
-					ackPacket := sockets.Packet{
-						Type:      sockets.Ack,
-						Data:      json.RawMessage(ackData),
-						Namespace: packet.Namespace,
-						ID:        packet.ID,
-					}
-
-					select {
-					case s.writeChan <- ackPacket:
-					default:
-						s.Close()
-					}
-				}
-
-				eventArgs = append(eventArgs, ackFunc)
+			// Stream replies are buffered here rather than dispatched, so that
+			// Recv sees them in order whatever the dispatch mode
+			if s.receiveStream(*eventName, eventArgs) {
+				continue
+			}
+			if *eventName == sessionEvent {
+				s.resumeWith(eventArgs)
+				continue
 			}
 
-			s.EventEmitter.Emit(*eventName, eventArgs...)
+			s.handlers.Run(func() {
+				s.handleEvent(packet, *eventName, eventArgs)
+			})
 
 		case sockets.Ack:
+			// Acks are handled here rather than dispatched, so that they are
+			// not held up by slow event handlers
 			if packet.ID != nil {
 				if callback, ok := s.ackMap.Load(*packet.ID); ok {
 					s.ackMap.Delete(*packet.ID)
 
 					var ackArgs []any
 					json.Unmarshal(packet.Data, &ackArgs)
-					var callArgs []reflect.Value
-
-					for _, arg := range ackArgs {
-						callArgs = append(callArgs, reflect.ValueOf(arg))
-					}
-					
-					callback.(reflect.Value).Call(callArgs)
+					s.callAck(callback.(*emitter.Callback), ackArgs)
 				}
 			}
 
+		case sockets.Error:
+			var connectErr struct {
+				Message string `json:"message"`
+			}
+			json.Unmarshal(packet.Data, &connectErr)
+			s.handlers.Run(func() {
+				s.EventEmitter.Emit("connect_error", connectErr.Message)
+			})
+
 		case sockets.Disconnect:
-			s.EventEmitter.Emit("disconnect", "server request")
+			s.handlers.Run(func() {
+				s.EventEmitter.Emit("disconnect", "server request")
+			})
+			s.Close()
 			return
 		}
 	}
 }
 
+// handleEvent runs the listeners of an event received from the server.
+func (s *Socket) handleEvent(packet sockets.Packet, event string, args []any) {
+	var ack func(args ...any)
+	if packet.ID != nil {
+		ack = s.ackFunc(packet)
+	}
+
+	if s.relay != nil {
+		s.relay(event, args, ack)
+		return
+	}
+
+	ctx, cancel := s.eventContext(ack != nil)
+	if ack != nil {
+		reply := ack
+		ack = func(args ...any) {
+			cancel()
+			reply(args...)
+		}
+	}
+
+	if event == "inbox:deliver" {
+		s.deliverInbox(packet.Data)
+		return
+	}
+
+	if event == reliableEvent {
+		s.receiveReliable(ctx, ack, args)
+		return
+	}
+
+	report := func(event string, err error, stack []byte) {
+		s.reportError(event, &HandlerError{Err: err, ack: ack}, stack)
+	}
+	if ack != nil {
+		args = append(args, ack)
+	}
+
+	s.EventEmitter.EmitContext(ctx, event, report, args...)
+}
+
+// ackFunc returns a function that answers packet with an ACK carrying its arguments.
+func (s *Socket) ackFunc(packet sockets.Packet) func(args ...any) {
+	return func(args ...any) {
+		ackData, _ := json.Marshal(args)
+		ackPacket := sockets.Packet{
+			Type:      sockets.Ack,
+			Data:      json.RawMessage(ackData),
+			Namespace: packet.Namespace,
+			ID:        packet.ID,
+		}
+
+		if !s.send(ackPacket) {
+			s.Close()
+		}
+	}
+}
+
+// deliverInbox emits the items the server kept in the user's inbox and
+// acknowledges them once every listener has run. An item may arrive both live
+// and with the inbox sent on connect, so items already emitted are skipped.
+func (s *Socket) deliverInbox(data json.RawMessage) {
+	var eventData []json.RawMessage
+	if err := json.Unmarshal(data, &eventData); err != nil || len(eventData) < 2 {
+		return
+	}
+
+	var items []struct {
+		ID    string          `json:"id"`
+		Event string          `json:"event"`
+		Args  json.RawMessage `json:"args"`
+	}
+	if err := json.Unmarshal(eventData[1], &items); err != nil {
+		log.Println("unmarshal error:", err)
+		return
+	}
+
+	ids := make([]any, 0, len(items))
+	for _, item := range items {
+		if !s.seen.Seen("inbox:" + item.ID) {
+			var args []any
+			json.Unmarshal(item.Args, &args)
+			s.EventEmitter.Emit(item.Event, args...)
+		}
+		ids = append(ids, item.ID)
+	}
+	s.Emit("inbox:ack", ids...)
+}
+
 func (s *Socket) writeLoop() {
-	for packet := range s.writeChan {
-		data := parser.Encode(packet)
+	for out := range s.writeChan {
+		conn := s.currentConn()
+		if conn == nil {
+			return
+		}
 
-		err := s.Conn.WriteMessage(websocket.TextMessage, data)
+		var err error
+		if out.frame != nil {
+			err = conn.WriteMessage(websocket.BinaryMessage, out.frame)
+		} else {
+			s.sent(out.packet)
+			err = conn.WriteMessage(websocket.TextMessage, parser.Encode(out.packet))
+		}
 		if err != nil {
 			log.Println("write error:", err)
-			return
+			if s.reconnectMax == 0 {
+				return
+			}
+			// Let the read loop notice and reconnect
+			conn.Close()
 		}
 	}
 }
 
+// OnAnyOutgoing registers a callback called with the name and arguments of
+// every event written to the server, including built-in events, as they were
+// encoded. It runs on the socket's writer before each write.
+func (s *Socket) OnAnyOutgoing(callback func(event string, args ...any)) *Subscription {
+	return s.outgoing.OnAny(callback)
+}
+
+// sent hands an event packet about to be written to the OnAnyOutgoing listeners.
+func (s *Socket) sent(packet sockets.Packet) {
+	if packet.Type != sockets.Event || s.outgoing.AnyListenerCount() == 0 {
+		return
+	}
+	event, ok := packet.GetEventName()
+	if !ok {
+		return
+	}
+	args, _ := packet.GetEventArgs()
+	s.outgoing.Emit(*event, args...)
+}
+
 // Emit sends an event to the server with optional arguments.
 // If the last argument is a function, it sets up an acknowledgment callback.
+// While reconnecting, events that do not fit in the write buffer are dropped.
 func (s *Socket) Emit(event string, args ...any) {
+	if !s.emit(event, args...) && s.currentConnNoWait() != nil {
+		s.Close()
+	}
+}
+
+// emit is Emit without closing the socket when its write buffer is full.
+func (s *Socket) emit(event string, args ...any) bool {
 	var ackID *uint64
 
 	if len(args) > 0 {
 		lastArg := args[len(args)-1]
 
-		if reflect.TypeOf(lastArg).Kind() == reflect.Func {
+		if lastArg != nil && reflect.TypeOf(lastArg).Kind() == reflect.Func {
 			id := atomic.AddUint64(&s.ackCounter, 1)
 			ackID = &id
-			s.ackMap.Store(id, reflect.ValueOf(lastArg))
+			s.ackMap.Store(id, emitter.NewCallback(event, lastArg))
 
-			// Timeout after 10 seconds
-			time.AfterFunc(10*time.Second, func() {
+			time.AfterFunc(s.ackTimeout, func() {
 				s.ackMap.Delete(id)
 			})
 
@@ -133,38 +302,119 @@ func (s *Socket) Emit(event string, args ...any) {
 		}
 	}
 
+	return s.send(s.eventPacket(event, args, ackID))
+}
+
+// eventPacket encodes an event with an optional ack ID.
+func (s *Socket) eventPacket(event string, args []any, ackID *uint64) sockets.Packet {
 	eventData := append([]any{event}, args...)
 	data, _ := json.Marshal(eventData)
 
-	packet := sockets.Packet{
+	return sockets.Packet{
 		Type:      sockets.Event,
 		Data:      json.RawMessage(data),
 		Namespace: s.Namespace,
 		ID:        ackID,
 	}
+}
+
+// outgoing is a packet or byte stream frame queued for the write loop.
+type outgoing struct {
+	packet sockets.Packet
+	// frame is a byte stream frame, written as a binary message instead of packet.
+	frame []byte
+}
+
+// send queues packet for the write loop.
+// It returns false if the socket is closed or its write buffer is full.
+func (s *Socket) send(packet sockets.Packet) bool {
+	s.closeMu.RLock()
+	defer s.closeMu.RUnlock()
+
+	if s.closed {
+		return false
+	}
 
 	select {
-	case s.writeChan <- packet:
-		return
+	case s.writeChan <- outgoing{packet: packet}:
+		return true
 	default:
-		s.Close()
+		return false
+	}
+}
+
+// sendWait is send, waiting while the write buffer is full until ctx is done
+// or the socket is closed.
+func (s *Socket) sendWait(ctx context.Context, packet sockets.Packet) error {
+	return s.queueWait(ctx, outgoing{packet: packet})
+}
+
+// queueWait is sendWait for any queued item.
+func (s *Socket) queueWait(ctx context.Context, out outgoing) error {
+	s.closeMu.RLock()
+	defer s.closeMu.RUnlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	select {
+	case s.writeChan <- out:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.ctx.Done():
+		return ErrClosed
 	}
 }
 
+// RelayFunc receives the events relayed by a socket. Ack is nil unless the
+// server asked for an acknowledgment.
+type RelayFunc func(event string, args []any, ack func(args ...any))
+
+// Relay hands every event the server sends to fn instead of the socket's
+// listeners, including inbox deliveries and reliable envelopes, so that a proxy
+// can pass them on unchanged. It must be called from the onConnect callback
+// given to Connect, before the first packet is read.
+func (s *Socket) Relay(fn RelayFunc) {
+	s.relay = fn
+}
+
 // Join sends a "join" event to the server to join the specified room.
 func (s *Socket) Join(room string) {
 	s.Emit("join", room)
 }
 
+// JoinWithKey sends a "join" event carrying the password or token required
+// by the room's join policy. A denied join is reported in a "join_error" event.
+func (s *Socket) JoinWithKey(room, key string) {
+	s.Emit("join", room, key)
+}
+
 // Leave sends a "leave" event to the server to leave the specified room.
 func (s *Socket) Leave(room string) {
 	s.Emit("leave", room)
 }
 
 // Close closes the WebSocket connection and cleans up resources.
+// It stops any reconnection in progress.
 func (s *Socket) Close() {
 	s.closeOnce.Do(func() {
-		s.Conn.Close()
+		// Cancel first, releasing writers waiting on a full write buffer
+		s.cancel()
+		s.closeMu.Lock()
+		s.closed = true
+		close(s.done)
 		close(s.writeChan)
+		s.closeMu.Unlock()
+
+		if conn := s.currentConnNoWait(); conn != nil {
+			conn.Close()
+		}
+		s.outbox.Close()
+		s.handlers.Close()
+		s.dispatcher.Close()
+		s.endStreams()
+		s.mux.Reset(io.ErrUnexpectedEOF)
 	})
 }
@@ -0,0 +1,206 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"iter"
+	"sync"
+	"sync/atomic"
+)
+
+// Stream messages; see the server package for the protocol.
+const (
+	streamOpen   = "stream:open"
+	streamChunk  = "stream:chunk"
+	streamEnd    = "stream:end"
+	streamError  = "stream:error"
+	streamCredit = "stream:credit"
+	streamCancel = "stream:cancel"
+)
+
+// DefaultStreamWindow is how many chunks of a stream the server may send
+// ahead of Recv by default.
+const DefaultStreamWindow = 16
+
+// WithStreamWindow sets how many chunks of a stream the server may send ahead
+// of Recv. The server waits for Recv once that many are buffered.
+func WithStreamWindow(n int) Option {
+	return func(s *Socket) {
+		s.streamWindow = max(n, 1)
+	}
+}
+
+// StreamError is a stream ended by the server with an error.
+type StreamError struct {
+	Message string
+}
+
+func (e *StreamError) Error() string {
+	return e.Message
+}
+
+// Stream receives the partial replies to a request sent with Socket.Stream.
+type Stream struct {
+	socket *Socket
+	id     uint64
+	ctx    context.Context
+	chunks chan any // closed when the stream ends, after err is set
+	err    error
+
+	mu       sync.Mutex // guards sending on and closing chunks
+	ended    bool
+	received int // chunks received since credit was last granted
+}
+
+// Stream sends an event opening a stream of replies, which a server listener
+// taking a *server.StreamWriter answers. The stream is cancelled on the
+// server when ctx is done or Close is called.
+func (s *Socket) Stream(ctx context.Context, event string, args ...any) (*Stream, error) {
+	st := &Stream{
+		socket: s,
+		id:     atomic.AddUint64(&s.ackCounter, 1),
+		ctx:    ctx,
+		chunks: make(chan any, s.streamWindow),
+	}
+	s.streams.Store(st.id, st)
+
+	if !s.emit(streamOpen, append([]any{st.id, s.streamWindow, event}, args...)...) {
+		s.streams.Delete(st.id)
+		return nil, ErrNotSent
+	}
+	return st, nil
+}
+
+// Recv returns the next chunk. It returns io.EOF once the server closes the
+// stream, a *StreamError if the server ends it with an error, ErrClosed if
+// the socket closes, or the context's error once it is done.
+func (st *Stream) Recv() (any, error) {
+	if err := st.ctx.Err(); err != nil {
+		st.Close()
+		return nil, err
+	}
+	select {
+	case chunk, ok := <-st.chunks:
+		if !ok {
+			return nil, st.err
+		}
+		st.received++
+		if st.received >= max(cap(st.chunks)/2, 1) {
+			// Credit must not be dropped, or the server would wait forever
+			packet := st.socket.eventPacket(streamCredit, []any{st.id, st.received}, nil)
+			if err := st.socket.sendWait(st.ctx, packet); err != nil {
+				st.Close()
+				return nil, err
+			}
+			st.received = 0
+		}
+		return chunk, nil
+	case <-st.ctx.Done():
+		st.Close()
+		return nil, st.ctx.Err()
+	}
+}
+
+// All returns an iterator over the chunks of the stream. It stops after the
+// first error, which it yields unless the stream ended successfully.
+func (st *Stream) All() iter.Seq2[any, error] {
+	return func(yield func(any, error) bool) {
+		defer st.Close()
+		for {
+			chunk, err := st.Recv()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if !yield(chunk, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Close cancels the stream on the server unless it has already ended.
+func (st *Stream) Close() {
+	if _, ok := st.socket.streams.LoadAndDelete(st.id); ok {
+		st.socket.emit(streamCancel, st.id)
+		st.end(context.Canceled)
+	}
+}
+
+// push buffers a chunk for Recv.
+func (st *Stream) push(chunk any) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.ended {
+		return
+	}
+	select {
+	case st.chunks <- chunk:
+	default:
+		// The server ignored the window
+		st.finish(errors.New("stream window exceeded"))
+	}
+}
+
+// end ends the stream with err, returned by Recv after the chunks buffered.
+func (st *Stream) end(err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.finish(err)
+}
+
+func (st *Stream) finish(err error) {
+	if st.ended {
+		return
+	}
+	st.ended = true
+	st.err = err
+	close(st.chunks)
+}
+
+// receiveStream handles a stream message from the server. It reports whether
+// event was one for a stream of the socket.
+func (s *Socket) receiveStream(event string, args []any) bool {
+	switch event {
+	case streamChunk, streamEnd, streamError:
+	default:
+		return false
+	}
+	if len(args) == 0 {
+		return false
+	}
+	id, _ := args[0].(float64)
+	value, ok := s.streams.Load(uint64(id))
+	if !ok {
+		// Drop the replies of a cancelled stream, unless relaying
+		return s.relay == nil
+	}
+	st := value.(*Stream)
+
+	switch event {
+	case streamChunk:
+		var chunk any
+		if len(args) > 1 {
+			chunk = args[1]
+		}
+		st.push(chunk)
+	case streamEnd:
+		s.streams.Delete(st.id)
+		st.end(io.EOF)
+	case streamError:
+		message, _ := args[len(args)-1].(string)
+		s.streams.Delete(st.id)
+		st.end(&StreamError{Message: message})
+	}
+	return true
+}
+
+// endStreams ends every open stream with ErrClosed, as the server forgets
+// them when the connection drops.
+func (s *Socket) endStreams() {
+	s.streams.Range(func(key, value any) bool {
+		s.streams.Delete(key)
+		value.(*Stream).end(ErrClosed)
+		return true
+	})
+}
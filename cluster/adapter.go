@@ -0,0 +1,92 @@
+package cluster
+
+import (
+	"context"
+
+	"github.com/givensuman/go-sockets/server"
+	"github.com/google/uuid"
+)
+
+// Adapter returns an AdapterFactory for server.WithAdapter, connecting the
+// server's namespaces to the cluster.
+func (c *Cluster) Adapter() server.AdapterFactory {
+	return func(ns *server.Namespace) (server.Adapter, error) {
+		c.mu.Lock()
+		c.namespaces[ns.Name()] = ns
+		c.mu.Unlock()
+		return &adapter{cluster: c, ns: ns}, nil
+	}
+}
+
+// adapter is the server.Adapter of one namespace.
+type adapter struct {
+	cluster *Cluster
+	ns      *server.Namespace
+}
+
+// Publish implements server.Adapter.
+func (a *adapter) Publish(op server.Operation) error {
+	f := frame{Type: frameOp, Namespace: a.ns.Name(), Op: &op}
+	for _, p := range a.cluster.targets(a.ns.Name(), op) {
+		p.send(f)
+	}
+	return nil
+}
+
+// Request implements server.Adapter. Only peers linked when the request is
+// sent are waited for.
+func (a *adapter) Request(ctx context.Context, op server.Operation) ([]server.Reply, error) {
+	c := a.cluster
+	targets := c.targets(a.ns.Name(), op)
+	if len(targets) == 0 {
+		return nil, nil
+	}
+
+	id := uuid.New().String()
+	replies := make(chan server.Reply, len(targets))
+	c.pending.Store(id, replies)
+	defer c.pending.Delete(id)
+
+	f := frame{Type: frameOp, Namespace: a.ns.Name(), Request: id, Op: &op}
+	expected := 0
+	for _, p := range targets {
+		if p.send(f) {
+			expected++
+		}
+	}
+
+	var collected []server.Reply
+	for len(collected) < expected {
+		select {
+		case reply := <-replies:
+			collected = append(collected, reply)
+		case <-ctx.Done():
+			return collected, ctx.Err()
+		case <-c.closed:
+			return collected, nil
+		}
+	}
+	return collected, nil
+}
+
+// Close implements server.Adapter. It detaches the namespace; the cluster
+// itself stays up until Cluster.Close.
+func (a *adapter) Close() error {
+	c := a.cluster
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.namespaces[a.ns.Name()] == a.ns {
+		delete(c.namespaces, a.ns.Name())
+	}
+	return nil
+}
+
+// RoomJoined implements server.RoomObserver.
+func (a *adapter) RoomJoined(socketID, room string) {
+	a.cluster.roomChanged(frameJoin, a.ns.Name(), room, socketID)
+}
+
+// RoomLeft implements server.RoomObserver.
+func (a *adapter) RoomLeft(socketID, room string) {
+	a.cluster.roomChanged(frameLeave, a.ns.Name(), room, socketID)
+}
@@ -0,0 +1,93 @@
+package cluster
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+)
+
+// ErrNoSecret is returned by New when Options.Secret is empty.
+var ErrNoSecret = errors.New("cluster: a shared secret is required")
+
+// errHandshake is returned when a link fails to authenticate.
+var errHandshake = errors.New("cluster: handshake failed")
+
+// Links are authenticated both ways with the shared secret before any other
+// frame is read. The dialer's hello carries a nonce, the accepting node's hello
+// proves the secret over that nonce and carries a nonce of its own, and the
+// dialer answers with an auth frame proving the secret over the second nonce.
+// Each proof also covers the prover's node ID and role, so it cannot be
+// replayed on another link or reflected back.
+const (
+	roleDial   = "dial"
+	roleAccept = "accept"
+)
+
+// newNonce returns a random hex string for a handshake.
+func newNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// proof returns the HMAC of a handshake step.
+func proof(secret []byte, role, nonce, node string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(role + "\n" + nonce + "\n" + node))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validProof reports whether got is the proof of a handshake step.
+func validProof(secret []byte, got, role, nonce, node string) bool {
+	return hmac.Equal([]byte(got), []byte(proof(secret, role, nonce, node)))
+}
+
+// dialHandshake authenticates a link dialed with hello and returns the hello
+// of the node at the other end.
+func dialHandshake(conn net.Conn, secret []byte, hello frame) (frame, error) {
+	hello.Type = frameHello
+	hello.Nonce = newNonce()
+	if err := json.NewEncoder(conn).Encode(hello); err != nil {
+		return frame{}, err
+	}
+
+	// The accepting node writes nothing after its hello, so the decoder
+	// cannot consume later frames
+	var reply frame
+	if err := json.NewDecoder(conn).Decode(&reply); err != nil || reply.Type != frameHello ||
+		!validProof(secret, reply.Proof, roleAccept, hello.Nonce, reply.Node) {
+		return frame{}, errHandshake
+	}
+
+	auth := frame{Type: frameAuth, Proof: proof(secret, roleDial, reply.Nonce, hello.Node)}
+	if err := json.NewEncoder(conn).Encode(auth); err != nil {
+		return frame{}, err
+	}
+	return reply, nil
+}
+
+// acceptHandshake authenticates an incoming link, reading with dec, and
+// returns the hello of the dialing node.
+func (c *Cluster) acceptHandshake(conn net.Conn, dec *json.Decoder) (frame, error) {
+	var hello frame
+	if err := dec.Decode(&hello); err != nil || hello.Type != frameHello || hello.Node == "" || hello.Nonce == "" {
+		return frame{}, errHandshake
+	}
+
+	nonce := newNonce()
+	reply := frame{Type: frameHello, Node: c.id, Nonce: nonce, Proof: proof(c.secret, roleAccept, hello.Nonce, c.id)}
+	if err := json.NewEncoder(conn).Encode(reply); err != nil {
+		return frame{}, err
+	}
+
+	var auth frame
+	if err := dec.Decode(&auth); err != nil || auth.Type != frameAuth ||
+		!validProof(c.secret, auth.Proof, roleDial, nonce, hello.Node) {
+		return frame{}, errHandshake
+	}
+	return hello, nil
+}
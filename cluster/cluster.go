@@ -0,0 +1,601 @@
+// Package cluster connects go-sockets servers into a brokerless mesh over TCP,
+// for deployments without Redis. Each node dials every peer in a static list
+// and accepts links from the others:
+//
+//	c, err := cluster.New(cluster.Options{
+//		Addr:   "10.0.0.1:7946",
+//		Peers:  []string{"10.0.0.2:7946", "10.0.0.3:7946"},
+//		Secret: []byte(os.Getenv("CLUSTER_SECRET")),
+//	})
+//	srv := server.NewServer(server.WithAdapter(c.Adapter()))
+//
+// Nodes forward broadcasts and room operations to each other and answer
+// requests such as BroadcastOperator.FetchSockets. Every node also keeps a
+// view of the room membership of its peers, used to skip nodes with no sockets
+// in the target room and exposed through RoomMembers and Locate.
+//
+// Links are authenticated both ways with a secret shared by every node and
+// publisher, and a link failing to prove it is closed before any operation is
+// read. The secret does not encrypt the links, which should run on a private
+// network.
+//
+// A node that cannot reach a peer keeps redialing it with backoff and,
+// meanwhile, serves its own sockets only: broadcasts skip the peer and requests
+// do not wait for it. The cluster emits "node_join" and "node_leave" with the
+// node ID when a peer's link comes up or goes down.
+package cluster
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/givensuman/go-sockets/internal/emitter"
+	"github.com/givensuman/go-sockets/server"
+)
+
+// Link timings.
+const (
+	dialTimeout  = 5 * time.Second
+	writeTimeout = 5 * time.Second
+	minBackoff   = 100 * time.Millisecond
+	maxBackoff   = 10 * time.Second
+)
+
+// queueSize is the number of frames buffered per peer. A peer that falls
+// this far behind is disconnected and resynchronized.
+const queueSize = 1024
+
+// Options configures a cluster node.
+type Options struct {
+	// ID names the node; it defaults to the listening address.
+	ID string
+	// Addr is the TCP address the node listens on for links from its peers.
+	Addr string
+	// Peers lists the addresses of the other nodes. It may include Addr,
+	// so every node can share the same list.
+	Peers []string
+	// Secret authenticates the links between nodes and from publishers. It
+	// is required, and must be the same on every node.
+	Secret []byte
+}
+
+// Frame types exchanged on links.
+const (
+	frameHello = "hello" // first frame in each direction, carrying the node ID
+	frameAuth  = "auth"  // the dialer's proof of the secret, ending the handshake
+	frameSync  = "sync"  // the sender's complete room membership
+	frameJoin  = "join"  // a socket joined a room on the sender
+	frameLeave = "leave" // a socket left a room on the sender
+	frameOp    = "op"    // an operation to apply
+	frameReply = "reply" // the reply to an operation with a request ID
+)
+
+// frame is the unit of the link protocol, sent as one JSON object per line.
+type frame struct {
+	Type      string            `json:"type"`
+	Node      string            `json:"node,omitempty"`
+	Namespace string            `json:"ns,omitempty"`
+	Request   string            `json:"request,omitempty"`
+	Op        *server.Operation `json:"op,omitempty"`
+	Reply     *server.Reply     `json:"reply,omitempty"`
+	Socket    string            `json:"socket,omitempty"`
+	Room      string            `json:"room,omitempty"`
+	Rooms     membership        `json:"rooms,omitempty"`
+	Publisher bool              `json:"publisher,omitempty"` // set in the hello of a Publisher
+	Nonce     string            `json:"nonce,omitempty"`
+	Proof     string            `json:"proof,omitempty"`
+}
+
+// membership maps namespace -> room -> socket IDs.
+type membership map[string]map[string]map[string]bool
+
+func (m membership) add(ns, room, id string) {
+	if m[ns] == nil {
+		m[ns] = make(map[string]map[string]bool)
+	}
+	if m[ns][room] == nil {
+		m[ns][room] = make(map[string]bool)
+	}
+	m[ns][room][id] = true
+}
+
+func (m membership) remove(ns, room, id string) {
+	delete(m[ns][room], id)
+	if len(m[ns][room]) == 0 {
+		delete(m[ns], room)
+	}
+	if len(m[ns]) == 0 {
+		delete(m, ns)
+	}
+}
+
+func (m membership) clone() membership {
+	c := make(membership)
+	for ns, rooms := range m {
+		for room, ids := range rooms {
+			for id := range ids {
+				c.add(ns, room, id)
+			}
+		}
+	}
+	return c
+}
+
+// view is the membership of a peer, received on its incoming link.
+type view struct {
+	conn  net.Conn
+	rooms membership
+}
+
+// Cluster is a node of the mesh.
+type Cluster struct {
+	emitter.EventEmitter
+	id     string
+	secret []byte
+	ln     net.Listener
+
+	mu         sync.Mutex // guards the fields below
+	namespaces map[string]*server.Namespace
+	local      membership
+	views      map[string]*view // by node ID
+	peers      []*peer
+	incoming   map[net.Conn]bool
+
+	pending   sync.Map // map[string]chan server.Reply
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// New starts a node listening on opts.Addr and dials its peers.
+func New(opts Options) (*Cluster, error) {
+	if len(opts.Secret) == 0 {
+		return nil, ErrNoSecret
+	}
+	ln, err := net.Listen("tcp", opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cluster{
+		id:         opts.ID,
+		secret:     opts.Secret,
+		ln:         ln,
+		namespaces: make(map[string]*server.Namespace),
+		local:      make(membership),
+		views:      make(map[string]*view),
+		incoming:   make(map[net.Conn]bool),
+		closed:     make(chan struct{}),
+	}
+	if c.id == "" {
+		c.id = ln.Addr().String()
+	}
+
+	go c.accept()
+	for _, addr := range opts.Peers {
+		if addr != opts.Addr {
+			c.AddPeer(addr)
+		}
+	}
+	return c, nil
+}
+
+// ID returns the node ID.
+func (c *Cluster) ID() string {
+	return c.id
+}
+
+// Addr returns the address the node listens on.
+func (c *Cluster) Addr() string {
+	return c.ln.Addr().String()
+}
+
+// AddPeer dials a node that was not in the static peer list, such as one
+// added to a running cluster.
+func (c *Cluster) AddPeer(addr string) {
+	p := &peer{cluster: c, addr: addr}
+	c.mu.Lock()
+	c.peers = append(c.peers, p)
+	c.mu.Unlock()
+	go p.run()
+}
+
+// Nodes returns the IDs of the peers currently linked to this node.
+func (c *Cluster) Nodes() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var nodes []string
+	for node := range c.views {
+		nodes = append(nodes, node)
+	}
+	slices.Sort(nodes)
+	return nodes
+}
+
+// RoomMembers returns the sockets in a room of a namespace, by node ID,
+// according to this node's view of the cluster.
+func (c *Cluster) RoomMembers(namespace, room string) map[string][]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	members := make(map[string][]string)
+	collect := func(node string, m membership) {
+		for id := range m[namespace][room] {
+			members[node] = append(members[node], id)
+		}
+		slices.Sort(members[node])
+	}
+	collect(c.id, c.local)
+	for node, v := range c.views {
+		collect(node, v.rooms)
+	}
+	return members
+}
+
+// Locate returns the ID of the node a socket is connected to.
+func (c *Cluster) Locate(namespace, socketID string) (string, bool) {
+	for node, ids := range c.RoomMembers(namespace, socketID) {
+		if slices.Contains(ids, socketID) {
+			return node, true
+		}
+	}
+	return "", false
+}
+
+// Close shuts the node down, closing every link.
+func (c *Cluster) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.ln.Close()
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for conn := range c.incoming {
+			conn.Close()
+		}
+		for _, p := range c.peers {
+			p.disconnect()
+		}
+	})
+	return nil
+}
+
+// accept serves incoming links until the listener is closed.
+func (c *Cluster) accept() {
+	for {
+		conn, err := c.ln.Accept()
+		if err != nil {
+			return
+		}
+		go c.serve(conn)
+	}
+}
+
+// serve reads frames from a peer's incoming link.
+func (c *Cluster) serve(conn net.Conn) {
+	defer conn.Close()
+
+	c.mu.Lock()
+	select {
+	case <-c.closed:
+		c.mu.Unlock()
+		return
+	default:
+		c.incoming[conn] = true
+	}
+	c.mu.Unlock()
+
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	hello, err := c.acceptHandshake(conn, dec)
+	if err != nil || hello.Node == c.id {
+		c.dropIncoming(conn, "")
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	node := hello.Node
+
+	// A publisher only sends operations and is not a member of the cluster
+	if hello.Publisher {
+		for {
+			var f frame
+			if err := dec.Decode(&f); err != nil {
+				break
+			}
+			if f.Type == frameOp {
+				f.Request = ""
+				c.handle(conn, node, f)
+			}
+		}
+		c.dropIncoming(conn, "")
+		return
+	}
+
+	c.mu.Lock()
+	c.views[node] = &view{conn: conn, rooms: make(membership)}
+	c.mu.Unlock()
+	c.Emit("node_join", node)
+
+	for {
+		var f frame
+		if err := dec.Decode(&f); err != nil {
+			break
+		}
+		c.handle(conn, node, f)
+	}
+
+	if c.dropIncoming(conn, node) {
+		c.Emit("node_leave", node)
+	}
+}
+
+// dropIncoming forgets a closed incoming link and, if it is still the node's
+// current link, the node's view. It reports whether the view was dropped.
+func (c *Cluster) dropIncoming(conn net.Conn, node string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.incoming, conn)
+	if v, ok := c.views[node]; ok && v.conn == conn {
+		delete(c.views, node)
+		return true
+	}
+	return false
+}
+
+// handle processes a frame received from node.
+func (c *Cluster) handle(conn net.Conn, node string, f frame) {
+	switch f.Type {
+	case frameSync, frameJoin, frameLeave:
+		c.mu.Lock()
+		if v, ok := c.views[node]; ok && v.conn == conn {
+			switch f.Type {
+			case frameSync:
+				v.rooms = f.Rooms
+				if v.rooms == nil {
+					v.rooms = make(membership)
+				}
+			case frameJoin:
+				v.rooms.add(f.Namespace, f.Room, f.Socket)
+			case frameLeave:
+				v.rooms.remove(f.Namespace, f.Room, f.Socket)
+			}
+		}
+		c.mu.Unlock()
+
+	case frameOp:
+		if f.Op == nil {
+			return
+		}
+		c.mu.Lock()
+		ns := c.namespaces[f.Namespace]
+		c.mu.Unlock()
+
+		// A namespace this node has not created yet has no sockets
+		var reply server.Reply
+		if ns != nil {
+			reply = ns.Apply(*f.Op)
+		}
+		if f.Request != "" {
+			if p := c.peer(node); p != nil {
+				p.send(frame{Type: frameReply, Request: f.Request, Reply: &reply})
+			}
+		}
+
+	case frameReply:
+		if f.Reply == nil {
+			return
+		}
+		if replies, ok := c.pending.Load(f.Request); ok {
+			select {
+			case replies.(chan server.Reply) <- *f.Reply:
+			default:
+			}
+		}
+	}
+}
+
+// peer returns the connected outgoing link to node, or nil.
+func (c *Cluster) peer(node string) *peer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range c.peers {
+		if p.connectedTo(node) {
+			return p
+		}
+	}
+	return nil
+}
+
+// roomChanged records a local membership change and tells every peer.
+func (c *Cluster) roomChanged(kind, namespace, room, id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if kind == frameJoin {
+		c.local.add(namespace, room, id)
+	} else {
+		c.local.remove(namespace, room, id)
+	}
+	for _, p := range c.peers {
+		p.send(frame{Type: kind, Namespace: namespace, Room: room, Socket: id})
+	}
+}
+
+// targets returns the connected peers that may have sockets matching op,
+// according to their views. Peers whose view is unknown are included.
+func (c *Cluster) targets(namespace string, op server.Operation) []*peer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var targets []*peer
+	for _, p := range c.peers {
+		node, ok := p.connectedNode()
+		if !ok {
+			continue
+		}
+		if v, known := c.views[node]; known && len(op.Rooms) > 0 && len(v.rooms[namespace][op.Rooms[0]]) == 0 {
+			continue
+		}
+		targets = append(targets, p)
+	}
+	return targets
+}
+
+// peer is the outgoing link to a node, which this node uses to send frames.
+type peer struct {
+	cluster *Cluster
+	addr    string
+
+	mu    sync.Mutex
+	node  string
+	conn  net.Conn
+	queue chan frame    // nil while disconnected
+	stop  chan struct{} // closed when the link is dropped
+}
+
+// run keeps the link to the peer up, redialing with backoff, until the
+// cluster is closed.
+func (p *peer) run() {
+	c := p.cluster
+	backoff := minBackoff
+	for {
+		if conn, hello, err := p.dial(); err == nil {
+			// The address is this node's own
+			if hello.Node == c.id {
+				conn.Close()
+				return
+			}
+			backoff = minBackoff
+			p.write(conn, hello.Node)
+		}
+
+		select {
+		case <-c.closed:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// dial connects to the peer and authenticates the link.
+func (p *peer) dial() (net.Conn, frame, error) {
+	conn, err := net.DialTimeout("tcp", p.addr, dialTimeout)
+	if err != nil {
+		return nil, frame{}, err
+	}
+
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	hello, err := dialHandshake(conn, p.cluster.secret, frame{Node: p.cluster.id})
+	if err != nil {
+		conn.Close()
+		return nil, hello, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, hello, nil
+}
+
+// write sends queued frames on conn until the link fails or the cluster closes.
+// The first frame is a snapshot of the local membership.
+func (p *peer) write(conn net.Conn, node string) {
+	c := p.cluster
+	queue := make(chan frame, queueSize)
+	stop := make(chan struct{})
+
+	// Holding the cluster lock orders the snapshot before any later change
+	c.mu.Lock()
+	p.mu.Lock()
+	select {
+	case <-c.closed:
+		p.mu.Unlock()
+		c.mu.Unlock()
+		conn.Close()
+		return
+	default:
+	}
+	p.node, p.conn, p.queue, p.stop = node, conn, queue, stop
+	queue <- frame{Type: frameSync, Rooms: c.local.clone()}
+	p.mu.Unlock()
+	c.mu.Unlock()
+
+	// The peer never writes after its hello, so a read only returns once the link is gone
+	go func() {
+		io.Copy(io.Discard, conn)
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.conn == conn {
+			p.drop()
+		}
+	}()
+
+	enc := json.NewEncoder(conn)
+	for {
+		select {
+		case f := <-queue:
+			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := enc.Encode(f); err != nil {
+				p.disconnect()
+				return
+			}
+		case <-stop:
+			return
+		case <-c.closed:
+			p.disconnect()
+			return
+		}
+	}
+}
+
+// send queues f for the peer and reports whether it was queued. A peer whose
+// queue is full is disconnected and resynchronized on the next link.
+func (p *peer) send(f frame) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.queue == nil {
+		return false
+	}
+	select {
+	case p.queue <- f:
+		return true
+	default:
+		p.drop()
+		return false
+	}
+}
+
+// disconnect closes the current link, if any.
+func (p *peer) disconnect() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.drop()
+}
+
+// drop closes the current link, if any. The caller must hold mu.
+func (p *peer) drop() {
+	if p.conn == nil {
+		return
+	}
+	p.conn.Close()
+	close(p.stop)
+	p.conn, p.queue, p.stop = nil, nil, nil
+}
+
+// connectedNode returns the ID of the node the link is up to.
+func (p *peer) connectedNode() (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.node, p.queue != nil
+}
+
+// connectedTo reports whether the link to node is up.
+func (p *peer) connectedTo(node string) bool {
+	n, ok := p.connectedNode()
+	return ok && n == node
+}
@@ -0,0 +1,280 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/givensuman/go-sockets/client"
+	"github.com/givensuman/go-sockets/server"
+)
+
+// secret is shared by the nodes of the tests.
+var secret = []byte("test secret")
+
+// startNode starts a cluster node linked to peers and a server using it, and
+// returns both along with the server's WebSocket URL.
+func startNode(t *testing.T, id string, peers ...string) (*Cluster, *server.Server, string) {
+	c, err := New(Options{ID: id, Addr: "127.0.0.1:0", Peers: peers, Secret: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := server.NewServer(server.WithAdapter(c.Adapter()))
+	httpServer := httptest.NewServer(srv)
+	t.Cleanup(func() {
+		httpServer.Close()
+		srv.Close()
+		c.Close()
+	})
+	return c, srv, "ws" + strings.TrimPrefix(httpServer.URL, "http")
+}
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(1 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+// linked reports whether every node lists every other node.
+func linked(nodes ...*Cluster) func() bool {
+	return func() bool {
+		for _, n := range nodes {
+			if len(n.Nodes()) != len(nodes)-1 {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// connect connects a client to url and returns its server-side ID along with a
+// channel receiving its "news" events.
+func connect(t *testing.T, srv *server.Server, url string) (string, *client.Socket, chan string) {
+	connected := make(chan string, 1)
+	srv.Of("/").Once("connection", func(s *server.Socket) {
+		connected <- s.ID
+	})
+
+	news := make(chan string, 10)
+	c, err := client.Connect(url, "/", func(s *client.Socket) {
+		s.On("news", func(msg string) {
+			news <- msg
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+
+	select {
+	case id := <-connected:
+		return id, c, news
+	case <-time.After(1 * time.Second):
+		t.Fatal("client not connected")
+		return "", nil, nil
+	}
+}
+
+func expectNews(t *testing.T, news chan string, want string) {
+	t.Helper()
+	select {
+	case msg := <-news:
+		if msg != want {
+			t.Errorf("expected %q, got %q", want, msg)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatalf("%q not received", want)
+	}
+}
+
+func TestMeshBroadcast(t *testing.T) {
+	a, srvA, urlA := startNode(t, "a")
+	b, srvB, urlB := startNode(t, "b", a.Addr())
+	c, srvC, urlC := startNode(t, "c", a.Addr(), b.Addr())
+	a.AddPeer(b.Addr())
+	a.AddPeer(c.Addr())
+	b.AddPeer(c.Addr())
+	waitFor(t, "mesh", linked(a, b, c))
+
+	_, _, newsA := connect(t, srvA, urlA)
+	_, _, newsB := connect(t, srvB, urlB)
+	_, _, newsC := connect(t, srvC, urlC)
+
+	srvB.Of("/").Broadcast().Emit("news", "hello")
+	expectNews(t, newsA, "hello")
+	expectNews(t, newsB, "hello")
+	expectNews(t, newsC, "hello")
+}
+
+func TestMeshRoomView(t *testing.T) {
+	a, srvA, urlA := startNode(t, "a")
+	b, srvB, urlB := startNode(t, "b", a.Addr())
+	a.AddPeer(b.Addr())
+	waitFor(t, "mesh", linked(a, b))
+
+	idA, _, newsA := connect(t, srvA, urlA)
+	idB, clientB, newsB := connect(t, srvB, urlB)
+
+	// Join a socket on node B from node A
+	srvA.Of("/").To(idB).SocketsJoin("lobby")
+	waitFor(t, "room view", func() bool {
+		members := a.RoomMembers("/", "lobby")
+		return len(members["b"]) == 1 && members["b"][0] == idB
+	})
+	if node, ok := a.Locate("/", idB); !ok || node != "b" {
+		t.Errorf("expected %s on node b, got %q", idB, node)
+	}
+
+	srvA.Of("/").To("lobby").Emit("news", "lobby only")
+	expectNews(t, newsB, "lobby only")
+	select {
+	case msg := <-newsA:
+		t.Errorf("socket outside lobby received %q", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	found, err := srvA.Of("/").Broadcast().FetchSockets(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 {
+		t.Fatalf("expected 2 sockets, got %d", len(found))
+	}
+
+	disconnected := make(chan struct{})
+	clientB.On("disconnect", func(reason string) {
+		close(disconnected)
+	})
+	for _, rs := range found {
+		if rs.ID == idB {
+			rs.Disconnect()
+		}
+	}
+	select {
+	case <-disconnected:
+	case <-time.After(1 * time.Second):
+		t.Fatal("remote socket not disconnected")
+	}
+	waitFor(t, "room view update", func() bool {
+		_, ok := a.Locate("/", idB)
+		return !ok
+	})
+	if _, ok := a.Locate("/", idA); !ok {
+		t.Error("local socket missing from the view")
+	}
+}
+
+func TestMeshNodeLeave(t *testing.T) {
+	a, srvA, urlA := startNode(t, "a")
+	b, _, _ := startNode(t, "b", a.Addr())
+	a.AddPeer(b.Addr())
+	waitFor(t, "mesh", linked(a, b))
+
+	left := make(chan string, 1)
+	a.On("node_leave", func(node string) {
+		left <- node
+	})
+	b.Close()
+
+	select {
+	case node := <-left:
+		if node != "b" {
+			t.Errorf("expected node b to leave, got %q", node)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("node_leave not emitted")
+	}
+
+	// Node A keeps serving its own sockets
+	_, _, news := connect(t, srvA, urlA)
+	waitFor(t, "link down", func() bool {
+		p := a.peer("b")
+		return p == nil
+	})
+	srvA.Of("/").Broadcast().Emit("news", "local")
+	expectNews(t, news, "local")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	found, err := srvA.Of("/").Broadcast().FetchSockets(ctx)
+	if err != nil || len(found) != 1 {
+		t.Errorf("expected 1 local socket, got %d (%v)", len(found), err)
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	a, srvA, urlA := startNode(t, "a")
+	b, srvB, urlB := startNode(t, "b", a.Addr())
+	a.AddPeer(b.Addr())
+	waitFor(t, "mesh", linked(a, b))
+
+	_, _, newsA := connect(t, srvA, urlA)
+	_, _, newsB := connect(t, srvB, urlB)
+
+	pub := NewPublisher(secret, a.Addr(), b.Addr())
+	defer pub.Close()
+	if err := pub.Publish("/", server.Operation{Type: server.OpBroadcast, Data: []byte(`["news","from worker"]`)}); err != nil {
+		t.Fatal(err)
+	}
+	expectNews(t, newsA, "from worker")
+	expectNews(t, newsB, "from worker")
+
+	if nodes := a.Nodes(); len(nodes) != 1 || nodes[0] != "b" {
+		t.Errorf("publisher should not join the cluster, got nodes %v", nodes)
+	}
+}
+
+func TestMeshAuthentication(t *testing.T) {
+	if _, err := New(Options{Addr: "127.0.0.1:0"}); err != ErrNoSecret {
+		t.Errorf("expected ErrNoSecret, got %v", err)
+	}
+
+	a, srvA, urlA := startNode(t, "a")
+	_, _, newsA := connect(t, srvA, urlA)
+
+	// A node with another secret never links
+	intruder, err := New(Options{ID: "intruder", Addr: "127.0.0.1:0", Peers: []string{a.Addr()}, Secret: []byte("guess")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer intruder.Close()
+
+	// Nor does a publisher, and frames sent without a handshake are dropped
+	pub := NewPublisher([]byte("guess"), a.Addr())
+	defer pub.Close()
+	op := server.Operation{Type: server.OpBroadcast, Data: []byte(`["news","forged"]`)}
+	if err := pub.Publish("/", op); err == nil {
+		t.Error("expected the publisher's handshake to fail")
+	}
+	conn, err := net.Dial("tcp", a.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	enc := json.NewEncoder(conn)
+	enc.Encode(frame{Type: frameHello, Node: "raw", Nonce: "n", Publisher: true})
+	enc.Encode(frame{Type: frameOp, Namespace: "/", Op: &op})
+
+	time.Sleep(300 * time.Millisecond)
+	if nodes := a.Nodes(); len(nodes) != 0 {
+		t.Errorf("expected no linked nodes, got %v", nodes)
+	}
+	select {
+	case msg := <-newsA:
+		t.Errorf("unauthenticated broadcast delivered: %q", msg)
+	default:
+	}
+}
@@ -0,0 +1,109 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/givensuman/go-sockets/server"
+	"github.com/google/uuid"
+)
+
+// Publisher sends operations to the nodes of a mesh, for processes that
+// broadcast without serving sockets. Nodes do not forward operations to each
+// other, so it links to every node in its list. Delivery is best effort: an
+// operation written just as a node goes down may be lost. It implements
+// publisher.Transport.
+type Publisher struct {
+	id     string
+	secret []byte
+
+	mu     sync.Mutex
+	links  map[string]net.Conn // by address; missing while disconnected
+	addrs  []string
+	closed bool
+}
+
+// NewPublisher creates a Publisher for the nodes listening on addrs, which
+// share secret, see Options.Secret. It connects on the first Publish.
+func NewPublisher(secret []byte, addrs ...string) *Publisher {
+	return &Publisher{
+		id:     "publisher-" + uuid.New().String(),
+		secret: secret,
+		links:  make(map[string]net.Conn),
+		addrs:  addrs,
+	}
+}
+
+// Publish sends op to every node serving namespace. Nodes that cannot be
+// reached are skipped, and the first error is returned after trying them all.
+func (p *Publisher) Publish(namespace string, op server.Operation) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return net.ErrClosed
+	}
+
+	var errs []error
+	f := frame{Type: frameOp, Namespace: namespace, Op: &op}
+	for _, addr := range p.addrs {
+		// A link that broke since the last publish is redialed once
+		for attempt := 0; attempt < 2; attempt++ {
+			conn, err := p.link(addr)
+			if err != nil {
+				errs = append(errs, err)
+				break
+			}
+			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err = json.NewEncoder(conn).Encode(f); err == nil {
+				break
+			}
+			conn.Close()
+			delete(p.links, addr)
+			if attempt == 1 {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// link returns the link to addr, dialing it if needed. The caller must hold mu.
+func (p *Publisher) link(addr string) (net.Conn, error) {
+	if conn, ok := p.links[addr]; ok {
+		return conn, nil
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	if _, err := dialHandshake(conn, p.secret, frame{Node: p.id, Publisher: true}); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cluster: handshake with %s: %w", addr, err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	p.links[addr] = conn
+	return conn, nil
+}
+
+// Close closes every link.
+func (p *Publisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	for addr, conn := range p.links {
+		conn.Close()
+		delete(p.links, addr)
+	}
+	return nil
+}
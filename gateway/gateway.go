@@ -0,0 +1,144 @@
+// Package gateway relays a namespace to an upstream go-sockets server, for an
+// edge tier in front of internal servers.
+//
+// Each client connecting to the relayed namespace gets its own upstream
+// connection. Events, acknowledgments, room joins and disconnects pass through
+// in both directions; rooms live on the upstream server, which sees the
+// gateway's connection as the client.
+package gateway
+
+import (
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/givensuman/go-sockets/client"
+	"github.com/givensuman/go-sockets/server"
+)
+
+// Options configures a Gateway.
+type Options struct {
+	// URL is the WebSocket URL of the upstream server.
+	URL string
+	// Namespace is the upstream namespace. It defaults to the name of the
+	// relayed namespace.
+	Namespace string
+	// Auth returns the handshake headers of a client's upstream connection,
+	// such as credentials derived from its request. An error refuses the client.
+	Auth func(s *server.Socket) (http.Header, error)
+}
+
+// RewriteFunc rewrites an event passing through the gateway for the client s.
+// It returns the event name and arguments to forward, or false to drop the event.
+type RewriteFunc func(s *server.Socket, event string, args []any) (string, []any, bool)
+
+// Gateway relays the sockets of a namespace to an upstream server.
+type Gateway struct {
+	opts Options
+
+	mu       sync.RWMutex
+	inbound  map[string]RewriteFunc
+	outbound map[string]RewriteFunc
+
+	upstreams sync.Map // client socket ID -> *client.Socket
+}
+
+// New relays ns to the upstream server described by opts. Connection
+// middleware on ns runs before a client is relayed, and listeners registered on
+// its sockets receive nothing, as every event is passed on.
+func New(ns *server.Namespace, opts Options) *Gateway {
+	if opts.Namespace == "" {
+		opts.Namespace = ns.Name()
+	}
+	g := &Gateway{
+		opts:     opts,
+		inbound:  make(map[string]RewriteFunc),
+		outbound: make(map[string]RewriteFunc),
+	}
+	ns.On("connection", g.relay)
+	return g
+}
+
+// Inbound rewrites event when a client sends it to the upstream server.
+func (g *Gateway) Inbound(event string, fn RewriteFunc) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.inbound[event] = fn
+}
+
+// Outbound rewrites event when the upstream server sends it to a client.
+func (g *Gateway) Outbound(event string, fn RewriteFunc) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.outbound[event] = fn
+}
+
+// Upstream returns the upstream connection of the client with the given socket ID.
+func (g *Gateway) Upstream(socketID string) (*client.Socket, bool) {
+	up, ok := g.upstreams.Load(socketID)
+	if !ok {
+		return nil, false
+	}
+	return up.(*client.Socket), true
+}
+
+// rewrite applies the hook registered for event in hooks, if any.
+func (g *Gateway) rewrite(hooks map[string]RewriteFunc, s *server.Socket, event string, args []any) (string, []any, bool) {
+	g.mu.RLock()
+	fn := hooks[event]
+	g.mu.RUnlock()
+	if fn == nil {
+		return event, args, true
+	}
+	return fn(s, event, args)
+}
+
+// relay connects a new client upstream and passes its traffic through.
+func (g *Gateway) relay(down *server.Socket) {
+	var header http.Header
+	if g.opts.Auth != nil {
+		var err error
+		if header, err = g.opts.Auth(down); err != nil {
+			log.Println("gateway auth error:", err)
+			down.Disconnect()
+			return
+		}
+	}
+
+	up, err := client.Connect(g.opts.URL, g.opts.Namespace, func(up *client.Socket) {
+		up.Relay(func(event string, args []any, ack func(args ...any)) {
+			event, args, ok := g.rewrite(g.outbound, down, event, args)
+			if !ok {
+				return
+			}
+			if ack != nil {
+				args = append(args, func(ackArgs ...any) { ack(ackArgs...) })
+			}
+			down.Emit(event, args...)
+		})
+		up.On("disconnect", func(reason string) {
+			down.Disconnect()
+		})
+	}, client.WithHeader(header))
+	if err != nil {
+		log.Println("gateway upstream error:", err)
+		down.Disconnect()
+		return
+	}
+	g.upstreams.Store(down.ID, up)
+
+	down.Relay(func(event string, args []any, ack func(args ...any)) {
+		event, args, ok := g.rewrite(g.inbound, down, event, args)
+		if !ok {
+			return
+		}
+		if ack != nil {
+			args = append(args, func(ackArgs ...any) { ack(ackArgs...) })
+		}
+		up.Emit(event, args...)
+	})
+	down.On("disconnect", func(reason string) {
+		g.upstreams.Delete(down.ID)
+		up.Close()
+	})
+}
@@ -0,0 +1,176 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/givensuman/go-sockets/client"
+	"github.com/givensuman/go-sockets/server"
+)
+
+func startServer(t *testing.T, srv *server.Server) string {
+	httpServer := httptest.NewServer(srv)
+	t.Cleanup(httpServer.Close)
+	return "ws" + strings.TrimPrefix(httpServer.URL, "http")
+}
+
+func receive[T any](t *testing.T, ch chan T, what string) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(1 * time.Second):
+		t.Fatalf("%s not received", what)
+		var zero T
+		return zero
+	}
+}
+
+func TestGateway(t *testing.T) {
+	upstream := server.NewServer()
+	ns := upstream.Of("/chat")
+	ns.Use(func(s *server.Socket) error {
+		if s.Request.Header.Get("Authorization") != "Bearer edge" {
+			return errors.New("unauthorized")
+		}
+		s.SetUser(s.Request.Header.Get("X-User"))
+		return nil
+	})
+	upSockets := make(chan *server.Socket, 1)
+	ns.On("connection", func(s *server.Socket) {
+		s.On("echo", func(msg string, ack func(string)) {
+			ack("upstream:" + msg)
+		})
+		s.Emit("welcome", s.User())
+		upSockets <- s
+	})
+	upstreamURL := startServer(t, upstream)
+
+	edge := server.NewServer()
+	g := New(edge.Of("/chat"), Options{
+		URL: upstreamURL,
+		Auth: func(s *server.Socket) (http.Header, error) {
+			user := s.Request.URL.Query().Get("user")
+			if user == "" {
+				return nil, errors.New("no user")
+			}
+			return http.Header{"Authorization": {"Bearer edge"}, "X-User": {user}}, nil
+		},
+	})
+	g.Inbound("shout", func(s *server.Socket, event string, args []any) (string, []any, bool) {
+		return "echo", []any{strings.ToUpper(args[0].(string))}, true
+	})
+	g.Outbound("internal", func(s *server.Socket, event string, args []any) (string, []any, bool) {
+		return "", nil, false
+	})
+	edgeURL := startServer(t, edge)
+
+	welcome := make(chan string, 1)
+	news := make(chan string, 10)
+	internal := make(chan struct{}, 1)
+	disconnected := make(chan string, 1)
+	c, err := client.Connect(edgeURL+"?user=ann", "/chat", func(s *client.Socket) {
+		s.On("welcome", func(user string) { welcome <- user })
+		s.On("news", func(msg string) { news <- msg })
+		s.On("internal", func() { internal <- struct{}{} })
+		s.On("ask", func(ack func(args ...any)) { ack("yes") })
+		s.On("disconnect", func(reason string) { disconnected <- reason })
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+
+	if user := receive(t, welcome, "welcome"); user != "ann" {
+		t.Errorf("expected upstream to see user ann, got %q", user)
+	}
+	up := receive(t, upSockets, "upstream connection")
+
+	replies := make(chan string, 2)
+	c.Emit("echo", "hi", func(reply string) { replies <- reply })
+	if reply := receive(t, replies, "ack"); reply != "upstream:hi" {
+		t.Errorf("expected upstream ack, got %q", reply)
+	}
+	c.Emit("shout", "hey", func(reply string) { replies <- reply })
+	if reply := receive(t, replies, "rewritten ack"); reply != "upstream:HEY" {
+		t.Errorf("expected rewritten event, got %q", reply)
+	}
+
+	joined := make(chan any, 1)
+	c.Emit("join", "lobby", func(err any) { joined <- err })
+	if err := receive(t, joined, "join ack"); err != nil {
+		t.Errorf("expected join to succeed, got %v", err)
+	}
+	up.Emit("internal")
+	ns.To("lobby").Emit("news", "lobby news")
+	if msg := receive(t, news, "room broadcast"); msg != "lobby news" {
+		t.Errorf("expected lobby news, got %q", msg)
+	}
+	select {
+	case <-internal:
+		t.Error("dropped event reached the client")
+	default:
+	}
+
+	answers := make(chan string, 1)
+	up.Emit("ask", func(answer string) { answers <- answer })
+	if answer := receive(t, answers, "client ack"); answer != "yes" {
+		t.Errorf("expected client ack, got %q", answer)
+	}
+
+	up.Disconnect()
+	receive(t, disconnected, "disconnect")
+}
+
+func TestGatewayClientDisconnect(t *testing.T) {
+	upstream := server.NewServer()
+	upDisconnected := make(chan string, 1)
+	upstream.Of("/").On("connection", func(s *server.Socket) {
+		s.On("disconnect", func(reason string) { upDisconnected <- reason })
+	})
+	upstreamURL := startServer(t, upstream)
+
+	edge := server.NewServer()
+	g := New(edge.Of("/"), Options{
+		URL: upstreamURL,
+		Auth: func(s *server.Socket) (http.Header, error) {
+			if s.Request.URL.Query().Get("user") == "" {
+				return nil, errors.New("no user")
+			}
+			return nil, nil
+		},
+	})
+	edgeConnected := make(chan *server.Socket, 1)
+	edge.Of("/").On("connection", func(s *server.Socket) { edgeConnected <- s })
+	edgeURL := startServer(t, edge)
+
+	refused := make(chan string, 1)
+	anonymous, err := client.Connect(edgeURL, "/", func(s *client.Socket) {
+		s.On("disconnect", func(reason string) { refused <- reason })
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(anonymous.Close)
+	receive(t, refused, "refusal")
+	receive(t, edgeConnected, "anonymous connection")
+
+	c, err := client.Connect(edgeURL+"?user=bob", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := receive(t, edgeConnected, "edge connection")
+	if _, ok := g.Upstream(s.ID); !ok {
+		t.Error("expected an upstream connection")
+	}
+
+	c.Close()
+	receive(t, upDisconnected, "upstream disconnect")
+	if _, ok := g.Upstream(s.ID); ok {
+		t.Error("expected the upstream connection to be forgotten")
+	}
+}
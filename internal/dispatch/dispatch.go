@@ -0,0 +1,260 @@
+// Package dispatch runs the handlers of the events a socket receives, either
+// inline on the socket's reader, on a goroutine per socket, or on a worker pool
+// shared by all sockets. The handlers of one socket always run in order.
+package dispatch
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Mode selects where handlers run.
+type Mode int
+
+const (
+	// Inline runs handlers on the socket's reader, so a slow handler delays
+	// every later packet from the socket.
+	Inline Mode = iota
+	// Serial runs each socket's handlers on a goroutine of its own.
+	Serial
+	// Pool runs handlers on a fixed number of workers shared by all sockets.
+	Pool
+)
+
+// DefaultQueueSize is how many handlers may wait per socket by default.
+const DefaultQueueSize = 256
+
+// batchSize is how many handlers a pool worker runs for one socket before
+// giving the other sockets a turn.
+const batchSize = 16
+
+// Config configures a Dispatcher.
+type Config struct {
+	Mode Mode
+	// Workers is the size of the pool in Pool mode. It defaults to GOMAXPROCS.
+	Workers int
+	// QueueSize bounds the handlers waiting per socket in Serial and Pool
+	// modes. A socket stops reading while its queue is full.
+	QueueSize int
+}
+
+// Stats reports on the handlers run by a Dispatcher.
+type Stats struct {
+	// QueueDepth is the number of handlers waiting to run.
+	QueueDepth int
+	// Handled is the number of handlers that have run.
+	Handled uint64
+	// TotalLatency and MaxLatency are the total and longest running times of
+	// those handlers.
+	TotalLatency time.Duration
+	MaxLatency   time.Duration
+}
+
+// MeanLatency returns the average running time of a handler.
+func (s Stats) MeanLatency() time.Duration {
+	if s.Handled == 0 {
+		return 0
+	}
+	return s.TotalLatency / time.Duration(s.Handled)
+}
+
+// Dispatcher runs handlers for a set of sockets, each through its own Queue.
+type Dispatcher struct {
+	cfg Config
+
+	work      chan *Queue // sockets with handlers waiting, in Pool mode
+	startPool sync.Once
+	stop      chan struct{}
+	stopOnce  sync.Once
+
+	depth   atomic.Int64
+	handled atomic.Uint64
+	total   atomic.Int64
+	max     atomic.Int64
+}
+
+// New returns a Dispatcher configured by cfg.
+func New(cfg Config) *Dispatcher {
+	if cfg.Workers <= 0 {
+		cfg.Workers = runtime.GOMAXPROCS(0)
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultQueueSize
+	}
+	return &Dispatcher{cfg: cfg, stop: make(chan struct{})}
+}
+
+// Stats returns the dispatcher's current statistics.
+func (d *Dispatcher) Stats() Stats {
+	return Stats{
+		QueueDepth:   int(d.depth.Load()),
+		Handled:      d.handled.Load(),
+		TotalLatency: time.Duration(d.total.Load()),
+		MaxLatency:   time.Duration(d.max.Load()),
+	}
+}
+
+// Close stops the worker pool. Handlers still waiting are not run.
+func (d *Dispatcher) Close() {
+	d.stopOnce.Do(func() {
+		close(d.stop)
+	})
+}
+
+// Queue returns the queue of a new socket.
+func (d *Dispatcher) Queue() *Queue {
+	q := &Queue{d: d, done: make(chan struct{})}
+	switch d.cfg.Mode {
+	case Serial:
+		q.tasks = make(chan func(), d.cfg.QueueSize)
+		go q.serve()
+	case Pool:
+		q.tasks = make(chan func(), d.cfg.QueueSize)
+		d.startPool.Do(func() {
+			d.work = make(chan *Queue, d.cfg.Workers)
+			for range d.cfg.Workers {
+				go d.worker()
+			}
+		})
+	}
+	return q
+}
+
+// run runs a handler and records its latency.
+func (d *Dispatcher) run(task func()) {
+	start := time.Now()
+	task()
+	elapsed := int64(time.Since(start))
+
+	d.handled.Add(1)
+	d.total.Add(elapsed)
+	for {
+		longest := d.max.Load()
+		if elapsed <= longest || d.max.CompareAndSwap(longest, elapsed) {
+			return
+		}
+	}
+}
+
+// worker runs the handlers of the sockets scheduled on the pool.
+func (d *Dispatcher) worker() {
+	for {
+		select {
+		case q := <-d.work:
+			q.drain()
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// Queue runs the handlers of one socket in order.
+type Queue struct {
+	d         *Dispatcher
+	tasks     chan func() // nil in Inline mode
+	scheduled atomic.Bool // on the pool or being run by a worker
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Run runs task inline or queues it, blocking while the queue is full.
+// Tasks given after Close are dropped. A nil Queue runs task inline.
+func (q *Queue) Run(task func()) {
+	if q == nil {
+		task()
+		return
+	}
+	if q.tasks == nil {
+		q.d.run(task)
+		return
+	}
+
+	select {
+	case <-q.done:
+		return
+	default:
+	}
+	q.d.depth.Add(1)
+	select {
+	case q.tasks <- task:
+	case <-q.done:
+		q.d.depth.Add(-1)
+		return
+	}
+
+	if q.d.cfg.Mode == Pool {
+		q.schedule()
+	}
+}
+
+// Inline reports whether Run runs tasks on the caller.
+func (q *Queue) Inline() bool {
+	return q == nil || q.tasks == nil
+}
+
+// Close stops the queue once the tasks already given have run.
+func (q *Queue) Close() {
+	if q == nil {
+		return
+	}
+	q.closeOnce.Do(func() {
+		close(q.done)
+	})
+}
+
+// serve runs the tasks of a Serial queue until it is closed and drained.
+func (q *Queue) serve() {
+	for {
+		select {
+		case task := <-q.tasks:
+			q.d.depth.Add(-1)
+			q.d.run(task)
+		case <-q.done:
+			for {
+				select {
+				case task := <-q.tasks:
+					q.d.depth.Add(-1)
+					q.d.run(task)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// schedule puts a Pool queue on the pool unless it is already there.
+func (q *Queue) schedule() {
+	if !q.scheduled.CompareAndSwap(false, true) {
+		return
+	}
+	select {
+	case q.d.work <- q:
+	case <-q.d.stop:
+	}
+}
+
+// drain runs a batch of the queue's tasks on a pool worker, then puts the
+// queue back on the pool if tasks remain.
+func (q *Queue) drain() {
+	for range batchSize {
+		var task func()
+		select {
+		case task = <-q.tasks:
+		default:
+		}
+		if task == nil {
+			break
+		}
+		q.d.depth.Add(-1)
+		q.d.run(task)
+	}
+
+	q.scheduled.Store(false)
+	if len(q.tasks) > 0 {
+		// Rescheduling from a worker must not wait for a free worker
+		go q.schedule()
+	}
+}
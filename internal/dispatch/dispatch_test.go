@@ -0,0 +1,72 @@
+package dispatch
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQueueOrder(t *testing.T) {
+	for _, mode := range []Mode{Inline, Serial, Pool} {
+		d := New(Config{Mode: mode, Workers: 2, QueueSize: 4})
+		var mu sync.Mutex
+		got := map[int][]int{}
+		var wg sync.WaitGroup
+		for socket := range 3 {
+			q := d.Queue()
+			for i := range 50 {
+				wg.Add(1)
+				q.Run(func() {
+					defer wg.Done()
+					mu.Lock()
+					got[socket] = append(got[socket], i)
+					mu.Unlock()
+				})
+			}
+			q.Close()
+		}
+		wg.Wait()
+		d.Close()
+
+		for socket, order := range got {
+			for i, v := range order {
+				if v != i {
+					t.Fatalf("mode %d socket %d: out of order %v", mode, socket, order)
+				}
+			}
+		}
+		if stats := d.Stats(); stats.Handled != 150 || stats.QueueDepth != 0 {
+			t.Errorf("mode %d: unexpected stats %+v", mode, stats)
+		}
+	}
+}
+
+func TestPoolRunsSocketsConcurrently(t *testing.T) {
+	d := New(Config{Mode: Pool, Workers: 2})
+	defer d.Close()
+
+	release := make(chan struct{})
+	blocked := d.Queue()
+	blocked.Run(func() { <-release })
+	blocked.Run(func() {})
+
+	done := make(chan struct{})
+	d.Queue().Run(func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		t.Fatal("a slow socket held up another")
+	}
+	if depth := d.Stats().QueueDepth; depth != 1 {
+		t.Errorf("expected 1 waiting handler, got %d", depth)
+	}
+
+	close(release)
+	deadline := time.Now().Add(1 * time.Second)
+	for d.Stats().Handled < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if stats := d.Stats(); stats.Handled != 3 || stats.MaxLatency <= 0 || stats.MeanLatency() <= 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
@@ -3,129 +3,359 @@
 package emitter
 
 import (
+	"context"
+	"maps"
 	"reflect"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 // EventEmitter is a struct that manages event listeners and emits events.
 // It uses reflection to call callbacks with variable arguments.
+//
+// Listeners are kept in an immutable registry that is replaced as a whole on
+// every change, so Emit reads a consistent snapshot with one atomic load and
+// never waits for On or Off.
+//
+// An event name containing "*" registers a pattern, in which "*" matches any
+// run of characters other than ":"; "chat:*" matches "chat:message" but not
+// "chat:room:message". Pattern listeners and OnAny listeners receive the
+// emitted event name before its arguments. Emit calls the listeners of the
+// exact event first, then those of matching patterns, then OnAny listeners,
+// each group in the order it was registered.
+//
+// A callback whose first parameter is a context.Context receives the context
+// given to EmitContext, or context.Background, before the arguments.
 type EventEmitter struct {
-	listeners     sync.Map // map[string][]reflect.Value
-	onceListeners sync.Map // map[string][]reflect.Value
+	mu       sync.Mutex // serializes changes to the registry
+	registry atomic.Pointer[registry]
+	catch    atomic.Pointer[ErrorFunc]
 }
 
-// On registers a callback function to be called whenever the specified event is emitted.
-// The callback must be a function. Panics if callback is not a function.
-func (e *EventEmitter) On(event string, callback any) {
-	val := reflect.ValueOf(callback)
-	if val.Kind() != reflect.Func {
-		panic("callback must be a function")
-	}
+// ErrorFunc receives the failures of listeners: a PanicError, whose stack is
+// also passed, or an error wrapping ErrArguments.
+type ErrorFunc func(event string, err error, stack []byte)
+
+// registry holds the listeners. It is never modified once stored.
+type registry struct {
+	events   map[string][]*listener // by event name or pattern
+	patterns []*listener            // the listeners of every pattern, in calling order
+	any      []*listener
+}
+
+// listener is one registration of a callback.
+type listener struct {
+	plan
+	pattern string // set for pattern listeners
+	once    bool
+	done    atomic.Bool // set once the listener is removed, or a once listener is claimed
+}
+
+// Subscription is a listener registered with On, Once, PrependListener or OnAny.
+type Subscription struct {
+	emitter  *EventEmitter
+	listener *listener
+}
 
-	actual, _ := e.listeners.LoadOrStore(event, []reflect.Value{})
-	list := actual.([]reflect.Value)
-	list = append(list, val)
+// Unsubscribe removes the listener. Calling it more than once has no effect.
+func (s *Subscription) Unsubscribe() {
+	s.listener.done.Store(true)
+	s.emitter.prune()
+}
 
-	e.listeners.Store(event, list)
+// On registers a callback function to be called whenever the specified event is emitted.
+// The callback must be a function. Panics if callback is not a function.
+func (e *EventEmitter) On(event string, callback any) *Subscription {
+	return e.add(event, callback, false, false)
 }
 
 // Once registers a callback function to be called only once when the specified event is emitted.
 // After emission, the callback is automatically removed.
-func (e *EventEmitter) Once(event string, callback any) {
-	val := reflect.ValueOf(callback)
-	if val.Kind() != reflect.Func {
+func (e *EventEmitter) Once(event string, callback any) *Subscription {
+	return e.add(event, callback, true, false)
+}
+
+// PrependListener is On, except that the callback is called before the
+// listeners already registered for the event.
+func (e *EventEmitter) PrependListener(event string, callback any) *Subscription {
+	return e.add(event, callback, false, true)
+}
+
+// OnAny registers a callback called with the name and arguments of every
+// emitted event, after the event's own listeners.
+func (e *EventEmitter) OnAny(callback func(event string, args ...any)) *Subscription {
+	l := &listener{plan: newPlan(callback)}
+	e.update(func(r *registry) {
+		r.any = append(slices.Clip(r.any), l)
+	})
+	return &Subscription{emitter: e, listener: l}
+}
+
+func (e *EventEmitter) add(event string, callback any, once, prepend bool) *Subscription {
+	if t := reflect.TypeOf(callback); t == nil || t.Kind() != reflect.Func {
 		panic("callback must be a function")
 	}
+	l := &listener{plan: newPlan(callback), once: once}
+	if strings.Contains(event, "*") {
+		l.pattern = event
+	}
 
-	actual, _ := e.onceListeners.LoadOrStore(event, []reflect.Value{})
-	list := actual.([]reflect.Value)
-	list = append(list, val)
+	e.update(func(r *registry) {
+		if prepend {
+			r.events[event] = append([]*listener{l}, r.events[event]...)
+		} else {
+			r.events[event] = append(slices.Clip(r.events[event]), l)
+		}
+		if l.pattern == "" {
+			return
+		}
+		if prepend {
+			r.patterns = append([]*listener{l}, r.patterns...)
+		} else {
+			r.patterns = append(slices.Clip(r.patterns), l)
+		}
+	})
 
-	e.onceListeners.Store(event, list)
+	return &Subscription{emitter: e, listener: l}
 }
 
-// Off removes a previously registered callback for the specified event.
-// It removes from both regular and once listeners.
+// Off removes every listener of the event registered with callback's function.
+// Closures created by the same function literal share their function, so Off
+// removes all of them; use Subscription.Unsubscribe to remove a single one.
 func (e *EventEmitter) Off(event string, callback any) {
-	val := reflect.ValueOf(callback)
-	actual, ok := e.listeners.Load(event)
-	if !ok {
-		return
+	ptr := reflect.ValueOf(callback).Pointer()
+	for _, l := range e.load(event) {
+		if l.fn.Pointer() == ptr {
+			l.done.Store(true)
+		}
 	}
+	e.prune()
+}
 
-	list := actual.([]reflect.Value)
-	newList := make([]reflect.Value, 0, len(list))
-	for _, v := range list {
-		if v.Pointer() != val.Pointer() {
-			newList = append(newList, v)
+// RemoveAllListeners removes every listener of the given events or patterns,
+// or every listener including OnAny ones if none are given.
+func (e *EventEmitter) RemoveAllListeners(events ...string) {
+	r := e.registry.Load()
+	if r == nil {
+		return
+	}
+	if len(events) == 0 {
+		for _, l := range r.any {
+			l.done.Store(true)
+		}
+		events = slices.Collect(maps.Keys(r.events))
+	}
+	for _, event := range events {
+		for _, l := range r.events[event] {
+			l.done.Store(true)
 		}
 	}
+	e.prune()
+}
 
-	e.listeners.Store(event, newList)
+// ListenerCount returns the number of listeners registered for the event or
+// pattern, not counting the patterns matching it.
+func (e *EventEmitter) ListenerCount(event string) int {
+	return len(e.load(event))
+}
 
-	// Also remove from onceListeners
-	actual, ok = e.onceListeners.Load(event)
-	if !ok {
-		return
+// AnyListenerCount returns the number of listeners registered with OnAny.
+func (e *EventEmitter) AnyListenerCount() int {
+	if r := e.registry.Load(); r != nil {
+		return len(r.any)
 	}
+	return 0
+}
 
-	list = actual.([]reflect.Value)
-	newList = make([]reflect.Value, 0, len(list))
-	for _, v := range list {
-		if v.Pointer() != val.Pointer() {
-			newList = append(newList, v)
-		}
+// EventNames returns the events and patterns that have listeners, in sorted order.
+func (e *EventEmitter) EventNames() []string {
+	r := e.registry.Load()
+	if r == nil {
+		return nil
 	}
+	return slices.Sorted(maps.Keys(r.events))
+}
 
-	e.onceListeners.Store(event, newList)
+// load returns the listeners of event. The slice must not be modified.
+func (e *EventEmitter) load(event string) []*listener {
+	if r := e.registry.Load(); r != nil {
+		return r.events[event]
+	}
+	return nil
+}
+
+// update replaces the registry with a copy changed by fn. The slices in the
+// copy are shared with the old registry, so fn must replace rather than modify them.
+func (e *EventEmitter) update(fn func(*registry)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	next := registry{events: map[string][]*listener{}}
+	if r := e.registry.Load(); r != nil {
+		next = *r
+		next.events = maps.Clone(r.events)
+	}
+	fn(&next)
+	e.registry.Store(&next)
+}
+
+// prune drops the listeners that have been removed or claimed.
+func (e *EventEmitter) prune() {
+	done := func(l *listener) bool {
+		return l.done.Load()
+	}
+	e.update(func(r *registry) {
+		for event, list := range r.events {
+			if slices.ContainsFunc(list, done) {
+				list = slices.DeleteFunc(slices.Clone(list), done)
+			}
+			if len(list) == 0 {
+				delete(r.events, event)
+			} else {
+				r.events[event] = list
+			}
+		}
+		if slices.ContainsFunc(r.patterns, done) {
+			r.patterns = slices.DeleteFunc(slices.Clone(r.patterns), done)
+		}
+		if slices.ContainsFunc(r.any, done) {
+			r.any = slices.DeleteFunc(slices.Clone(r.any), done)
+		}
+	})
 }
 
 // Emit triggers all registered callbacks for the specified event, passing the provided arguments.
-// It handles both regular and once listeners, with panic recovery for each callback.
+// Callbacks run in the order described on EventEmitter, with panic recovery for each callback.
+// A callback whose parameters do not fit the arguments is skipped; extra
+// arguments are dropped. Failures are passed to the function set with Catch.
 func (e *EventEmitter) Emit(event string, args ...any) {
-	reflectedArgs := make([]reflect.Value, len(args))
-	for i, arg := range args {
-		reflectedArgs[i] = reflect.ValueOf(arg)
+	var report ErrorFunc
+	if catch := e.catch.Load(); catch != nil {
+		report = *catch
 	}
+	e.EmitReport(event, report, args...)
+}
 
-	// Handle once listeners
-	if actual, ok := e.onceListeners.LoadAndDelete(event); ok {
-		list := actual.([]reflect.Value)
-		for _, listener := range list {
-			func() {
-				defer func() {
-					recover()
-				}()
+// EmitReport is Emit, passing the failures of listeners to report instead of
+// the function set with Catch. A nil report ignores them.
+func (e *EventEmitter) EmitReport(event string, report ErrorFunc, args ...any) {
+	e.EmitContext(context.Background(), event, report, args...)
+}
 
-				listener.Call(reflectedArgs)
-			}()
+// EmitContext is EmitReport, passing ctx to the callbacks that take a context.
+func (e *EventEmitter) EmitContext(ctx context.Context, event string, report ErrorFunc, args ...any) {
+	r := e.registry.Load()
+	if r == nil {
+		return
+	}
+
+	call := func(l *listener, args []any) {
+		err := l.call(ctx, args)
+		if err == nil || report == nil {
+			return
+		}
+		var stack []byte
+		if p, ok := err.(*PanicError); ok {
+			stack = p.Stack
 		}
+		report(event, err, stack)
 	}
 
-	// Handle regular listeners
-	if actual, ok := e.listeners.Load(event); ok {
-		list := actual.([]reflect.Value)
-		for _, listener := range list {
-			func() {
-				defer func() {
-					recover()
-				}()
+	fired := false
+	for _, l := range r.events[event] {
+		if l.pattern == "" && l.claim(&fired) {
+			call(l, args)
+		}
+	}
 
-				listener.Call(reflectedArgs)
-			}()
+	if len(r.patterns) > 0 || len(r.any) > 0 {
+		named := append([]any{event}, args...)
+		for _, l := range r.patterns {
+			if Match(l.pattern, event) && l.claim(&fired) {
+				call(l, named)
+			}
 		}
+		for _, l := range r.any {
+			if l.claim(&fired) {
+				call(l, named)
+			}
+		}
+	}
+
+	if fired {
+		e.prune()
+	}
+}
+
+// Catch sets the function receiving the failures of listeners during Emit.
+func (e *EventEmitter) Catch(fn ErrorFunc) {
+	e.catch.Store(&fn)
+}
+
+// claim reports whether the listener should be called, claiming it if it is a
+// once listener so that concurrent emits call it only once.
+func (l *listener) claim(fired *bool) bool {
+	if !l.once {
+		// Skip a listener removed while this emit was running
+		return !l.done.Load()
+	}
+	if !l.done.CompareAndSwap(false, true) {
+		return false
 	}
+	*fired = true
+	return true
 }
 
-// GetCallbackType returns the reflect.Type of the first callback registered for the event.
+// GetCallbackType returns the reflect.Type of the first callback registered
+// for the event, or else of the first matching pattern or OnAny listener.
 // Returns nil if no callbacks are registered.
 func (e *EventEmitter) GetCallbackType(event string) reflect.Type {
-	if listeners, ok := e.listeners.Load(event); ok {
-		list := listeners.([]reflect.Value)
-		if len(list) > 0 {
-			return list[0].Type()
+	r := e.registry.Load()
+	if r == nil {
+		return nil
+	}
+	for _, l := range r.events[event] {
+		if l.pattern == "" {
+			return l.fn.Type()
+		}
+	}
+	for _, l := range r.patterns {
+		if Match(l.pattern, event) {
+			return l.fn.Type()
 		}
 	}
+	if len(r.any) > 0 {
+		return r.any[0].fn.Type()
+	}
 
 	return nil
 }
+
+// Match reports whether event matches pattern, in which "*" matches any run
+// of characters other than ":".
+func Match(pattern, event string) bool {
+	for {
+		star := strings.IndexByte(pattern, '*')
+		if star < 0 {
+			return pattern == event
+		}
+		if !strings.HasPrefix(event, pattern[:star]) {
+			return false
+		}
+		pattern, event = pattern[star+1:], event[star:]
+
+		// Try every length for the run, shortest first, up to the next ":"
+		run := strings.IndexByte(event, ':')
+		if run < 0 {
+			run = len(event)
+		}
+		for i := 0; i < run; i++ {
+			if Match(pattern, event[i:]) {
+				return true
+			}
+		}
+		event = event[run:]
+	}
+}
@@ -1,6 +1,10 @@
 package emitter
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"sync"
 	"testing"
 )
@@ -104,3 +108,237 @@ func TestPanicRecovery(t *testing.T) {
 	// Should not panic
 	em.Emit("test")
 }
+
+func TestUnsubscribe(t *testing.T) {
+	em := &EventEmitter{}
+	var calls []int
+	var subs []*Subscription
+	for i := range 3 {
+		subs = append(subs, em.On("test", func() { calls = append(calls, i) }))
+	}
+	subs[1].Unsubscribe()
+	subs[1].Unsubscribe()
+	em.Emit("test")
+	if len(calls) != 2 || calls[0] != 0 || calls[1] != 2 {
+		t.Errorf("expected only the unsubscribed closure to be removed, got %v", calls)
+	}
+
+	once := em.Once("other", func() { t.Error("unsubscribed once listener called") })
+	once.Unsubscribe()
+	em.Emit("other")
+	if n := em.ListenerCount("other"); n != 0 {
+		t.Errorf("expected no listeners, got %d", n)
+	}
+}
+
+func TestPrependListener(t *testing.T) {
+	em := &EventEmitter{}
+	var order []string
+	em.On("test", func() { order = append(order, "on") })
+	em.Once("test", func() { order = append(order, "once") })
+	em.PrependListener("test", func() { order = append(order, "first") })
+	em.Emit("test")
+	em.Emit("test")
+	if want := "first on once first on"; strings.Join(order, " ") != want {
+		t.Errorf("expected %q, got %q", want, strings.Join(order, " "))
+	}
+}
+
+func TestListenerIntrospection(t *testing.T) {
+	em := &EventEmitter{}
+	em.On("b", func() {})
+	em.On("a", func() {})
+	em.Once("a", func() {})
+	if names := em.EventNames(); len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Errorf("unexpected event names %v", names)
+	}
+	if n := em.ListenerCount("a"); n != 2 {
+		t.Errorf("expected 2 listeners, got %d", n)
+	}
+
+	em.RemoveAllListeners("a")
+	if names := em.EventNames(); len(names) != 1 || names[0] != "b" {
+		t.Errorf("unexpected event names %v", names)
+	}
+	em.RemoveAllListeners()
+	if names := em.EventNames(); len(names) != 0 {
+		t.Errorf("expected no events, got %v", names)
+	}
+}
+
+func TestConcurrentOn(t *testing.T) {
+	em := &EventEmitter{}
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			em.On("test", func() {})
+		}()
+	}
+	wg.Wait()
+	if n := em.ListenerCount("test"); n != 50 {
+		t.Errorf("expected 50 listeners, got %d", n)
+	}
+}
+
+func TestCallPlan(t *testing.T) {
+	em := &EventEmitter{}
+	var got []string
+	em.On("test", func(s string, n float64) { got = append(got, fmt.Sprint("typed ", s, n)) })
+	em.On("test", func(s string) { got = append(got, "string "+s) })
+	em.On("test", func(args ...any) { got = append(got, fmt.Sprint("any ", len(args))) })
+	em.On("test", func(s string, rest ...int) { got = append(got, fmt.Sprint("variadic ", s, rest)) })
+
+	em.Emit("test", "a", 1.5)
+	em.Emit("test", nil, nil)
+	em.Emit("test", "b")
+	// Extra arguments are dropped
+	want := []string{
+		"typed a1.5", "string a", "any 2",
+		"typed 0", "string ", "any 2", "variadic [0]",
+		"string b", "any 1", "variadic b[]",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func benchmarkEmit(b *testing.B, callback any, args ...any) {
+	em := &EventEmitter{}
+	em.On("message", callback)
+	em.On("other", func() {})
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			em.Emit("message", args...)
+		}
+	})
+}
+
+func BenchmarkEmitDirect(b *testing.B) {
+	benchmarkEmit(b, func(s string) {}, "hello")
+}
+
+func BenchmarkEmitReflect(b *testing.B) {
+	benchmarkEmit(b, func(s string, n float64, m map[string]any) {}, "hello", 1.0, map[string]any{})
+}
+
+func BenchmarkEmitNoListener(b *testing.B) {
+	em := &EventEmitter{}
+	em.On("other", func() {})
+	b.ReportAllocs()
+	for b.Loop() {
+		em.Emit("message", "hello")
+	}
+}
+
+func TestContextListener(t *testing.T) {
+	em := &EventEmitter{}
+	type key struct{}
+	var got []any
+	em.On("test", func(ctx context.Context, n float64) {
+		got = append(got, ctx.Value(key{}), n)
+	})
+	em.On("test:*", func(ctx context.Context, event string, args ...any) {
+		got = append(got, ctx.Value(key{}), event)
+	})
+
+	ctx := context.WithValue(context.Background(), key{}, "ctx")
+	em.EmitContext(ctx, "test", nil, 1.0)
+	em.EmitContext(ctx, "test:sub", nil)
+	em.Emit("test", 2.0)
+	want := []any{"ctx", 1.0, "ctx", "test:sub", nil, 2.0}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, event string
+		want           bool
+	}{
+		{"chat:*", "chat:message", true},
+		{"chat:*", "chat:", true},
+		{"chat:*", "chat:room:message", false},
+		{"chat:*", "doc:patch", false},
+		{"*:patch", "doc:patch", true},
+		{"chat:typ*", "chat:typing", true},
+		{"*:*", "doc:patch", true},
+		{"*", "doc:patch", false},
+		{"*", "ping", true},
+	} {
+		if got := Match(tc.pattern, tc.event); got != tc.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tc.pattern, tc.event, got, tc.want)
+		}
+	}
+}
+
+func TestWildcardPrecedence(t *testing.T) {
+	em := &EventEmitter{}
+	var order []string
+	em.OnAny(func(event string, args ...any) {
+		order = append(order, fmt.Sprint("any ", event, args))
+	})
+	em.On("chat:*", func(event string, msg string) {
+		order = append(order, "pattern "+event+" "+msg)
+	})
+	em.Once("*:message", func(event string, msg string) {
+		order = append(order, "once "+event)
+	})
+	em.On("chat:message", func(msg string) {
+		order = append(order, "exact "+msg)
+	})
+
+	em.Emit("chat:message", "hi")
+	em.Emit("chat:message", "again")
+	em.Emit("doc:patch", "x")
+	want := []string{
+		"exact hi", "pattern chat:message hi", "once chat:message", "any chat:message[hi]",
+		"exact again", "pattern chat:message again", "any chat:message[again]",
+		"any doc:patch[x]",
+	}
+	if strings.Join(order, "|") != strings.Join(want, "|") {
+		t.Errorf("expected %q, got %q", want, order)
+	}
+
+	if n := em.ListenerCount("chat:*"); n != 1 {
+		t.Errorf("expected 1 pattern listener, got %d", n)
+	}
+	em.RemoveAllListeners()
+	if n := em.AnyListenerCount(); n != 0 {
+		t.Errorf("expected OnAny listeners to be removed, got %d", n)
+	}
+}
+
+func TestCatch(t *testing.T) {
+	em := &EventEmitter{}
+	var errs []error
+	var stacks [][]byte
+	em.Catch(func(event string, err error, stack []byte) {
+		if event != "test" {
+			t.Errorf("unexpected event %q", event)
+		}
+		errs = append(errs, err)
+		stacks = append(stacks, stack)
+	})
+	em.On("test", func(n int) {})
+	em.On("test", func(s string) { panic("boom") })
+
+	em.Emit("test", "x")
+	if len(errs) != 2 || !errors.Is(errs[0], ErrArguments) || stacks[0] != nil {
+		t.Fatalf("expected an argument error first, got %v", errs)
+	}
+	var p *PanicError
+	if !errors.As(errs[1], &p) || p.Value != "boom" || len(stacks[1]) == 0 {
+		t.Errorf("expected a panic with its stack, got %v", errs[1])
+	}
+
+	reported := 0
+	em.EmitReport("test", func(event string, err error, stack []byte) { reported++ })
+	if reported != 2 || len(errs) != 2 {
+		t.Errorf("expected EmitReport to replace Catch, got %d and %d", reported, len(errs))
+	}
+}
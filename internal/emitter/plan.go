@@ -0,0 +1,163 @@
+package emitter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime/debug"
+)
+
+// ErrArguments is reported when the arguments of an event do not fit the
+// parameters of a listener, which is then not called.
+var ErrArguments = errors.New("arguments do not fit the listener")
+
+// PanicError is reported when a listener panics.
+type PanicError struct {
+	// Value is the value recovered from the panic.
+	Value any
+	// Stack is the stack trace of the panicking goroutine.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprint("listener panicked: ", e.Value)
+}
+
+// plan is how a callback is called, worked out once when it is registered
+// rather than on every Emit.
+type plan struct {
+	fn reflect.Value
+	// context is set if the first parameter is a context.Context, which is
+	// then passed the context of the emit before the arguments.
+	context bool
+	// direct calls common callback types without reflection. It is nil for
+	// other types, which are called through fn.
+	direct   func(args []any) error
+	params   []reflect.Type // the fixed parameters
+	variadic reflect.Type   // the element type of a variadic parameter, or nil
+}
+
+func newPlan(callback any) plan {
+	p := plan{fn: reflect.ValueOf(callback)}
+
+	switch fn := callback.(type) {
+	case func():
+		p.direct = func(args []any) error {
+			fn()
+			return nil
+		}
+	case func(string):
+		p.direct = func(args []any) error {
+			if len(args) == 0 {
+				return fmt.Errorf("%w: missing argument 1", ErrArguments)
+			}
+			s, ok := args[0].(string)
+			if !ok && args[0] != nil {
+				return fmt.Errorf("%w: argument 1 is %T, not string", ErrArguments, args[0])
+			}
+			fn(s)
+			return nil
+		}
+	case func(any):
+		p.direct = func(args []any) error {
+			if len(args) == 0 {
+				return fmt.Errorf("%w: missing argument 1", ErrArguments)
+			}
+			fn(args[0])
+			return nil
+		}
+	case func(...any):
+		p.direct = func(args []any) error {
+			fn(args...)
+			return nil
+		}
+	}
+
+	t := p.fn.Type()
+	for i := range t.NumIn() {
+		if i == 0 && t.In(0) == contextType {
+			p.context = true
+		} else if t.IsVariadic() && i == t.NumIn()-1 {
+			p.variadic = t.In(i).Elem()
+		} else {
+			p.params = append(p.params, t.In(i))
+		}
+	}
+	return p
+}
+
+var contextType = reflect.TypeFor[context.Context]()
+
+// Callback is a function called with arguments from the peer, such as an
+// acknowledgment callback, checked and recovered like a listener.
+type Callback struct {
+	// Event is the event the callback belongs to, for reporting its failures.
+	Event string
+	plan  plan
+}
+
+// NewCallback plans fn, which must be a function, for calls with peer arguments.
+func NewCallback(event string, fn any) *Callback {
+	return &Callback{Event: event, plan: newPlan(fn)}
+}
+
+// Call calls the callback as a listener is called, returning ErrArguments if
+// args do not fit its parameters or a *PanicError if it panics.
+func (c *Callback) Call(ctx context.Context, args []any) error {
+	return c.plan.call(ctx, args)
+}
+
+// call calls the callback with args, and ctx first if it takes a context,
+// recovering from any panic as a PanicError. It returns ErrArguments without
+// calling the callback if the arguments do not fit its parameters.
+func (p *plan) call(ctx context.Context, args []any) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+
+	if p.direct != nil {
+		return p.direct(args)
+	}
+	in, err := p.values(args)
+	if err != nil {
+		return err
+	}
+	if p.context {
+		in = append([]reflect.Value{reflect.ValueOf(&ctx).Elem()}, in...)
+	}
+	p.fn.Call(in)
+	return nil
+}
+
+// values converts args for a reflective call. Arguments beyond the parameters
+// of a non-variadic callback are dropped, and a nil argument becomes the zero
+// value of its parameter type.
+func (p *plan) values(args []any) ([]reflect.Value, error) {
+	if len(args) < len(p.params) {
+		return nil, fmt.Errorf("%w: missing argument %d", ErrArguments, len(args)+1)
+	}
+	if p.variadic == nil {
+		args = args[:len(p.params)]
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		t := p.variadic
+		if i < len(p.params) {
+			t = p.params[i]
+		}
+		if arg == nil {
+			in[i] = reflect.Zero(t)
+			continue
+		}
+		v := reflect.ValueOf(arg)
+		if !v.Type().AssignableTo(t) {
+			return nil, fmt.Errorf("%w: argument %d is %s, not %s", ErrArguments, i+1, v.Type(), t)
+		}
+		in[i] = v
+	}
+	return in, nil
+}
@@ -0,0 +1,431 @@
+// Package mux multiplexes byte streams over the binary frames of a socket.
+//
+// Each frame is a type byte, a big-endian uint32 stream ID and a payload.
+// A stream is opened with an open frame carrying the opener's receive window
+// and the stream's name, and answered with an accept frame carrying the
+// acceptor's window, or a close frame to refuse it. Data frames may carry no
+// more bytes than the receiver's window, which the receiver widens with
+// window frames as it reads. A close frame ends the stream in both directions.
+// Clients open streams with odd IDs and servers with even ones, so both ends
+// may open streams at once.
+package mux
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+)
+
+const (
+	frameOpen byte = iota + 1
+	frameAccept
+	frameData
+	frameWindow
+	frameClose
+)
+
+// DefaultWindow is how many bytes a stream buffers for its reader by default.
+const DefaultWindow = 256 << 10
+
+// maxPayload bounds the data carried by one frame.
+const maxPayload = 32 << 10
+
+// ErrRefused is returned by Open when the peer does not accept streams.
+var ErrRefused = errors.New("stream refused")
+
+// SendFunc writes a frame, waiting while the connection is busy until ctx is done.
+type SendFunc func(ctx context.Context, frame []byte) error
+
+// Session is one end of the streams multiplexed over a connection.
+type Session struct {
+	ctx    context.Context
+	send   SendFunc
+	window uint32
+
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+	nextID  uint32
+	accept  func(*Stream)
+	handle  map[string]func(*Stream) // by name prefix
+}
+
+// New returns a session writing its frames with send. The client end of a
+// connection passes client true. Streams buffer up to window bytes, or
+// DefaultWindow if window is not positive, and end when ctx is done.
+func New(ctx context.Context, send SendFunc, client bool, window int) *Session {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	m := &Session{
+		ctx:     ctx,
+		send:    send,
+		window:  uint32(window),
+		streams: make(map[uint32]*Stream),
+		nextID:  2,
+	}
+	if client {
+		m.nextID = 1
+	}
+	return m
+}
+
+// SetAccept sets the function receiving the streams opened by the peer, on a
+// goroutine of their own. Without one, streams are refused.
+func (m *Session) SetAccept(fn func(*Stream)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.accept = fn
+}
+
+// Handle sets the function receiving the streams opened by the peer whose
+// name starts with prefix, instead of the function set with SetAccept. A nil
+// fn removes it.
+func (m *Session) Handle(prefix string, fn func(*Stream)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if fn == nil {
+		delete(m.handle, prefix)
+		return
+	}
+	if m.handle == nil {
+		m.handle = make(map[string]func(*Stream))
+	}
+	m.handle[prefix] = fn
+}
+
+// acceptor returns the function receiving a stream named name, or nil. It
+// must be called with m.mu held.
+func (m *Session) acceptor(name string) func(*Stream) {
+	for prefix, fn := range m.handle {
+		if strings.HasPrefix(name, prefix) {
+			return fn
+		}
+	}
+	return m.accept
+}
+
+// Open opens a stream with the given name and waits until the peer accepts it.
+// It gives up when either ctx or the session's context is done.
+func (m *Session) Open(ctx context.Context, name string) (*Stream, error) {
+	// A send blocked on a full connection must not outlive the session
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(m.ctx, cancel)
+	defer stop()
+
+	m.mu.Lock()
+	id := m.nextID
+	m.nextID += 2
+	st := m.newStream(id, name, 0)
+	m.mu.Unlock()
+
+	payload := binary.BigEndian.AppendUint32(nil, m.window)
+	if err := m.send(ctx, frame(frameOpen, id, append(payload, name...))); err != nil {
+		st.end(err, err)
+		return nil, err
+	}
+
+	for {
+		st.mu.Lock()
+		accepted, err, wait := st.accepted, st.writeErr, st.changed
+		st.mu.Unlock()
+		switch {
+		case accepted:
+			return st, nil
+		case err != nil:
+			return nil, err
+		}
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			st.Close()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Receive handles a frame read from the connection. It does not wait for
+// the frame to be read by a stream.
+func (m *Session) Receive(f []byte) {
+	if len(f) < 5 {
+		return
+	}
+	typ, id, payload := f[0], binary.BigEndian.Uint32(f[1:5]), f[5:]
+
+	if typ == frameOpen {
+		m.opened(id, payload)
+		return
+	}
+
+	m.mu.Lock()
+	st := m.streams[id]
+	m.mu.Unlock()
+	if st == nil {
+		return
+	}
+
+	switch typ {
+	case frameAccept:
+		if len(payload) < 4 {
+			return
+		}
+		st.mu.Lock()
+		st.accepted = true
+		st.sendWindow += int64(binary.BigEndian.Uint32(payload))
+		st.broadcast()
+		st.mu.Unlock()
+	case frameData:
+		st.push(payload)
+	case frameWindow:
+		if len(payload) < 4 {
+			return
+		}
+		st.mu.Lock()
+		st.sendWindow += int64(binary.BigEndian.Uint32(payload))
+		st.broadcast()
+		st.mu.Unlock()
+	case frameClose:
+		st.mu.Lock()
+		accepted := st.accepted
+		st.mu.Unlock()
+		if accepted {
+			st.end(io.EOF, io.ErrClosedPipe)
+		} else {
+			st.end(ErrRefused, ErrRefused)
+		}
+	}
+}
+
+// opened accepts or refuses a stream opened by the peer.
+func (m *Session) opened(id uint32, payload []byte) {
+	if len(payload) < 4 {
+		return
+	}
+	name := string(payload[4:])
+	m.mu.Lock()
+	accept := m.acceptor(name)
+	// IDs of this end's parity are left for its own streams, which Open
+	// would otherwise replace
+	if accept == nil || id%2 == m.nextID%2 || m.streams[id] != nil {
+		m.mu.Unlock()
+		go m.send(m.ctx, frame(frameClose, id, nil))
+		return
+	}
+	st := m.newStream(id, name, int64(binary.BigEndian.Uint32(payload)))
+	st.accepted = true
+	m.mu.Unlock()
+
+	go func() {
+		if err := m.send(st.ctx, frame(frameAccept, id, binary.BigEndian.AppendUint32(nil, m.window))); err != nil {
+			st.end(err, err)
+			return
+		}
+		accept(st)
+	}()
+}
+
+// Reset ends every stream with err, as when the connection is lost. Reads
+// return the data already received, then err.
+func (m *Session) Reset(err error) {
+	m.mu.Lock()
+	streams := make([]*Stream, 0, len(m.streams))
+	for _, st := range m.streams {
+		streams = append(streams, st)
+	}
+	m.mu.Unlock()
+
+	for _, st := range streams {
+		st.end(err, err)
+	}
+}
+
+// newStream registers a stream. It must be called with m.mu held.
+func (m *Session) newStream(id uint32, name string, sendWindow int64) *Stream {
+	ctx, cancel := context.WithCancel(m.ctx)
+	st := &Stream{
+		session:    m,
+		id:         id,
+		name:       name,
+		ctx:        ctx,
+		cancel:     cancel,
+		sendWindow: sendWindow,
+		changed:    make(chan struct{}),
+	}
+	m.streams[id] = st
+	return st
+}
+
+func (m *Session) remove(id uint32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.streams, id)
+}
+
+func frame(typ byte, id uint32, payload []byte) []byte {
+	f := make([]byte, 5, 5+len(payload))
+	f[0] = typ
+	binary.BigEndian.PutUint32(f[1:], id)
+	return append(f, payload...)
+}
+
+// Stream is a byte stream multiplexed over a socket. Reads and writes may
+// run concurrently with each other.
+type Stream struct {
+	session *Session
+	id      uint32
+	name    string
+	ctx     context.Context // cancelled once the stream ends
+	cancel  context.CancelFunc
+
+	mu         sync.Mutex
+	changed    chan struct{} // closed and replaced on every change
+	accepted   bool
+	buf        bytes.Buffer
+	unacked    uint32 // bytes read since the window was last widened
+	sendWindow int64
+	readErr    error // returned once buf is drained
+	writeErr   error
+	closed     bool
+}
+
+// Name returns the name the stream was opened with.
+func (st *Stream) Name() string {
+	return st.name
+}
+
+// Read reads data sent by the peer. It returns io.EOF once the peer has
+// closed the stream and its data has been read.
+func (st *Stream) Read(p []byte) (int, error) {
+	for {
+		st.mu.Lock()
+		if st.buf.Len() > 0 {
+			n, _ := st.buf.Read(p)
+			st.unacked += uint32(n)
+			var widen uint32
+			if st.unacked >= st.session.window/2 && st.readErr == nil {
+				widen, st.unacked = st.unacked, 0
+			}
+			st.mu.Unlock()
+
+			if widen > 0 {
+				st.session.send(st.ctx, frame(frameWindow, st.id, binary.BigEndian.AppendUint32(nil, widen)))
+			}
+			return n, nil
+		}
+		if err := st.readErr; err != nil {
+			st.mu.Unlock()
+			return 0, err
+		}
+		wait := st.changed
+		st.mu.Unlock()
+		<-wait
+	}
+}
+
+// Write sends p to the peer, waiting while the peer's window is full.
+func (st *Stream) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		st.mu.Lock()
+		if err := st.writeErr; err != nil {
+			st.mu.Unlock()
+			return n, err
+		}
+		if st.sendWindow == 0 {
+			wait := st.changed
+			st.mu.Unlock()
+			<-wait
+			continue
+		}
+		k := int(min(int64(len(p)), st.sendWindow, maxPayload))
+		st.sendWindow -= int64(k)
+		st.mu.Unlock()
+
+		if err := st.session.send(st.ctx, frame(frameData, st.id, p[:k])); err != nil {
+			st.mu.Lock()
+			if st.writeErr != nil {
+				err = st.writeErr
+			}
+			st.mu.Unlock()
+			return n, err
+		}
+		n += k
+		p = p[k:]
+	}
+	return n, nil
+}
+
+// Close closes the stream in both directions. Data not yet read is discarded.
+func (st *Stream) Close() error {
+	st.mu.Lock()
+	if st.closed {
+		st.mu.Unlock()
+		return nil
+	}
+	st.closed = true
+	notify := st.writeErr == nil
+	st.readErr = io.ErrClosedPipe
+	st.writeErr = io.ErrClosedPipe
+	st.buf.Reset()
+	st.broadcast()
+	st.mu.Unlock()
+
+	st.session.remove(st.id)
+	var err error
+	if notify {
+		err = st.session.send(st.ctx, frame(frameClose, st.id, nil))
+	}
+	st.cancel()
+	return err
+}
+
+// push buffers data received from the peer.
+func (st *Stream) push(data []byte) {
+	st.mu.Lock()
+	if st.readErr != nil {
+		st.mu.Unlock()
+		return
+	}
+	if st.buf.Len()+len(data) > int(st.session.window) {
+		st.mu.Unlock()
+		// The peer ignored the window
+		err := errors.New("stream window exceeded")
+		st.end(err, err)
+		// Receive runs on the socket's reader, which must not wait for the
+		// connection's write buffer
+		go st.session.send(st.session.ctx, frame(frameClose, st.id, nil))
+		return
+	}
+	st.buf.Write(data)
+	st.broadcast()
+	st.mu.Unlock()
+}
+
+// end ends the stream. Reads return readErr once the data received is
+// drained, and writes return writeErr.
+func (st *Stream) end(readErr, writeErr error) {
+	st.mu.Lock()
+	if st.readErr == nil {
+		st.readErr = readErr
+	}
+	if st.writeErr == nil {
+		st.writeErr = writeErr
+	}
+	st.broadcast()
+	st.mu.Unlock()
+
+	st.session.remove(st.id)
+	st.cancel()
+}
+
+// broadcast wakes every goroutine waiting for a change. It must be called
+// with st.mu held.
+func (st *Stream) broadcast() {
+	close(st.changed)
+	st.changed = make(chan struct{})
+}
@@ -0,0 +1,231 @@
+package mux
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// pipe connects a client and a server session, each frame being received on
+// a reader goroutine of its own as over a connection.
+func pipe(t *testing.T, window int) (client, server *Session) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	toClient := make(chan []byte, 64)
+	toServer := make(chan []byte, 64)
+	sender := func(ch chan []byte) SendFunc {
+		return func(ctx context.Context, f []byte) error {
+			select {
+			case ch <- f:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	client = New(ctx, sender(toServer), true, window)
+	server = New(ctx, sender(toClient), false, window)
+	reader := func(ch chan []byte, m *Session) {
+		for {
+			select {
+			case f := <-ch:
+				m.Receive(f)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+	go reader(toClient, client)
+	go reader(toServer, server)
+	return client, server
+}
+
+func TestStream(t *testing.T) {
+	client, server := pipe(t, 1024)
+	server.SetAccept(func(st *Stream) {
+		if st.Name() != "echo" {
+			t.Errorf("unexpected name %q", st.Name())
+		}
+		io.Copy(st, st)
+		st.Close()
+	})
+
+	st, err := client.Open(context.Background(), "echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("0123456789"), 10000) // far larger than the window
+	go st.Write(data)
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(st, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("echoed data differs")
+	}
+	st.Close()
+	if _, err := st.Write([]byte("x")); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("expected ErrClosedPipe after Close, got %v", err)
+	}
+}
+
+func TestStreamWindow(t *testing.T) {
+	client, server := pipe(t, 100)
+	accepted := make(chan *Stream, 1)
+	server.SetAccept(func(st *Stream) { accepted <- st })
+
+	st, err := client.Open(context.Background(), "tail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := <-accepted
+
+	written := make(chan int, 1)
+	go func() {
+		n, _ := peer.Write(make([]byte, 250))
+		written <- n
+	}()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case n := <-written:
+		t.Fatalf("write of %d bytes ignored the window", n)
+	default:
+	}
+
+	if _, err := io.ReadFull(st, make([]byte, 250)); err != nil {
+		t.Fatal(err)
+	}
+	if n := <-written; n != 250 {
+		t.Errorf("expected 250 bytes written, got %d", n)
+	}
+
+	peer.Close()
+	if _, err := st.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected EOF once the peer closed, got %v", err)
+	}
+}
+
+func TestStreamRefusedAndReset(t *testing.T) {
+	client, server := pipe(t, 0)
+	if _, err := client.Open(context.Background(), "nobody"); err != ErrRefused {
+		t.Errorf("expected ErrRefused without an accept function, got %v", err)
+	}
+
+	handled := make(chan string, 1)
+	server.Handle("file:", func(st *Stream) {
+		handled <- st.Name()
+		st.Close()
+	})
+	if _, err := client.Open(context.Background(), "file:1"); err != nil || <-handled != "file:1" {
+		t.Errorf("expected the prefix handler to accept the stream, got %v", err)
+	}
+	if _, err := client.Open(context.Background(), "other"); err != ErrRefused {
+		t.Errorf("expected streams outside the prefix to be refused, got %v", err)
+	}
+
+	accepted := make(chan *Stream, 1)
+	client.SetAccept(func(st *Stream) { accepted <- st })
+	st, err := server.Open(context.Background(), "push")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := <-accepted
+	st.Write([]byte("last words"))
+	time.Sleep(20 * time.Millisecond)
+
+	lost := errors.New("connection lost")
+	peer.session.Reset(lost)
+	if data, err := io.ReadAll(peer); string(data) != "last words" || err != lost {
+		t.Errorf("expected the buffered data then the reset error, got %q, %v", data, err)
+	}
+	if _, err := peer.Write([]byte("x")); err != lost {
+		t.Errorf("expected writes to fail after a reset, got %v", err)
+	}
+}
+
+func TestOpenEndsWithSession(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	// The connection's writer is gone, so sends block until their context is done
+	blocked := func(ctx context.Context, f []byte) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	m := New(ctx, blocked, true, 0)
+
+	opened := make(chan error, 1)
+	go func() {
+		_, err := m.Open(context.Background(), "stuck")
+		opened <- err
+	}()
+	cancel()
+	select {
+	case err := <-opened:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected the session's cancellation, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Open outlived its session")
+	}
+}
+
+func TestMisbehavingPeer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sent := make(chan []byte, 4)
+	release := make(chan struct{})
+	// The connection is busy until released
+	m := New(ctx, func(ctx context.Context, f []byte) error {
+		select {
+		case <-release:
+			sent <- f
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}, false, 4)
+	m.SetAccept(func(st *Stream) {})
+
+	open := func(id uint32) []byte {
+		return frame(frameOpen, id, append([]byte{0, 0, 0, 4}, "x"...))
+	}
+
+	// Streams the peer opens with this end's IDs are refused
+	m.Receive(open(2))
+	m.mu.Lock()
+	taken := m.streams[2] != nil
+	m.mu.Unlock()
+	if taken {
+		t.Error("expected an ID of this end's parity to be refused")
+	}
+
+	// A peer overrunning the window does not hold up the reader while the
+	// connection is busy
+	m.Receive(open(1))
+	done := make(chan struct{})
+	go func() {
+		m.Receive(frame(frameData, 1, []byte("too much")))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected Receive not to wait for the connection")
+	}
+
+	close(release)
+	closed := map[uint32]bool{}
+	for !closed[1] || !closed[2] {
+		select {
+		case f := <-sent:
+			if f[0] == frameClose {
+				closed[binary.BigEndian.Uint32(f[1:5])] = true
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected both streams to be closed, got %v", closed)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+// Package reliable provides the building blocks of at-least-once delivery.
+// An Outbox keeps messages until the peer acknowledges them, resending with
+// exponential backoff, and a Window drops messages the receiver has already seen.
+package reliable
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Default retry and deduplication timings.
+const (
+	DefaultMinBackoff = 1 * time.Second
+	DefaultMaxBackoff = 30 * time.Second
+	DefaultWindow     = 10 * time.Minute
+)
+
+// SendFunc transmits a message to the peer. Delivery is best effort;
+// a message that is lost is sent again when its retry is due.
+type SendFunc func(id string, payload []any)
+
+// Outbox holds messages until they are acknowledged.
+// While attached to a connection it sends each message and resends it with
+// exponential backoff; while detached it keeps messages until the next Attach.
+type Outbox struct {
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	mu      sync.Mutex
+	send    SendFunc
+	pending map[string]*entry
+	seq     uint64
+	closed  bool
+}
+
+type entry struct {
+	seq     uint64
+	payload []any
+	backoff time.Duration
+	timer   *time.Timer
+}
+
+// NewOutbox creates a detached Outbox with the default backoff.
+func NewOutbox() *Outbox {
+	return &Outbox{
+		MinBackoff: DefaultMinBackoff,
+		MaxBackoff: DefaultMaxBackoff,
+		pending:    make(map[string]*entry),
+	}
+}
+
+// Add stores a message under id and sends it if the outbox is attached.
+func (o *Outbox) Add(id string, payload []any) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed {
+		return
+	}
+	o.seq++
+	e := &entry{seq: o.seq, payload: payload, backoff: o.MinBackoff}
+	o.pending[id] = e
+	o.transmit(id, e)
+}
+
+// Ack forgets the message with the given id.
+func (o *Outbox) Ack(id string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if e, ok := o.pending[id]; ok {
+		if e.timer != nil {
+			e.timer.Stop()
+		}
+		delete(o.pending, id)
+	}
+}
+
+// Attach sets the function used to send messages and immediately resends
+// every pending message, oldest first.
+func (o *Outbox) Attach(send SendFunc) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.send = send
+	for _, id := range o.ordered() {
+		e := o.pending[id]
+		if e.timer != nil {
+			e.timer.Stop()
+		}
+		e.backoff = o.MinBackoff
+		o.transmit(id, e)
+	}
+}
+
+// Detach stops sending until the next Attach. Pending messages are kept.
+func (o *Outbox) Detach() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.send = nil
+	for _, e := range o.pending {
+		if e.timer != nil {
+			e.timer.Stop()
+			e.timer = nil
+		}
+	}
+}
+
+// Close detaches the outbox and drops every pending message.
+func (o *Outbox) Close() {
+	o.Detach()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.closed = true
+	o.pending = make(map[string]*entry)
+}
+
+// Len returns the number of unacknowledged messages.
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.pending)
+}
+
+// ordered returns the pending IDs, oldest first. The caller must hold mu.
+func (o *Outbox) ordered() []string {
+	ids := make([]string, 0, len(o.pending))
+	for id := range o.pending {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, func(a, b string) int {
+		return cmp.Compare(o.pending[a].seq, o.pending[b].seq)
+	})
+	return ids
+}
+
+// transmit sends e if attached and schedules its next retry. The caller must hold mu.
+func (o *Outbox) transmit(id string, e *entry) {
+	if o.send == nil {
+		return
+	}
+	o.send(id, e.payload)
+
+	delay := e.backoff
+	e.backoff = min(e.backoff*2, o.MaxBackoff)
+	e.timer = time.AfterFunc(delay, func() {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		if current, ok := o.pending[id]; ok && current == e {
+			o.transmit(id, e)
+		}
+	})
+}
+
+// Window remembers message IDs seen within a duration so duplicates can be dropped.
+type Window struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+// NewWindow creates a Window remembering IDs for ttl.
+func NewWindow(ttl time.Duration) *Window {
+	return &Window{ttl: ttl, seen: make(map[string]time.Time)}
+}
+
+// Seen records id and reports whether it had already been seen within the window.
+func (w *Window) Seen(id string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.has(id) {
+		return true
+	}
+	w.seen[id] = time.Now()
+	return false
+}
+
+// Has reports whether id was recorded within the window, without recording it.
+func (w *Window) Has(id string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.has(id)
+}
+
+// Add records id.
+func (w *Window) Add(id string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.seen[id] = time.Now()
+}
+
+// has is Has, pruning expired IDs now and then. The caller must hold mu.
+func (w *Window) has(id string) bool {
+	now := time.Now()
+	if now.Sub(w.lastPrune) > w.ttl/10 {
+		for k, t := range w.seen {
+			if now.Sub(t) > w.ttl {
+				delete(w.seen, k)
+			}
+		}
+		w.lastPrune = now
+	}
+
+	t, ok := w.seen[id]
+	return ok && now.Sub(t) <= w.ttl
+}
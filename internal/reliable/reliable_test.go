@@ -0,0 +1,90 @@
+package reliable
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type recorder struct {
+	mu  sync.Mutex
+	ids []string
+}
+
+func (r *recorder) send(id string, payload []any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ids = append(r.ids, id)
+}
+
+func (r *recorder) sent() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.ids...)
+}
+
+func TestOutboxRetriesUntilAck(t *testing.T) {
+	o := NewOutbox()
+	o.MinBackoff = 10 * time.Millisecond
+	o.MaxBackoff = 20 * time.Millisecond
+	r := &recorder{}
+	o.Attach(r.send)
+
+	o.Add("a", []any{"event"})
+	time.Sleep(50 * time.Millisecond)
+	if n := len(r.sent()); n < 3 {
+		t.Errorf("expected retries, got %d sends", n)
+	}
+
+	o.Ack("a")
+	n := len(r.sent())
+	time.Sleep(50 * time.Millisecond)
+	if len(r.sent()) != n {
+		t.Error("expected no sends after ack")
+	}
+	if o.Len() != 0 {
+		t.Errorf("expected empty outbox, got %d", o.Len())
+	}
+}
+
+func TestOutboxResendsOnAttach(t *testing.T) {
+	o := NewOutbox()
+	o.Add("a", nil)
+	o.Add("b", nil)
+	o.Add("c", nil)
+	o.Ack("b")
+
+	r := &recorder{}
+	o.Attach(r.send)
+	if sent := r.sent(); len(sent) != 2 || sent[0] != "a" || sent[1] != "c" {
+		t.Errorf("expected a and c in order, got %v", sent)
+	}
+
+	o.Detach()
+	o.Close()
+	o.Add("d", nil)
+	if o.Len() != 0 {
+		t.Error("expected closed outbox to drop messages")
+	}
+}
+
+func TestWindow(t *testing.T) {
+	w := NewWindow(20 * time.Millisecond)
+	if w.Has("b") {
+		t.Error("expected b not to be seen")
+	}
+	w.Add("b")
+	if !w.Has("b") || !w.Seen("b") {
+		t.Error("expected b to be seen once added")
+	}
+	if w.Seen("a") {
+		t.Error("expected first sighting")
+	}
+	if !w.Seen("a") {
+		t.Error("expected duplicate")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if w.Seen("a") {
+		t.Error("expected ID to expire from window")
+	}
+}
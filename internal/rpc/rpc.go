@@ -0,0 +1,47 @@
+// Package rpc defines the errors of service calls, shared by the server that
+// answers them and the client that makes them.
+package rpc
+
+import "context"
+
+// Code classifies an Error.
+type Code string
+
+// Error codes.
+const (
+	// InvalidArgument is returned for a request the method cannot decode.
+	InvalidArgument Code = "invalid_argument"
+	// NotFound is returned for a method that is not registered.
+	NotFound Code = "not_found"
+	// PermissionDenied is for middleware refusing a call.
+	PermissionDenied Code = "permission_denied"
+	// DeadlineExceeded is returned when the call's deadline passes.
+	DeadlineExceeded Code = "deadline_exceeded"
+	// Canceled is returned when the caller cancels the call.
+	Canceled Code = "canceled"
+	// Internal is returned for any other error of a method.
+	Internal Code = "internal"
+)
+
+// Error is the error of a service call, as sent to the caller.
+type Error struct {
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return string(e.Code) + ": " + e.Message
+}
+
+// Is reports the codes DeadlineExceeded and Canceled as the errors of
+// context, so that callers can check for either with errors.Is whichever side
+// noticed the context expire first.
+func (e *Error) Is(target error) bool {
+	switch e.Code {
+	case DeadlineExceeded:
+		return target == context.DeadlineExceeded
+	case Canceled:
+		return target == context.Canceled
+	}
+	return false
+}
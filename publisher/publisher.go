@@ -0,0 +1,112 @@
+// Package publisher lets Go processes broadcast to the clients of a cluster of
+// servers without holding a socket, the way a worker notifies users:
+//
+//	pub := publisher.New(redisadapter.NewPublisher(redisadapter.Options{Addr: "localhost:6379"}))
+//	defer pub.Close()
+//	pub.Of("/chat").To("room").Emit("message", "deploy finished")
+//
+// Operations travel over the adapter channel the servers already share, so
+// the transport must match their adapter: redisadapter.NewPublisher for the
+// Redis adapter and cluster.NewPublisher for the peer mesh. Events published
+// this way are not recorded in room history and cannot be persistent, since
+// no server originates them.
+package publisher
+
+import (
+	"encoding/json"
+
+	"github.com/givensuman/go-sockets/server"
+)
+
+// Transport carries operations to every server of a cluster.
+type Transport interface {
+	// Publish sends op to the servers serving namespace.
+	Publish(namespace string, op server.Operation) error
+	// Close releases the transport's connections.
+	Close() error
+}
+
+// Publisher broadcasts to the sockets of a cluster through a Transport.
+type Publisher struct {
+	transport Transport
+}
+
+// New creates a Publisher using transport.
+func New(transport Transport) *Publisher {
+	return &Publisher{transport: transport}
+}
+
+// Of returns an Operator targeting every socket in namespace.
+// If namespace is empty, it defaults to "/".
+func (p *Publisher) Of(namespace string) *Operator {
+	if namespace == "" {
+		namespace = "/"
+	}
+	return &Operator{publisher: p, namespace: namespace}
+}
+
+// Close closes the transport.
+func (p *Publisher) Close() error {
+	return p.transport.Close()
+}
+
+// Operator selects sockets like server.BroadcastOperator.
+type Operator struct {
+	publisher *Publisher
+	namespace string
+	rooms     []string // targets must be in every room; none means the whole namespace
+	except    []string // socket IDs never targeted
+}
+
+// To filters the targets to sockets in room. Every socket is in a room named
+// after its ID, and sockets bound to a user are in "user:<id>".
+func (o *Operator) To(room string) *Operator {
+	op := *o
+	op.rooms = append(o.rooms[:len(o.rooms):len(o.rooms)], room)
+	return &op
+}
+
+// Except skips the sockets with the given IDs.
+func (o *Operator) Except(ids ...string) *Operator {
+	op := *o
+	op.except = append(o.except[:len(o.except):len(o.except)], ids...)
+	return &op
+}
+
+// Emit broadcasts an event to the targets.
+func (o *Operator) Emit(event string, args ...any) error {
+	data, err := json.Marshal(append([]any{event}, args...))
+	if err != nil {
+		return err
+	}
+	op := o.operation(server.OpBroadcast)
+	op.Data = data
+	return o.publish(op)
+}
+
+// SocketsJoin makes the targets join rooms.
+func (o *Operator) SocketsJoin(rooms ...string) error {
+	op := o.operation(server.OpJoin)
+	op.RoomChange = rooms
+	return o.publish(op)
+}
+
+// SocketsLeave makes the targets leave rooms.
+func (o *Operator) SocketsLeave(rooms ...string) error {
+	op := o.operation(server.OpLeave)
+	op.RoomChange = rooms
+	return o.publish(op)
+}
+
+// DisconnectSockets disconnects the targets.
+func (o *Operator) DisconnectSockets() error {
+	return o.publish(o.operation(server.OpDisconnect))
+}
+
+func (o *Operator) operation(opType server.OperationType) server.Operation {
+	return server.Operation{Type: opType, Rooms: o.rooms, Except: o.except}
+}
+
+func (o *Operator) publish(op server.Operation) error {
+	return o.publisher.transport.Publish(o.namespace, op)
+}
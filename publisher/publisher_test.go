@@ -0,0 +1,60 @@
+package publisher
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/givensuman/go-sockets/server"
+)
+
+type recorder struct {
+	namespaces []string
+	ops        []server.Operation
+}
+
+func (r *recorder) Publish(namespace string, op server.Operation) error {
+	r.namespaces = append(r.namespaces, namespace)
+	r.ops = append(r.ops, op)
+	return nil
+}
+
+func (r *recorder) Close() error {
+	return nil
+}
+
+func TestOperator(t *testing.T) {
+	r := &recorder{}
+	pub := New(r)
+
+	chat := pub.Of("/chat").To("room").Except("s1")
+	if err := chat.Emit("message", "hi", 1); err != nil {
+		t.Fatal(err)
+	}
+	pub.Of("").To("user:alice").SocketsJoin("vip")
+	chat.To("other").DisconnectSockets()
+
+	if !slices.Equal(r.namespaces, []string{"/chat", "/", "/chat"}) {
+		t.Errorf("unexpected namespaces %v", r.namespaces)
+	}
+
+	emit := r.ops[0]
+	var data []any
+	json.Unmarshal(emit.Data, &data)
+	if emit.Type != server.OpBroadcast || !slices.Equal(emit.Rooms, []string{"room"}) ||
+		!slices.Equal(emit.Except, []string{"s1"}) || len(data) != 3 || data[0] != "message" {
+		t.Errorf("unexpected broadcast %+v", emit)
+	}
+
+	if join := r.ops[1]; join.Type != server.OpJoin || !slices.Equal(join.RoomChange, []string{"vip"}) {
+		t.Errorf("unexpected join %+v", join)
+	}
+
+	// Chaining must not modify the operator it started from
+	if disconnect := r.ops[2]; !slices.Equal(disconnect.Rooms, []string{"room", "other"}) {
+		t.Errorf("unexpected disconnect targets %v", disconnect.Rooms)
+	}
+	if len(chat.rooms) != 1 {
+		t.Errorf("chained To modified the original operator: %v", chat.rooms)
+	}
+}
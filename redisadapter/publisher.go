@@ -0,0 +1,41 @@
+package redisadapter
+
+import (
+	"encoding/json"
+
+	"github.com/givensuman/go-sockets/server"
+	"github.com/google/uuid"
+)
+
+// Publisher publishes operations to the servers using the Redis adapter, for
+// processes that broadcast without serving sockets. It implements
+// publisher.Transport.
+type Publisher struct {
+	opts Options
+	node string
+	pub  *lazyConn
+}
+
+// NewPublisher creates a Publisher. It connects on the first Publish.
+func NewPublisher(opts Options) *Publisher {
+	return &Publisher{
+		opts: opts,
+		node: uuid.New().String(),
+		pub:  &lazyConn{opts: opts},
+	}
+}
+
+// Publish sends op to every server serving namespace.
+func (p *Publisher) Publish(namespace string, op server.Operation) error {
+	data, err := json.Marshal(Message{Node: p.node, Op: op})
+	if err != nil {
+		return err
+	}
+	_, err = p.pub.command("PUBLISH", p.opts.Channel(namespace), string(data))
+	return err
+}
+
+// Close closes the connection to Redis.
+func (p *Publisher) Close() error {
+	return p.pub.Close()
+}
@@ -0,0 +1,321 @@
+// Package redisadapter connects go-sockets servers through Redis pub/sub, so
+// that broadcasts, room operations and socket lookups reach the sockets
+// connected to every server process:
+//
+//	srv := server.NewServer(server.WithAdapter(redisadapter.New(redisadapter.Options{
+//		Addr: "localhost:6379",
+//	})))
+//
+// Each namespace publishes its operations on one channel, which every node
+// serving the namespace subscribes to. Replies to requests such as
+// BroadcastOperator.FetchSockets are published on a channel private to the
+// requesting node.
+package redisadapter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/givensuman/go-sockets/server"
+	"github.com/google/uuid"
+)
+
+// DefaultPrefix starts the name of every channel unless Options.Prefix is set.
+const DefaultPrefix = "go-sockets"
+
+// Reconnection backoff of the subscription.
+const (
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 10 * time.Second
+)
+
+// ErrClosed is returned by an adapter that has been closed.
+var ErrClosed = errors.New("redisadapter: closed")
+
+// Options configures the Redis connection.
+type Options struct {
+	// Addr is the host:port of the Redis server.
+	Addr string
+	// Password authenticates the connection if set.
+	Password string
+	// Prefix starts the name of every channel; DefaultPrefix if empty.
+	// Servers only see each other when they use the same prefix.
+	Prefix string
+}
+
+// Channel returns the channel carrying the operations of a namespace.
+func (o Options) Channel(namespace string) string {
+	prefix := o.Prefix
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	return prefix + "#" + namespace + "#"
+}
+
+// Message is published on a namespace channel for every operation.
+type Message struct {
+	// Node identifies the publishing node, which ignores its own messages.
+	Node string `json:"node"`
+	// Request is set when the publisher waits for replies, which each node
+	// publishes on the publisher's response channel.
+	Request string           `json:"request,omitempty"`
+	Op      server.Operation `json:"op"`
+}
+
+// response is a node's reply to a request.
+type response struct {
+	Request string       `json:"request"`
+	Reply   server.Reply `json:"reply"`
+}
+
+// Adapter is a server.Adapter for one namespace, using one connection for
+// publishing and one for the subscription.
+type Adapter struct {
+	ns       *server.Namespace
+	opts     Options
+	node     string
+	channel  string
+	response string
+
+	pub *lazyConn
+
+	subMu sync.Mutex
+	sub   *conn
+
+	pending   sync.Map // map[string]chan server.Reply
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// New returns an AdapterFactory for server.WithAdapter.
+func New(opts Options) server.AdapterFactory {
+	return func(ns *server.Namespace) (server.Adapter, error) {
+		a, err := NewAdapter(ns, opts)
+		if err != nil {
+			// A nil *Adapter would make a non-nil server.Adapter
+			return nil, err
+		}
+		return a, nil
+	}
+}
+
+// NewAdapter subscribes to the channels of ns and returns its adapter.
+func NewAdapter(ns *server.Namespace, opts Options) (*Adapter, error) {
+	a := &Adapter{
+		ns:      ns,
+		opts:    opts,
+		node:    uuid.New().String(),
+		channel: opts.Channel(ns.Name()),
+		pub:     &lazyConn{opts: opts},
+		closed:  make(chan struct{}),
+	}
+	a.response = a.channel + a.node + "#"
+
+	sub, err := a.subscribe()
+	if err != nil {
+		return nil, err
+	}
+	a.sub = sub
+	go a.listen(sub)
+	return a, nil
+}
+
+// Publish implements server.Adapter.
+func (a *Adapter) Publish(op server.Operation) error {
+	return a.publish(a.channel, Message{Node: a.node, Op: op})
+}
+
+// Request implements server.Adapter.
+func (a *Adapter) Request(ctx context.Context, op server.Operation) ([]server.Reply, error) {
+	nodes, err := a.subscribers()
+	if err != nil {
+		return nil, err
+	}
+	// The count includes this node
+	if nodes <= 1 {
+		return nil, nil
+	}
+
+	id := uuid.New().String()
+	replies := make(chan server.Reply, nodes-1)
+	a.pending.Store(id, replies)
+	defer a.pending.Delete(id)
+
+	if err := a.publish(a.channel, Message{Node: a.node, Request: id, Op: op}); err != nil {
+		return nil, err
+	}
+
+	var collected []server.Reply
+	for len(collected) < nodes-1 {
+		select {
+		case reply := <-replies:
+			collected = append(collected, reply)
+		case <-ctx.Done():
+			return collected, ctx.Err()
+		case <-a.closed:
+			return collected, ErrClosed
+		}
+	}
+	return collected, nil
+}
+
+// Close implements server.Adapter.
+func (a *Adapter) Close() error {
+	a.closeOnce.Do(func() {
+		close(a.closed)
+
+		a.subMu.Lock()
+		if a.sub != nil {
+			a.sub.Close()
+		}
+		a.subMu.Unlock()
+
+		a.pub.Close()
+	})
+	return nil
+}
+
+// publish encodes v and publishes it on channel, redialing once if the
+// publishing connection was lost.
+func (a *Adapter) publish(channel string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = a.command("PUBLISH", channel, string(data))
+	return err
+}
+
+// subscribers returns the number of nodes subscribed to the namespace channel.
+func (a *Adapter) subscribers() (int, error) {
+	reply, err := a.command("PUBSUB", "NUMSUB", a.channel)
+	if err != nil {
+		return 0, err
+	}
+	items, ok := reply.([]any)
+	if !ok || len(items) != 2 {
+		return 0, fmt.Errorf("redisadapter: unexpected NUMSUB reply %v", reply)
+	}
+	n, ok := items[1].(int64)
+	if !ok {
+		return 0, fmt.Errorf("redisadapter: unexpected NUMSUB reply %v", reply)
+	}
+	return int(n), nil
+}
+
+// command runs a command on the publishing connection.
+func (a *Adapter) command(args ...string) (any, error) {
+	return a.pub.command(args...)
+}
+
+// subscribe opens a connection subscribed to the namespace channel and the
+// node's response channel.
+func (a *Adapter) subscribe() (*conn, error) {
+	c, err := dial(a.opts.Addr, a.opts.Password)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.write("SUBSCRIBE", a.channel, a.response); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	// Wait for both confirmations so nothing published afterwards is missed
+	for range 2 {
+		reply, err := c.read()
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		if e, ok := reply.(redisError); ok {
+			c.Close()
+			return nil, e
+		}
+	}
+	return c, nil
+}
+
+// listen handles messages on sub, resubscribing with backoff whenever the
+// connection is lost, until the adapter is closed.
+func (a *Adapter) listen(sub *conn) {
+	backoff := minBackoff
+	for {
+		for {
+			reply, err := sub.read()
+			if err != nil {
+				break
+			}
+			backoff = minBackoff
+
+			items, ok := reply.([]any)
+			if !ok || len(items) != 3 || items[0] != "message" {
+				continue
+			}
+			channel, _ := items[1].(string)
+			payload, _ := items[2].(string)
+			a.handle(channel, []byte(payload))
+		}
+		sub.Close()
+
+		for {
+			select {
+			case <-a.closed:
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, maxBackoff)
+
+			var err error
+			if sub, err = a.subscribe(); err == nil {
+				break
+			}
+			log.Println("redisadapter: resubscribe failed:", err)
+		}
+
+		a.subMu.Lock()
+		select {
+		case <-a.closed:
+			a.subMu.Unlock()
+			sub.Close()
+			return
+		default:
+			a.sub = sub
+		}
+		a.subMu.Unlock()
+	}
+}
+
+// handle processes a message received on channel.
+func (a *Adapter) handle(channel string, payload []byte) {
+	if channel == a.response {
+		var resp response
+		if err := json.Unmarshal(payload, &resp); err != nil {
+			return
+		}
+		if replies, ok := a.pending.Load(resp.Request); ok {
+			select {
+			case replies.(chan server.Reply) <- resp.Reply:
+			default:
+			}
+		}
+		return
+	}
+
+	var msg Message
+	if err := json.Unmarshal(payload, &msg); err != nil || msg.Node == a.node {
+		return
+	}
+
+	reply := a.ns.Apply(msg.Op)
+	if msg.Request != "" {
+		err := a.publish(a.channel+msg.Node+"#", response{Request: msg.Request, Reply: reply})
+		if err != nil {
+			log.Println("redisadapter: reply failed:", err)
+		}
+	}
+}
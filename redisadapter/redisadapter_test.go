@@ -0,0 +1,286 @@
+package redisadapter
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/givensuman/go-sockets/client"
+	"github.com/givensuman/go-sockets/server"
+)
+
+// fakeRedis is an in-process stand-in for Redis supporting the pub/sub
+// commands used by the adapter.
+type fakeRedis struct {
+	ln   net.Listener
+	mu   sync.Mutex
+	subs map[string]map[*fakeClient]bool
+}
+
+type fakeClient struct {
+	mu sync.Mutex
+	nc net.Conn
+}
+
+func (c *fakeClient) write(s string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nc.Write([]byte(s))
+}
+
+func bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+func startFakeRedis(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRedis{ln: ln, subs: make(map[string]map[*fakeClient]bool)}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(&fakeClient{nc: nc})
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func (r *fakeRedis) serve(c *fakeClient) {
+	defer func() {
+		r.mu.Lock()
+		for _, clients := range r.subs {
+			delete(clients, c)
+		}
+		r.mu.Unlock()
+		c.nc.Close()
+	}()
+
+	reader := bufio.NewReader(c.nc)
+	for {
+		var n int
+		if _, err := fmt.Fscanf(reader, "*%d\r\n", &n); err != nil {
+			return
+		}
+		args := make([]string, n)
+		for i := range args {
+			var size int
+			if _, err := fmt.Fscanf(reader, "$%d\r\n", &size); err != nil {
+				return
+			}
+			buf := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, buf); err != nil {
+				return
+			}
+			args[i] = string(buf[:size])
+		}
+
+		switch strings.ToUpper(args[0]) {
+		case "SUBSCRIBE":
+			r.mu.Lock()
+			for i, channel := range args[1:] {
+				if r.subs[channel] == nil {
+					r.subs[channel] = make(map[*fakeClient]bool)
+				}
+				r.subs[channel][c] = true
+				c.write(fmt.Sprintf("*3\r\n%s%s:%d\r\n", bulk("subscribe"), bulk(channel), i+1))
+			}
+			r.mu.Unlock()
+
+		case "PUBLISH":
+			r.mu.Lock()
+			var receivers []*fakeClient
+			for sub := range r.subs[args[1]] {
+				receivers = append(receivers, sub)
+			}
+			r.mu.Unlock()
+			for _, sub := range receivers {
+				sub.write("*3\r\n" + bulk("message") + bulk(args[1]) + bulk(args[2]))
+			}
+			c.write(fmt.Sprintf(":%d\r\n", len(receivers)))
+
+		case "PUBSUB":
+			r.mu.Lock()
+			count := len(r.subs[args[2]])
+			r.mu.Unlock()
+			c.write(fmt.Sprintf("*2\r\n%s:%d\r\n", bulk(args[2]), count))
+
+		default:
+			c.write("-ERR unknown command\r\n")
+		}
+	}
+}
+
+// startNode starts a server connected to the Redis stand-in at addr and
+// returns it along with its WebSocket URL.
+func startNode(t *testing.T, addr string) (*server.Server, string) {
+	srv := server.NewServer(server.WithAdapter(New(Options{Addr: addr})))
+	httpServer := httptest.NewServer(srv)
+	t.Cleanup(func() {
+		httpServer.Close()
+		srv.Close()
+	})
+	return srv, "ws" + strings.TrimPrefix(httpServer.URL, "http")
+}
+
+// connect connects a client to url, waits for the server to register it and
+// returns it along with a channel receiving its "news" events.
+func connect(t *testing.T, srv *server.Server, url string) (*client.Socket, chan string) {
+	connected := make(chan *server.Socket, 1)
+	srv.Of("/").Once("connection", func(s *server.Socket) {
+		connected <- s
+	})
+
+	news := make(chan string, 10)
+	c, err := client.Connect(url, "/", func(s *client.Socket) {
+		s.On("news", func(msg string) {
+			news <- msg
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+
+	select {
+	case <-connected:
+	case <-time.After(1 * time.Second):
+		t.Fatal("client not connected")
+	}
+	return c, news
+}
+
+func expectNews(t *testing.T, news chan string, want string) {
+	t.Helper()
+	select {
+	case msg := <-news:
+		if msg != want {
+			t.Errorf("expected %q, got %q", want, msg)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatalf("%q not received", want)
+	}
+}
+
+func TestBroadcastAcrossNodes(t *testing.T) {
+	addr := startFakeRedis(t)
+	nodeA, urlA := startNode(t, addr)
+	nodeB, urlB := startNode(t, addr)
+
+	_, newsA := connect(t, nodeA, urlA)
+	_, newsB := connect(t, nodeB, urlB)
+
+	nodeB.Of("/").Broadcast().Emit("news", "hello")
+	expectNews(t, newsA, "hello")
+	expectNews(t, newsB, "hello")
+}
+
+func TestRoomOperationsAcrossNodes(t *testing.T) {
+	addr := startFakeRedis(t)
+	nodeA, urlA := startNode(t, addr)
+	nodeB, urlB := startNode(t, addr)
+
+	_, newsA := connect(t, nodeA, urlA)
+	_, newsB := connect(t, nodeB, urlB)
+
+	// Make every socket join "lobby" from node A
+	nodeA.Of("/").Broadcast().SocketsJoin("lobby")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	found, err := nodeA.Of("/").To("lobby").FetchSockets(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 {
+		t.Fatalf("expected 2 sockets in lobby, got %d", len(found))
+	}
+	for _, rs := range found {
+		if !slices.Contains(rs.Rooms, "lobby") {
+			t.Errorf("socket %s rooms %v do not include lobby", rs.ID, rs.Rooms)
+		}
+	}
+
+	nodeB.Of("/").To("lobby").Emit("news", "lobby message")
+	expectNews(t, newsA, "lobby message")
+	expectNews(t, newsB, "lobby message")
+
+	// Reach a socket on the other node through its RemoteSocket
+	for _, rs := range found {
+		rs.Emit("news", "direct")
+	}
+	expectNews(t, newsA, "direct")
+	expectNews(t, newsB, "direct")
+}
+
+func TestPersistentAcrossNodes(t *testing.T) {
+	addr := startFakeRedis(t)
+	nodeA, _ := startNode(t, addr)
+	nodeB, urlB := startNode(t, addr)
+
+	// The nodes share the store, as acknowledgments reach only one of them
+	store := server.NewMemoryInbox()
+	nodeA.Of("/").SetInbox(store, 0, 0)
+	nodeB.Of("/").SetInbox(store, 0, 0)
+	nodeB.Of("/").Use(func(s *server.Socket) error {
+		s.SetUser("alice")
+		return nil
+	})
+	_, news := connect(t, nodeB, urlB)
+
+	// Alice is connected to node B, which delivers the stored item, and her
+	// acknowledgment removes it
+	nodeA.Of("/").ToUser("alice").Persistent().Emit("news", "online")
+	expectNews(t, news, "online")
+	deadline := time.Now().Add(1 * time.Second)
+	for time.Now().Before(deadline) {
+		if items, _ := store.List("alice"); len(items) == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if items, _ := store.List("alice"); len(items) != 0 {
+		t.Errorf("expected empty inbox, got %d items", len(items))
+	}
+
+	nodeA.Of("/").ToUser("bob").Persistent().Emit("news", "offline")
+	deadline = time.Now().Add(1 * time.Second)
+	for time.Now().Before(deadline) {
+		if items, _ := store.List("bob"); len(items) == 1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("event for offline user not stored")
+}
+
+func TestPublisher(t *testing.T) {
+	addr := startFakeRedis(t)
+	nodeA, urlA := startNode(t, addr)
+	nodeB, urlB := startNode(t, addr)
+
+	_, newsA := connect(t, nodeA, urlA)
+	_, newsB := connect(t, nodeB, urlB)
+
+	pub := NewPublisher(Options{Addr: addr})
+	defer pub.Close()
+	if err := pub.Publish("/", server.Operation{Type: server.OpBroadcast, Data: []byte(`["news","from worker"]`)}); err != nil {
+		t.Fatal(err)
+	}
+	expectNews(t, newsA, "from worker")
+	expectNews(t, newsB, "from worker")
+}
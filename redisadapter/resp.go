@@ -0,0 +1,189 @@
+package redisadapter
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// dialTimeout bounds how long connecting to Redis may take.
+const dialTimeout = 5 * time.Second
+
+// redisError is an error reply from the server.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// conn is a minimal RESP2 connection, supporting the commands the adapter needs.
+type conn struct {
+	mu sync.Mutex // serializes commands
+	nc net.Conn
+	r  *bufio.Reader
+}
+
+// dial connects to the Redis server at addr, authenticating if password is set.
+func dial(addr, password string) (*conn, error) {
+	nc, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &conn{nc: nc, r: bufio.NewReader(nc)}
+	if password != "" {
+		if _, err := c.do("AUTH", password); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// do sends a command and returns its reply.
+func (c *conn) do(args ...string) (any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.write(args...); err != nil {
+		return nil, err
+	}
+	reply, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := reply.(redisError); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+// write sends a command as an array of bulk strings.
+func (c *conn) write(args ...string) error {
+	buf := fmt.Appendf(nil, "*%d\r\n", len(args))
+	for _, arg := range args {
+		buf = fmt.Appendf(buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := c.nc.Write(buf)
+	return err
+}
+
+// read parses one reply. Simple and bulk strings are returned as string,
+// integers as int64, arrays as []any, errors as redisError and nulls as nil.
+func (c *conn) read() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: malformed reply")
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+
+	case '-':
+		return redisError(body), nil
+
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
+
+// Close closes the connection.
+func (c *conn) Close() error {
+	return c.nc.Close()
+}
+
+// lazyConn is a connection for commands that is dialed on first use and
+// redialed when it is lost.
+type lazyConn struct {
+	opts Options
+
+	mu     sync.Mutex
+	c      *conn
+	closed bool
+}
+
+// command runs a command, retrying once on a new connection if the current
+// one is broken.
+func (l *lazyConn) command(args ...string) (any, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		if l.closed {
+			return nil, ErrClosed
+		}
+
+		if l.c == nil {
+			c, err := dial(l.opts.Addr, l.opts.Password)
+			if err != nil {
+				return nil, err
+			}
+			l.c = c
+		}
+
+		reply, err := l.c.do(args...)
+		var redisErr redisError
+		if err == nil || errors.As(err, &redisErr) || attempt > 0 {
+			return reply, err
+		}
+
+		l.c.Close()
+		l.c = nil
+	}
+}
+
+// Close closes the connection; later commands fail with ErrClosed.
+func (l *lazyConn) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closed = true
+	if l.c == nil {
+		return nil
+	}
+	err := l.c.Close()
+	l.c = nil
+	return err
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/givensuman/go-sockets"
+)
+
+// OperationType identifies a cluster operation.
+type OperationType int
+
+// Operations applied to the sockets selected by an Operation.
+const (
+	// OpBroadcast sends the encoded event in Data.
+	OpBroadcast OperationType = iota
+	// OpJoin makes the sockets join RoomChange.
+	OpJoin
+	// OpLeave makes the sockets leave RoomChange.
+	OpLeave
+	// OpDisconnect disconnects the sockets.
+	OpDisconnect
+	// OpFetch describes the sockets in the Reply.
+	OpFetch
+)
+
+// Operation is an action on a set of sockets that an Adapter carries to every
+// node serving a namespace, where it is applied with Namespace.Apply.
+type Operation struct {
+	Type OperationType `json:"type"`
+	// Rooms and Except select sockets the same way as a BroadcastOperator:
+	// sockets in every room in Rooms, or in the whole namespace if Rooms is
+	// empty, and never the sockets whose IDs are in Except.
+	Rooms  []string `json:"rooms,omitempty"`
+	Except []string `json:"except,omitempty"`
+	// Data is the encoded event array of an OpBroadcast.
+	Data json.RawMessage `json:"data,omitempty"`
+	// RoomChange lists the rooms joined or left by OpJoin and OpLeave.
+	RoomChange []string `json:"roomChange,omitempty"`
+	// Time is when an OpBroadcast was recorded in room history, or zero if
+	// no room keeps history.
+	Time time.Time `json:"time,omitzero"`
+}
+
+// Reply is a node's answer to an Operation.
+type Reply struct {
+	// Count is the number of local sockets the operation applied to.
+	Count int `json:"count"`
+	// Sockets describes those sockets for OpFetch.
+	Sockets []RemoteSocket `json:"sockets,omitempty"`
+}
+
+// RemoteSocket describes a socket that may be connected to any node.
+type RemoteSocket struct {
+	ID    string   `json:"id"`
+	User  string   `json:"user,omitempty"`
+	Rooms []string `json:"rooms"`
+
+	namespace *Namespace
+}
+
+// Adapter carries operations between the nodes serving a namespace, so that
+// broadcasts and room operations reach sockets connected to other processes.
+// Room membership itself stays on the node each socket is connected to.
+type Adapter interface {
+	// Publish sends op to the other nodes without waiting for replies.
+	Publish(op Operation) error
+	// Request sends op to the other nodes and returns their replies, waiting
+	// until every node has answered or ctx is done.
+	Request(ctx context.Context, op Operation) ([]Reply, error)
+	// Close releases the adapter's resources.
+	Close() error
+}
+
+// RoomObserver is implemented by adapters that keep a view of room membership
+// across nodes. The namespace calls its methods for every local membership
+// change, including ID and user rooms, while holding its room lock, so they
+// must not block or call back into the namespace.
+type RoomObserver interface {
+	RoomJoined(socketID, room string)
+	RoomLeft(socketID, room string)
+}
+
+// AdapterFactory creates the adapter of a namespace. The adapter applies the
+// operations it receives from other nodes with ns.Apply.
+type AdapterFactory func(ns *Namespace) (Adapter, error)
+
+// WithAdapter connects every namespace of the server to other nodes through
+// adapters created by factory. Without an adapter, a server only reaches its own sockets.
+func WithAdapter(factory AdapterFactory) Option {
+	return func(s *Server) {
+		s.adapterFactory = factory
+	}
+}
+
+// Name returns the namespace path, such as "/" or "/chat".
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+// Apply performs op on the sockets of this node and returns the local reply.
+// Adapters call it for operations received from other nodes.
+func (ns *Namespace) Apply(op Operation) Reply {
+	bo := &BroadcastOperator{namespace: ns, rooms: op.Rooms, except: op.Except}
+	targets := bo.targets()
+	reply := Reply{Count: len(targets)}
+
+	switch op.Type {
+	case OpBroadcast:
+		packet := sockets.Packet{
+			Type:      sockets.Event,
+			Data:      op.Data,
+			Namespace: ns.name,
+		}
+		for _, sock := range targets {
+			// A closed socket or full channel is skipped
+			sock.queue(outgoing{packet: packet, recorded: op.Time})
+		}
+
+	case OpJoin:
+		for _, sock := range targets {
+			for _, room := range op.RoomChange {
+				sock.Join(room)
+			}
+		}
+
+	case OpLeave:
+		for _, sock := range targets {
+			for _, room := range op.RoomChange {
+				sock.Leave(room)
+			}
+		}
+
+	case OpDisconnect:
+		for _, sock := range targets {
+			sock.Disconnect()
+		}
+
+	case OpFetch:
+		for _, sock := range targets {
+			reply.Sockets = append(reply.Sockets, ns.describe(sock))
+		}
+	}
+
+	return reply
+}
+
+// describe returns the RemoteSocket for a local socket.
+func (ns *Namespace) describe(s *Socket) RemoteSocket {
+	var rooms []string
+	ns.rooms.Range(func(key, value any) bool {
+		if _, ok := value.(*sync.Map).Load(s.ID); ok {
+			rooms = append(rooms, key.(string))
+		}
+		return true
+	})
+	return RemoteSocket{ID: s.ID, User: s.User(), Rooms: rooms, namespace: ns}
+}
+
+// publish sends op to the other nodes, if the namespace has an adapter.
+func (ns *Namespace) publish(op Operation) {
+	if ns.adapter == nil {
+		return
+	}
+	if err := ns.adapter.Publish(op); err != nil {
+		log.Println("adapter error:", err)
+	}
+}
+
+// request applies op on this node and on every other node, returning all replies.
+func (ns *Namespace) request(ctx context.Context, op Operation) ([]Reply, error) {
+	replies := []Reply{ns.Apply(op)}
+	if ns.adapter == nil {
+		return replies, nil
+	}
+
+	remote, err := ns.adapter.Request(ctx, op)
+	return append(replies, remote...), err
+}
+
+// operation returns an Operation selecting the operator's targets.
+func (bo *BroadcastOperator) operation(opType OperationType) Operation {
+	return Operation{Type: opType, Rooms: bo.rooms, Except: bo.except}
+}
+
+// FetchSockets describes the matching sockets on every node.
+// It returns the sockets found so far along with an error if ctx is done
+// before every node has answered.
+func (bo *BroadcastOperator) FetchSockets(ctx context.Context) ([]RemoteSocket, error) {
+	replies, err := bo.namespace.request(ctx, bo.operation(OpFetch))
+
+	var found []RemoteSocket
+	for _, reply := range replies {
+		for _, rs := range reply.Sockets {
+			rs.namespace = bo.namespace
+			found = append(found, rs)
+		}
+	}
+	return found, err
+}
+
+// SocketsJoin makes the matching sockets on every node join rooms.
+func (bo *BroadcastOperator) SocketsJoin(rooms ...string) {
+	op := bo.operation(OpJoin)
+	op.RoomChange = rooms
+	bo.namespace.Apply(op)
+	bo.namespace.publish(op)
+}
+
+// SocketsLeave makes the matching sockets on every node leave rooms.
+func (bo *BroadcastOperator) SocketsLeave(rooms ...string) {
+	op := bo.operation(OpLeave)
+	op.RoomChange = rooms
+	bo.namespace.Apply(op)
+	bo.namespace.publish(op)
+}
+
+// DisconnectSockets disconnects the matching sockets on every node.
+func (bo *BroadcastOperator) DisconnectSockets() {
+	op := bo.operation(OpDisconnect)
+	bo.namespace.Apply(op)
+	bo.namespace.publish(op)
+}
+
+// Emit sends an event to the socket, on whichever node it is connected to.
+func (rs RemoteSocket) Emit(event string, args ...any) {
+	rs.namespace.To(rs.ID).Emit(event, args...)
+}
+
+// Join makes the socket join rooms, on whichever node it is connected to.
+func (rs RemoteSocket) Join(rooms ...string) {
+	rs.namespace.To(rs.ID).SocketsJoin(rooms...)
+}
+
+// Leave makes the socket leave rooms, on whichever node it is connected to.
+func (rs RemoteSocket) Leave(rooms ...string) {
+	rs.namespace.To(rs.ID).SocketsLeave(rooms...)
+}
+
+// Disconnect disconnects the socket, on whichever node it is connected to.
+func (rs RemoteSocket) Disconnect() {
+	rs.namespace.To(rs.ID).DisconnectSockets()
+}
@@ -1,53 +1,117 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"slices"
+	"strings"
 	"sync"
-
-	"github.com/givensuman/go-sockets"
+	"time"
 )
 
 // BroadcastOperator is used to broadcast events to multiple sockets, optionally filtered by rooms.
+// Targets are resolved when Emit is called.
 type BroadcastOperator struct {
-	namespace *Namespace
-	targets   []string
+	namespace  *Namespace
+	rooms      []string // targets must be in every room; none means the whole namespace
+	except     []string // socket IDs never targeted
+	persistent bool
 }
 
 // To filters the broadcast targets to only sockets in the specified room.
 func (bo *BroadcastOperator) To(room string) *BroadcastOperator {
-	var newTargets []string
-	if roomMap, ok := bo.namespace.rooms.Load(room); ok {
-		for _, id := range bo.targets {
-			if _, inRoom := roomMap.(*sync.Map).Load(id); inRoom {
-				newTargets = append(newTargets, id)
-			}
-		}
-	}
-
 	return &BroadcastOperator{
-		namespace: bo.namespace,
-		targets:   newTargets,
+		namespace:  bo.namespace,
+		rooms:      append(bo.rooms[:len(bo.rooms):len(bo.rooms)], room),
+		except:     bo.except,
+		persistent: bo.persistent,
 	}
 }
 
+// Persistent marks the broadcast for store-and-forward delivery. When it was
+// created with Namespace.ToUser, the event is kept in the user's inbox and
+// sent to the user's connected sockets as an inbox item, so it is delivered
+// once one of them acknowledges it, or when the user next connects.
+// It has no effect unless the namespace has an inbox; see Namespace.SetInbox.
+func (bo *BroadcastOperator) Persistent() *BroadcastOperator {
+	op := *bo
+	op.persistent = true
+	return &op
+}
+
 // Emit broadcasts an event to all targets in the BroadcastOperator.
 func (bo *BroadcastOperator) Emit(event string, args ...any) {
+	op := bo.prepare(event, args)
+	bo.namespace.Apply(op)
+	bo.namespace.publish(op)
+}
+
+// emitCounted is Emit waiting for every node to deliver the event, returning
+// the number of sockets it reached.
+func (bo *BroadcastOperator) emitCounted(ctx context.Context, event string, args []any) (int, error) {
+	op := bo.prepare(event, args)
+	replies, err := bo.namespace.request(ctx, op)
+	delivered := 0
+	for _, reply := range replies {
+		delivered += reply.Count
+	}
+	return delivered, err
+}
+
+// prepare records an event in the room history and returns the operation
+// delivering it. A persistent event for a user is stored in the user's inbox
+// before it is delivered, so that a socket connecting meanwhile finds it there.
+func (bo *BroadcastOperator) prepare(event string, args []any) Operation {
 	eventData := append([]any{event}, args...)
 	data, _ := json.Marshal(eventData)
-	packet := sockets.Packet{
-		Type:      sockets.Event,
-		Data:      json.RawMessage(data),
-		Namespace: bo.namespace.name,
+	op := bo.operation(OpBroadcast)
+	op.Data = data
+
+	ns := bo.namespace
+	if now := time.Now(); ns.record(bo.rooms, event, args, now) {
+		op.Time = now
 	}
 
-	for _, id := range bo.targets {
-		if sock, ok := bo.namespace.sockets.Load(id); ok {
-			select {
-			case sock.(*Socket).writeChan <- packet:
-			default:
-				// channel full, skip
-			}
+	if bo.persistent && len(bo.rooms) == 1 && isUserRoom(bo.rooms[0]) {
+		userID := strings.TrimPrefix(bo.rooms[0], userRoomPrefix)
+		if item, ok := ns.storeInbox(userID, event, args); ok {
+			// Connected sockets acknowledge the item like any other, which
+			// removes it from the inbox
+			op.Data, _ = json.Marshal([]any{inboxDeliverEvent, []InboxItem{item}})
 		}
 	}
+	return op
 }
 
+// targets returns the sockets currently matched by the operator.
+func (bo *BroadcastOperator) targets() []*Socket {
+	match := func(id string) (*Socket, bool) {
+		if slices.Contains(bo.except, id) {
+			return nil, false
+		}
+		for _, room := range bo.rooms[min(1, len(bo.rooms)):] {
+			if !bo.namespace.inRoom(room, id) {
+				return nil, false
+			}
+		}
+		return bo.namespace.Socket(id)
+	}
+
+	var members *sync.Map
+	if len(bo.rooms) == 0 {
+		members = &bo.namespace.sockets
+	} else if roomMap, ok := bo.namespace.rooms.Load(bo.rooms[0]); ok {
+		members = roomMap.(*sync.Map)
+	} else {
+		return nil
+	}
+
+	var targets []*Socket
+	members.Range(func(key, value any) bool {
+		if sock, ok := match(key.(string)); ok {
+			targets = append(targets, sock)
+		}
+		return true
+	})
+	return targets
+}
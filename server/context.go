@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// cancelEvent is sent by a client that no longer waits for the acknowledgment
+// of an event, with the event's ack ID, to cancel the context of its listeners.
+const cancelEvent = "ack:cancel"
+
+// DefaultAckTimeout is how long acknowledgments are waited for by default.
+const DefaultAckTimeout = 10 * time.Second
+
+// WithAckTimeout sets how long the server waits for a client to acknowledge an
+// event, and how long the listeners of an event the client wants acknowledged
+// may take before their context is cancelled.
+func WithAckTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.ackTimeout = d
+	}
+}
+
+// Context returns a context cancelled once the socket is closed.
+//
+// Listeners whose first parameter is a context.Context receive a context
+// derived from it. When the client asked for an acknowledgment, that context
+// is also cancelled once the listener acknowledges, when the ack timeout
+// passes, or when the client cancels the event.
+func (s *Socket) Context() context.Context {
+	return s.ctx
+}
+
+// eventContext returns the context of the listeners of an event with the
+// given ack ID, cancelled after timeout, or of the socket if id is nil.
+func (s *Socket) eventContext(id *uint64, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if id == nil {
+		return s.ctx, func() {}
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, timeout)
+	s.inflight.Store(*id, cancel)
+	context.AfterFunc(ctx, func() {
+		s.inflight.Delete(*id)
+	})
+	return ctx, cancel
+}
+
+// cancelAck cancels the context of the event whose ack ID is the first of args.
+func (s *Socket) cancelAck(args []any) {
+	if len(args) == 0 {
+		return
+	}
+	id, ok := args[0].(float64)
+	if !ok {
+		return
+	}
+	if cancel, ok := s.inflight.Load(uint64(id)); ok {
+		cancel.(context.CancelFunc)()
+	}
+}
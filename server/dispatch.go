@@ -0,0 +1,40 @@
+package server
+
+import "github.com/givensuman/go-sockets/internal/dispatch"
+
+// DispatchMode selects where the listeners of incoming events run.
+type DispatchMode = dispatch.Mode
+
+// Dispatch modes.
+const (
+	// DispatchInline runs listeners on the socket's reader, the default. A slow
+	// listener delays every later packet from the socket.
+	DispatchInline = dispatch.Inline
+	// DispatchSerial runs each socket's listeners in order on a goroutine of its own.
+	DispatchSerial = dispatch.Serial
+	// DispatchPool runs listeners on a bounded pool of workers shared by all
+	// sockets, still in order for each socket.
+	DispatchPool = dispatch.Pool
+)
+
+// Dispatch configures how the listeners of incoming events run. Workers is
+// the pool size in DispatchPool mode, GOMAXPROCS by default. QueueSize bounds
+// the events waiting per socket, 256 by default; a socket stops reading while
+// its queue is full. Acknowledgments are handled as they arrive, whatever the mode.
+type Dispatch = dispatch.Config
+
+// DispatchStats reports the number of events waiting for their listeners and
+// how long the listeners took.
+type DispatchStats = dispatch.Stats
+
+// WithDispatch sets how the listeners of incoming events run.
+func WithDispatch(cfg Dispatch) Option {
+	return func(s *Server) {
+		s.dispatcher = dispatch.New(cfg)
+	}
+}
+
+// DispatchStats returns statistics on the listeners of incoming events.
+func (s *Server) DispatchStats() DispatchStats {
+	return s.dispatcher.Stats()
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"log"
+
+	"github.com/givensuman/go-sockets/internal/emitter"
+)
+
+// ErrorHandler receives the failures of event listeners: a *PanicError, whose
+// stack is also passed, or an error wrapping ErrListenerArguments. Failures of
+// listeners of client events and of acknowledgment callbacks, reported under the
+// event they acknowledge, are wrapped in a *HandlerError.
+type ErrorHandler func(event string, err error, stack []byte)
+
+// ErrListenerArguments is reported when the arguments of an event do not fit
+// the parameters of a listener, which is then not called.
+var ErrListenerArguments = emitter.ErrArguments
+
+// PanicError is reported when a listener panics.
+type PanicError = emitter.PanicError
+
+// HandlerError is the failure of a listener of an event received from a client.
+type HandlerError struct {
+	Err error
+	ack func(args ...any)
+}
+
+func (e *HandlerError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+// Ack acknowledges the event with args, typically an error description, if
+// the client asked for an acknowledgment. It reports whether it did.
+func (e *HandlerError) Ack(args ...any) bool {
+	if e.ack == nil {
+		return false
+	}
+	e.ack(args...)
+	return true
+}
+
+// OnError sets the handler receiving the failures of listeners in namespaces
+// without a handler of their own. Without any handler, failures are logged.
+func (s *Server) OnError(fn ErrorHandler) {
+	s.errorHandler.Store(&fn)
+}
+
+// OnError sets the handler receiving the failures of the namespace's listeners
+// and of the listeners of its sockets.
+func (ns *Namespace) OnError(fn ErrorHandler) {
+	ns.errorHandler.Store(&fn)
+}
+
+// reportError passes a listener failure to the namespace's error handler, or
+// else to the server's, or logs it.
+func (ns *Namespace) reportError(event string, err error, stack []byte) {
+	if fn := ns.errorHandler.Load(); fn != nil {
+		(*fn)(event, err, stack)
+		return
+	}
+	if ns.server != nil {
+		if fn := ns.server.errorHandler.Load(); fn != nil {
+			(*fn)(event, err, stack)
+			return
+		}
+	}
+	log.Printf("listener error on %q: %v\n%s", event, err, stack)
+}
+
+// callAck calls an acknowledgment callback with the peer's arguments,
+// reporting a panic or arguments that do not fit instead of crashing the
+// read loop.
+func (s *Socket) callAck(callback *emitter.Callback, args []any) {
+	if err := callback.Call(s.ctx, args); err != nil {
+		var stack []byte
+		if p, ok := err.(*PanicError); ok {
+			stack = p.Stack
+		}
+		s.Namespace.reportError(callback.Event, &HandlerError{Err: err}, stack)
+	}
+}
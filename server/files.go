@@ -0,0 +1,338 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for FileOptions.
+const (
+	DefaultMaxChunkSize  = 1 << 20
+	DefaultResumeTimeout = 10 * time.Minute
+)
+
+// chunkStreamPrefix starts the names of the byte streams carrying chunks, as
+// "file:<id>:<index>:<sha256>". The chunk's bytes are followed by the server's
+// JSON reply in the other direction.
+const chunkStreamPrefix = "file:"
+
+// Errors ending file transfers.
+var (
+	// ErrQuotaExceeded may be returned by FileOptions.Quota to refuse a file.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrChecksumMismatch is reported when a file does not match its SHA-256.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrTransferExpired is reported when an interrupted transfer is not
+	// resumed within the resume timeout.
+	ErrTransferExpired = errors.New("transfer expired")
+)
+
+// FileInfo describes a file sent by a client with SendFile.
+type FileInfo struct {
+	// ID identifies the transfer, and is kept when it is resumed.
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	ChunkSize int    `json:"chunkSize"`
+	// SHA256 is the hex-encoded checksum of the whole file.
+	SHA256 string `json:"sha256"`
+}
+
+// chunks returns the number of chunks of the file.
+func (info FileInfo) chunks() int64 {
+	return (info.Size + int64(info.ChunkSize) - 1) / int64(info.ChunkSize)
+}
+
+// FileOptions configures the files accepted by a socket.
+type FileOptions struct {
+	// Quota is called before a new file is opened and returns an error, such
+	// as ErrQuotaExceeded, to refuse it.
+	Quota func(s *Socket, info FileInfo) error
+	// Open returns where a new file is written, or an error to refuse it.
+	// It is required.
+	Open func(s *Socket, info FileInfo) (io.WriterAt, error)
+	// Progress is called after each chunk is written, with the bytes received so far.
+	Progress func(s *Socket, info FileInfo, received int64)
+	// Done is called once a file has been received and matched its checksum,
+	// with a nil error, or once its transfer failed.
+	Done func(s *Socket, info FileInfo, err error)
+	// MaxChunkSize bounds the chunks a client may send. It defaults to
+	// DefaultMaxChunkSize.
+	MaxChunkSize int
+	// ResumeTimeout is how long an interrupted transfer may be resumed by the
+	// client that started it, after reconnecting. It defaults to
+	// DefaultResumeTimeout.
+	ResumeTimeout time.Duration
+}
+
+// fileTransfer is a file being received, kept in its namespace so that a
+// client may resume it after reconnecting.
+type fileTransfer struct {
+	key    string // in Namespace.files; see fileKey
+	info   FileInfo
+	opts   FileOptions
+	userID string
+
+	mu     sync.Mutex
+	socket *Socket // the socket of the last chunk
+	dst    io.WriterAt
+	sum    hash.Hash // of the chunks written so far
+	next   int64     // the index of the next chunk
+	done   bool
+	expiry *time.Timer
+}
+
+// fileReply is the acknowledgment of a file event: the index of the next
+// chunk expected, and an error. Retry is set if the chunk may be sent again.
+type fileReply struct {
+	Next  int64  `json:"next"`
+	Error string `json:"error,omitempty"`
+	Retry bool   `json:"retry,omitempty"`
+}
+
+// AcceptFiles lets the client send files with SendFile. Each file is sent
+// in chunks over byte streams, checked against their SHA-256 and acknowledged
+// as they are written to the io.WriterAt returned by opts.Open; the whole file
+// is checked against its own SHA-256 once written. A client reconnecting within
+// opts.ResumeTimeout resumes from the last chunk acknowledged, provided it
+// resumed its session (see client.WithResume), its new socket also accepts
+// files and it is bound to the same user. Transfers are private to the client that
+// started them, whatever ID it gave them. After each chunk is written the
+// socket emits "file:progress" to its own listeners with the file name, the
+// bytes received so far and the file size, as SendFile does on the client.
+//
+// Event stream subscribers cannot send files. Byte streams opened by the
+// client for other purposes still go to the function set with AcceptStream.
+func (s *Socket) AcceptFiles(opts FileOptions) {
+	if opts.MaxChunkSize <= 0 {
+		opts.MaxChunkSize = DefaultMaxChunkSize
+	}
+	if opts.ResumeTimeout <= 0 {
+		opts.ResumeTimeout = DefaultResumeTimeout
+	}
+
+	s.On("file:start", func(raw map[string]any, ack func(any)) {
+		ack(s.startFile(raw, opts))
+	})
+	if s.mux != nil {
+		s.mux.Handle(chunkStreamPrefix, s.receiveChunk)
+	}
+}
+
+// fileKey returns the key of a transfer in Namespace.files, made of the ID
+// the client chose and the client itself: its session, if it can resume one,
+// or else the socket.
+func (s *Socket) fileKey(id string) string {
+	if s.session != nil && s.session.id != "" {
+		return "session:" + s.session.id + ":" + id
+	}
+	return "socket:" + s.ID + ":" + id
+}
+
+// transfer returns the client's transfer with the given ID, or nil.
+func (s *Socket) transfer(id string) *fileTransfer {
+	value, ok := s.Namespace.files.Load(s.fileKey(id))
+	if !ok {
+		return nil
+	}
+	t := value.(*fileTransfer)
+	if t.userID != s.User() {
+		return nil
+	}
+	return t
+}
+
+// startFile begins a transfer, or resumes it if the namespace knows its ID.
+func (s *Socket) startFile(raw map[string]any, opts FileOptions) fileReply {
+	var info FileInfo
+	data, _ := json.Marshal(raw)
+	if err := json.Unmarshal(data, &info); err != nil || info.ID == "" || info.Size < 0 ||
+		info.ChunkSize <= 0 || info.ChunkSize > opts.MaxChunkSize {
+		return fileReply{Error: "invalid file"}
+	}
+
+	key := s.fileKey(info.ID)
+	if value, ok := s.Namespace.files.Load(key); ok {
+		t := value.(*fileTransfer)
+		if t.userID != s.User() || t.info != info {
+			return fileReply{Error: "transfer belongs to another file"}
+		}
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.socket = s
+		return fileReply{Next: t.next}
+	}
+
+	if opts.Quota != nil {
+		if err := opts.Quota(s, info); err != nil {
+			return fileReply{Error: err.Error()}
+		}
+	}
+	dst, err := opts.Open(s, info)
+	if err != nil {
+		return fileReply{Error: err.Error()}
+	}
+
+	t := &fileTransfer{
+		key:    key,
+		info:   info,
+		opts:   opts,
+		userID: s.User(),
+		socket: s,
+		dst:    dst,
+		sum:    sha256.New(),
+	}
+	t.expiry = time.AfterFunc(opts.ResumeTimeout, func() {
+		s.Namespace.files.CompareAndDelete(key, t)
+		t.finish(ErrTransferExpired)
+	})
+	if _, loaded := s.Namespace.files.LoadOrStore(key, t); loaded {
+		t.expiry.Stop()
+		return fileReply{Error: "transfer already started"}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if info.Size == 0 {
+		if err := t.complete(); err != nil {
+			return fileReply{Error: err.Error()}
+		}
+	}
+	return fileReply{Next: t.next}
+}
+
+// receiveChunk reads a chunk from the byte stream carrying it and answers
+// with a fileReply.
+func (s *Socket) receiveChunk(st *ByteStream) {
+	defer st.Close()
+	json.NewEncoder(st).Encode(s.readChunk(st))
+}
+
+// readChunk reads and writes the chunk named by st. Chunks already written
+// are acknowledged again without being read, as their acknowledgment may have
+// been lost.
+func (s *Socket) readChunk(st *ByteStream) fileReply {
+	id, index, sum, ok := parseChunkStream(st.Name())
+	if !ok {
+		return fileReply{Error: "invalid chunk"}
+	}
+	t := s.transfer(id)
+	if t == nil {
+		return fileReply{Error: "unknown transfer"}
+	}
+
+	t.mu.Lock()
+	next, done := t.next, t.done
+	t.mu.Unlock()
+	if done || index != next {
+		return fileReply{Next: next, Retry: !done}
+	}
+
+	chunk := make([]byte, min(int64(t.info.ChunkSize), t.info.Size-index*int64(t.info.ChunkSize)))
+	if _, err := io.ReadFull(st, chunk); err != nil {
+		return fileReply{Next: next, Error: "invalid chunk", Retry: true}
+	}
+	return s.writeChunk(t, index, chunk, sum)
+}
+
+// parseChunkStream parses the name of a chunk's byte stream.
+func parseChunkStream(name string) (id string, index int64, sum string, ok bool) {
+	rest := strings.TrimPrefix(name, chunkStreamPrefix)
+	rest, sum, ok = cutLast(rest, ":")
+	if !ok {
+		return "", 0, "", false
+	}
+	id, n, ok := cutLast(rest, ":")
+	if !ok {
+		return "", 0, "", false
+	}
+	index, err := strconv.ParseInt(n, 10, 64)
+	return id, index, sum, err == nil
+}
+
+// cutLast slices s around the last instance of sep.
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// writeChunk writes a chunk of a transfer once it has been read.
+func (s *Socket) writeChunk(t *fileTransfer, index int64, chunk []byte, sum string) fileReply {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.socket = s
+	if t.done || index != t.next {
+		return fileReply{Next: t.next, Retry: !t.done}
+	}
+
+	if digest := sha256.Sum256(chunk); hex.EncodeToString(digest[:]) != sum {
+		return fileReply{Next: t.next, Error: "chunk " + ErrChecksumMismatch.Error(), Retry: true}
+	}
+	if _, err := t.dst.WriteAt(chunk, index*int64(t.info.ChunkSize)); err != nil {
+		t.fail(err)
+		return fileReply{Next: t.next, Error: err.Error()}
+	}
+
+	t.sum.Write(chunk)
+	t.next++
+	t.expiry.Reset(t.opts.ResumeTimeout)
+	received := min(t.next*int64(t.info.ChunkSize), t.info.Size)
+	if t.opts.Progress != nil {
+		t.opts.Progress(s, t.info, received)
+	}
+	s.EventEmitter.Emit("file:progress", t.info.Name, received, t.info.Size)
+
+	if t.next == t.info.chunks() {
+		if err := t.complete(); err != nil {
+			return fileReply{Next: t.next, Error: err.Error()}
+		}
+	}
+	return fileReply{Next: t.next}
+}
+
+// complete checks the whole file once its last chunk is written. The
+// transfer is kept until it expires, so that a client whose last
+// acknowledgment was lost learns it is complete. It must be called with t.mu held.
+func (t *fileTransfer) complete() error {
+	if hex.EncodeToString(t.sum.Sum(nil)) != t.info.SHA256 {
+		t.fail(ErrChecksumMismatch)
+		return ErrChecksumMismatch
+	}
+	t.done = true
+	t.callDone(nil)
+	return nil
+}
+
+// fail ends the transfer with err. It must be called with t.mu held.
+func (t *fileTransfer) fail(err error) {
+	t.socket.Namespace.files.CompareAndDelete(t.key, t)
+	t.expiry.Stop()
+	t.done = true
+	t.callDone(err)
+}
+
+// finish reports an expired transfer unless it already ended.
+func (t *fileTransfer) finish(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.done {
+		t.done = true
+		t.callDone(err)
+	}
+}
+
+func (t *fileTransfer) callDone(err error) {
+	if t.opts.Done != nil {
+		t.opts.Done(t.socket, t.info, err)
+	}
+}
@@ -0,0 +1,389 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/givensuman/go-sockets"
+)
+
+// HistoryMessage is an event recorded in a room's history.
+type HistoryMessage struct {
+	// ID increases with every message recorded in the room.
+	ID    uint64          `json:"id"`
+	Room  string          `json:"room"`
+	Event string          `json:"event"`
+	Args  json.RawMessage `json:"args"` // JSON array of the event arguments
+	Time  time.Time       `json:"time"`
+}
+
+// HistoryStore stores the message history of rooms.
+type HistoryStore interface {
+	// Append records m in room's history, assigning its ID, and returns the stored message.
+	Append(room string, m HistoryMessage) (HistoryMessage, error)
+	// Since returns at most limit of the most recent messages in room recorded
+	// after since, oldest first. A limit of zero or less returns all of them.
+	Since(room string, since time.Time, limit int) ([]HistoryMessage, error)
+}
+
+// Room is a handle to a named room in a namespace.
+type Room struct {
+	namespace *Namespace
+	name      string
+}
+
+// Room returns a handle to the named room. The room need not have any members.
+func (ns *Namespace) Room(name string) *Room {
+	return &Room{namespace: ns, name: name}
+}
+
+// Name returns the room name.
+func (r *Room) Name() string {
+	return r.name
+}
+
+// History returns at most limit of the most recent messages broadcast to the
+// room after since, oldest first. It returns nil if the room has no history store.
+func (r *Room) History(since time.Time, limit int) ([]HistoryMessage, error) {
+	store, _ := r.namespace.historyFor(r.name)
+	if store == nil {
+		return nil, nil
+	}
+	return store.Since(r.name, since, limit)
+}
+
+type roomHistory struct {
+	pattern string
+	store   HistoryStore
+	replay  int
+}
+
+// SetHistory records events broadcast with BroadcastOperator.Emit to rooms
+// matching pattern in store. Events broadcast to several rooms at once are not
+// recorded, as they reach only the sockets in all of them. If replay is
+// positive, a socket that joins such a room is sent up to that many of the most
+// recent messages as ordinary events. Patterns use path.Match syntax and the
+// first matching pattern wins. Calling it again with the same pattern replaces
+// the store, and a nil store removes it.
+// Panics if pattern is malformed.
+func (ns *Namespace) SetHistory(pattern string, store HistoryStore, replay int) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		panic("invalid room pattern: " + pattern)
+	}
+
+	ns.policyMu.Lock()
+	defer ns.policyMu.Unlock()
+
+	kept := ns.history[:0:0]
+	for _, h := range ns.history {
+		if h.pattern != pattern {
+			kept = append(kept, h)
+		}
+	}
+	if store != nil {
+		kept = append(kept, roomHistory{pattern: pattern, store: store, replay: replay})
+	}
+	ns.history = kept
+}
+
+// historyFor returns the history store and replay count for room.
+func (ns *Namespace) historyFor(room string) (HistoryStore, int) {
+	ns.policyMu.RLock()
+	defer ns.policyMu.RUnlock()
+
+	for _, h := range ns.history {
+		if ok, _ := path.Match(h.pattern, room); ok {
+			return h.store, h.replay
+		}
+	}
+	return nil, 0
+}
+
+// record appends a broadcast event sent at now to the history of its room, if
+// the room keeps one, and reports whether it was recorded. Events sent to
+// several rooms at once reach only the members of all of them, so they are
+// not recorded in the history of any.
+func (ns *Namespace) record(rooms []string, event string, args []any, now time.Time) bool {
+	if len(rooms) != 1 {
+		return false
+	}
+	room := rooms[0]
+	store, _ := ns.historyFor(room)
+	if store == nil {
+		return false
+	}
+	data, _ := json.Marshal(args)
+	if _, err := store.Append(room, HistoryMessage{Room: room, Event: event, Args: data, Time: now}); err != nil {
+		log.Println("history error:", err)
+		return false
+	}
+	return true
+}
+
+// replayHistory sends the most recent messages of room to s.
+func (ns *Namespace) replayHistory(s *Socket, room string) {
+	// Event streams replay their rooms together once they have joined them all
+	if s.stream {
+		return
+	}
+
+	store, replay := ns.historyFor(room)
+	if store == nil || replay <= 0 {
+		return
+	}
+
+	messages, err := store.Since(room, time.Time{}, replay)
+	if err != nil {
+		log.Println("history error:", err)
+		return
+	}
+	// The replay may exceed the write buffer, so wait for room rather than
+	// closing the socket as Emit would
+	for _, m := range messages {
+		var args []any
+		json.Unmarshal(m.Args, &args)
+		data, _ := json.Marshal(append([]any{m.Event}, args...))
+		packet := sockets.Packet{Type: sockets.Event, Data: json.RawMessage(data), Namespace: ns.name}
+		if err := s.sendWait(s.ctx, packet); err != nil {
+			return
+		}
+	}
+}
+
+// selectHistory picks at most limit of the latest messages after since from
+// messages, which must be ordered oldest first.
+func selectHistory(messages []HistoryMessage, since time.Time, limit int) []HistoryMessage {
+	start := len(messages)
+	for start > 0 && messages[start-1].Time.After(since) {
+		start--
+	}
+	messages = messages[start:]
+	if limit > 0 && len(messages) > limit {
+		messages = messages[len(messages)-limit:]
+	}
+	return append([]HistoryMessage(nil), messages...)
+}
+
+// MemoryHistory is a HistoryStore keeping the latest messages of each room
+// in a fixed-size in-memory ring buffer.
+type MemoryHistory struct {
+	mu    sync.Mutex
+	size  int
+	rooms map[string]*historyRing
+}
+
+type historyRing struct {
+	buf    []HistoryMessage
+	start  int
+	nextID uint64
+}
+
+// NewMemoryHistory creates a MemoryHistory keeping up to size messages per room.
+func NewMemoryHistory(size int) *MemoryHistory {
+	return &MemoryHistory{size: max(size, 1), rooms: make(map[string]*historyRing)}
+}
+
+// Append implements HistoryStore, overwriting the oldest message once the room is full.
+func (h *MemoryHistory) Append(room string, m HistoryMessage) (HistoryMessage, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r, ok := h.rooms[room]
+	if !ok {
+		r = &historyRing{}
+		h.rooms[room] = r
+	}
+	r.nextID++
+	m.ID = r.nextID
+
+	if len(r.buf) < h.size {
+		r.buf = append(r.buf, m)
+	} else {
+		r.buf[r.start] = m
+		r.start = (r.start + 1) % h.size
+	}
+	return m, nil
+}
+
+// Since implements HistoryStore.
+func (h *MemoryHistory) Since(room string, since time.Time, limit int) ([]HistoryMessage, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r, ok := h.rooms[room]
+	if !ok {
+		return nil, nil
+	}
+	ordered := append(r.buf[r.start:len(r.buf):len(r.buf)], r.buf[:r.start]...)
+	return selectHistory(ordered, since, limit), nil
+}
+
+// FileHistory is a HistoryStore appending each room's messages to a JSON lines
+// file in a directory, so history survives restarts. It keeps the latest
+// messages of each room in memory once read, and rewrites a room's file
+// without its oldest messages once it holds twice as many as it keeps.
+type FileHistory struct {
+	mu    sync.Mutex
+	dir   string
+	size  int
+	rooms map[string]*fileRoom
+}
+
+type fileRoom struct {
+	// messages mirrors the room's file, oldest first.
+	messages []HistoryMessage
+	nextID   uint64
+}
+
+// NewFileHistory creates a FileHistory keeping up to size messages per room,
+// storing files in dir, creating it if needed.
+func NewFileHistory(dir string, size int) (*FileHistory, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileHistory{dir: dir, size: max(size, 1), rooms: make(map[string]*fileRoom)}, nil
+}
+
+func (h *FileHistory) file(room string) string {
+	return filepath.Join(h.dir, url.PathEscape(room)+".jsonl")
+}
+
+// Append implements HistoryStore, dropping the oldest message once the room is full.
+func (h *FileHistory) Append(room string, m HistoryMessage) (HistoryMessage, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r, err := h.load(room)
+	if err != nil {
+		return m, err
+	}
+	r.nextID++
+	m.ID = r.nextID
+
+	line, err := json.Marshal(m)
+	if err != nil {
+		return m, err
+	}
+	f, err := os.OpenFile(h.file(room), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return m, err
+	}
+	_, err = f.Write(append(line, '\n'))
+	f.Close()
+	if err != nil {
+		return m, err
+	}
+
+	r.messages = append(r.messages, m)
+	if len(r.messages) > 2*h.size {
+		if err := h.compact(room, r); err != nil {
+			log.Println("history error:", err)
+		}
+	}
+	return m, nil
+}
+
+// Since implements HistoryStore.
+func (h *FileHistory) Since(room string, since time.Time, limit int) ([]HistoryMessage, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r, err := h.load(room)
+	if err != nil {
+		return nil, err
+	}
+	messages := r.messages[max(len(r.messages)-h.size, 0):]
+	return selectHistory(messages, since, limit), nil
+}
+
+// load returns the messages of room, reading its file the first time. The
+// caller must hold mu.
+func (h *FileHistory) load(room string) (*fileRoom, error) {
+	if r, ok := h.rooms[room]; ok {
+		return r, nil
+	}
+
+	messages, err := h.read(room)
+	if err != nil {
+		return nil, err
+	}
+	r := &fileRoom{}
+	if len(messages) > 0 {
+		r.nextID = messages[len(messages)-1].ID
+	}
+	r.messages = messages
+	if len(messages) > 2*h.size {
+		if err := h.compact(room, r); err != nil {
+			return nil, err
+		}
+	}
+	h.rooms[room] = r
+	return r, nil
+}
+
+// compact rewrites the file of room with only the messages it keeps. The
+// caller must hold mu.
+func (h *FileHistory) compact(room string, r *fileRoom) error {
+	kept := append([]HistoryMessage(nil), r.messages[len(r.messages)-h.size:]...)
+
+	tmp, err := os.CreateTemp(h.dir, ".compact-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, m := range kept {
+		line, err := json.Marshal(m)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		w.Write(append(line, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// Rename replaces the file in one step, so a crash leaves either version
+	if err := os.Rename(tmp.Name(), h.file(room)); err != nil {
+		return err
+	}
+	r.messages = kept
+	return nil
+}
+
+// read loads every message of room. The caller must hold mu.
+func (h *FileHistory) read(room string) ([]HistoryMessage, error) {
+	f, err := os.Open(h.file(room))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var messages []HistoryMessage
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var m HistoryMessage
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			// Skip a line torn by a crash mid-write
+			continue
+		}
+		messages = append(messages, m)
+	}
+	return messages, scanner.Err()
+}
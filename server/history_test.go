@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/givensuman/go-sockets"
+)
+
+func testHistoryStore(t *testing.T, store HistoryStore) {
+	start := time.Now()
+	for i, event := range []string{"a", "b", "c"} {
+		m, err := store.Append("room", HistoryMessage{Room: "room", Event: event, Args: json.RawMessage(`[]`), Time: start.Add(time.Duration(i) * time.Second)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.ID != uint64(i+1) {
+			t.Errorf("expected ID %d, got %d", i+1, m.ID)
+		}
+	}
+
+	messages, err := store.Since("room", time.Time{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || messages[0].Event != "b" || messages[1].Event != "c" {
+		t.Errorf("expected the 2 latest messages, got %v", messages)
+	}
+
+	messages, _ = store.Since("room", start, 0)
+	if len(messages) != 2 || messages[0].Event != "b" {
+		t.Errorf("expected messages after start, got %v", messages)
+	}
+
+	if messages, _ := store.Since("other", time.Time{}, 0); len(messages) != 0 {
+		t.Errorf("expected empty history, got %v", messages)
+	}
+}
+
+func TestMemoryHistory(t *testing.T) {
+	testHistoryStore(t, NewMemoryHistory(10))
+
+	ring := NewMemoryHistory(2)
+	for _, event := range []string{"a", "b", "c"} {
+		ring.Append("room", HistoryMessage{Event: event, Time: time.Now()})
+	}
+	messages, _ := ring.Since("room", time.Time{}, 0)
+	if len(messages) != 2 || messages[0].Event != "b" || messages[1].Event != "c" {
+		t.Errorf("expected oldest message to be dropped, got %v", messages)
+	}
+}
+
+func TestFileHistory(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileHistory(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testHistoryStore(t, store)
+
+	// IDs continue after a restart
+	reopened, err := NewFileHistory(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := reopened.Append("room", HistoryMessage{Event: "d", Time: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ID != 4 {
+		t.Errorf("expected ID 4, got %d", m.ID)
+	}
+
+	// The file keeps only the latest messages once it grows past twice the size
+	capped, err := NewFileHistory(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range []string{"e", "f"} {
+		capped.Append("room", HistoryMessage{Event: event, Time: time.Now()})
+	}
+	messages, _ := capped.Since("room", time.Time{}, 0)
+	if len(messages) != 2 || messages[0].Event != "e" || messages[1].Event != "f" || messages[1].ID != 6 {
+		t.Errorf("expected the 2 latest messages, got %v", messages)
+	}
+	data, err := os.ReadFile(capped.file("room"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines > 4 {
+		t.Errorf("expected the file to be compacted, got %d lines", lines)
+	}
+}
+
+func TestHistoryReplay(t *testing.T) {
+	server := NewServer()
+	ns := server.Of("/")
+	ns.SetHistory("chat:*", NewMemoryHistory(10), 2)
+	url := startTestServer(t, server)
+
+	ns.To("chat:1").Emit("message", "one")
+	ns.To("chat:1").Emit("message", "two")
+	ns.To("chat:1").Emit("message", "three")
+	ns.To("lobby").Emit("message", "ignored")
+	ns.To("chat:1").To("chat:2").Emit("message", "members of both only")
+
+	messages, err := ns.Room("chat:1").History(time.Time{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 3 || string(messages[2].Args) != `["three"]` {
+		t.Errorf("expected 3 recorded messages, got %v", messages)
+	}
+	if messages, _ := ns.Room("lobby").History(time.Time{}, 0); messages != nil {
+		t.Errorf("expected no history for lobby, got %v", messages)
+	}
+
+	conn := dialTest(t, url)
+	sendTestPacket(t, conn, sockets.Packet{Type: sockets.Event, Data: json.RawMessage(`["join","chat:1"]`)})
+	for _, want := range []string{"two", "three"} {
+		if event := readTestEvent(t, conn); len(event) != 2 || event[1] != want {
+			t.Errorf("expected replayed %q, got %v", want, event)
+		}
+	}
+
+	// A replay larger than the write buffer waits for it rather than
+	// disconnecting the socket
+	ns.SetHistory("log:*", NewMemoryHistory(50), 40)
+	for i := range 40 {
+		ns.To("log:1").Emit("line", float64(i))
+	}
+	sendTestPacket(t, conn, sockets.Packet{Type: sockets.Event, Data: json.RawMessage(`["join","log:1"]`)})
+	for i := range 40 {
+		if event := readTestEvent(t, conn); len(event) != 2 || event[1] != float64(i) {
+			t.Fatalf("expected replayed line %d, got %v", i, event)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// inboxDeliverEvent carries inbox items to the user's sockets as
+// ["inbox:deliver", items], and inboxAckEvent answers it with the IDs of the
+// items delivered.
+const (
+	inboxDeliverEvent = "inbox:deliver"
+	inboxAckEvent     = "inbox:ack"
+)
+
+// InboxItem is an event kept for a user who was offline when it was sent.
+type InboxItem struct {
+	ID    string          `json:"id"`
+	Event string          `json:"event"`
+	Args  json.RawMessage `json:"args"` // JSON array of the event arguments
+	Time  time.Time       `json:"time"`
+	// Expires is when the item is dropped undelivered, or zero for never.
+	Expires time.Time `json:"expires,omitzero"`
+}
+
+// InboxStore stores the inboxes of offline users.
+type InboxStore interface {
+	// Push appends item to the user's inbox.
+	Push(userID string, item InboxItem) error
+	// List returns the user's inbox, oldest first.
+	List(userID string) ([]InboxItem, error)
+	// Remove deletes the items with the given IDs from the user's inbox.
+	Remove(userID string, ids ...string) error
+}
+
+// SetInbox enables store-and-forward delivery for the namespace.
+//
+// An event emitted with ToUser(userID).Persistent() is pushed to the user's
+// inbox in store, then sent to the user's connected sockets as an
+// "inbox:deliver" event carrying a list of InboxItem. When the user next
+// connects, the whole inbox is sent in order to the new socket the same way.
+// Each item is removed once a client answers with "inbox:ack" and the item
+// IDs, so an item may be delivered more than once. The Go client does this
+// automatically, emitting each item once as an ordinary event.
+//
+// Acknowledgments remove items from the store of the node the client is
+// connected to, so nodes sharing an adapter should share the store too.
+//
+// Items older than ttl are dropped, as are the oldest items once an inbox holds
+// more than maxSize; zero disables either limit. A nil store disables the inbox.
+func (ns *Namespace) SetInbox(store InboxStore, ttl time.Duration, maxSize int) {
+	ns.policyMu.Lock()
+	defer ns.policyMu.Unlock()
+	ns.inbox = store
+	ns.inboxTTL = ttl
+	ns.inboxMax = maxSize
+}
+
+func (ns *Namespace) inboxConfig() (InboxStore, time.Duration, int) {
+	ns.policyMu.RLock()
+	defer ns.policyMu.RUnlock()
+	return ns.inbox, ns.inboxTTL, ns.inboxMax
+}
+
+// storeInbox pushes an event to the user's inbox, trimming it to the maximum
+// size, and returns the stored item. It reports false if the namespace has no
+// inbox or the item could not be stored.
+func (ns *Namespace) storeInbox(userID, event string, args []any) (InboxItem, bool) {
+	store, ttl, maxSize := ns.inboxConfig()
+	if store == nil {
+		return InboxItem{}, false
+	}
+
+	data, _ := json.Marshal(args)
+	item := InboxItem{
+		ID:    uuid.New().String(),
+		Event: event,
+		Args:  data,
+		Time:  time.Now(),
+	}
+	if ttl > 0 {
+		item.Expires = item.Time.Add(ttl)
+	}
+	if err := store.Push(userID, item); err != nil {
+		log.Println("inbox error:", err)
+		return InboxItem{}, false
+	}
+
+	if maxSize > 0 {
+		items, err := store.List(userID)
+		if err != nil {
+			log.Println("inbox error:", err)
+			return item, true
+		}
+		if len(items) > maxSize {
+			var ids []string
+			for _, old := range items[:len(items)-maxSize] {
+				ids = append(ids, old.ID)
+			}
+			store.Remove(userID, ids...)
+		}
+	}
+	return item, true
+}
+
+// deliverInbox sends the user's unexpired inbox items to s.
+func (ns *Namespace) deliverInbox(s *Socket, userID string) {
+	store, _, _ := ns.inboxConfig()
+	if store == nil {
+		return
+	}
+
+	items, err := store.List(userID)
+	if err != nil {
+		log.Println("inbox error:", err)
+		return
+	}
+
+	now := time.Now()
+	var pending []InboxItem
+	var expired []string
+	for _, item := range items {
+		if !item.Expires.IsZero() && now.After(item.Expires) {
+			expired = append(expired, item.ID)
+		} else {
+			pending = append(pending, item)
+		}
+	}
+	if len(expired) > 0 {
+		store.Remove(userID, expired...)
+	}
+	if len(pending) > 0 {
+		s.Emit(inboxDeliverEvent, pending)
+	}
+}
+
+// ackInbox removes delivered items from the inbox of the user bound to s.
+func (ns *Namespace) ackInbox(s *Socket, ids []string) {
+	store, _, _ := ns.inboxConfig()
+	userID := s.User()
+	if store == nil || userID == "" {
+		return
+	}
+	if err := store.Remove(userID, ids...); err != nil {
+		log.Println("inbox error:", err)
+	}
+}
+
+// MemoryInbox is an InboxStore holding inboxes in memory.
+type MemoryInbox struct {
+	mu    sync.Mutex
+	items map[string][]InboxItem
+}
+
+// NewMemoryInbox creates an empty MemoryInbox.
+func NewMemoryInbox() *MemoryInbox {
+	return &MemoryInbox{items: make(map[string][]InboxItem)}
+}
+
+// Push implements InboxStore.
+func (b *MemoryInbox) Push(userID string, item InboxItem) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.items[userID] = append(b.items[userID], item)
+	return nil
+}
+
+// List implements InboxStore.
+func (b *MemoryInbox) List(userID string) ([]InboxItem, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.items[userID]), nil
+}
+
+// Remove implements InboxStore.
+func (b *MemoryInbox) Remove(userID string, ids ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	items := slices.DeleteFunc(b.items[userID], func(item InboxItem) bool {
+		return slices.Contains(ids, item.ID)
+	})
+	if len(items) == 0 {
+		delete(b.items, userID)
+	} else {
+		b.items[userID] = items
+	}
+	return nil
+}
+
+// FileInbox is an InboxStore keeping each user's inbox in a JSON lines file in
+// a directory, so undelivered items survive restarts. Pushes and removals are
+// appended to the file, which is rewritten without the removed items once
+// they make up most of it.
+type FileInbox struct {
+	mu  sync.Mutex
+	dir string
+	// appended counts the records appended to each file since it was last read.
+	appended map[string]int
+}
+
+// inboxCompactEvery is how many records are appended to a FileInbox file
+// between checks for whether it should be compacted.
+const inboxCompactEvery = 64
+
+// inboxRecord is a line of a FileInbox file, pushing an item or removing items.
+type inboxRecord struct {
+	Item   *InboxItem `json:"item,omitempty"`
+	Remove []string   `json:"remove,omitempty"`
+}
+
+// NewFileInbox creates a FileInbox storing files in dir, creating it if needed.
+func NewFileInbox(dir string) (*FileInbox, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileInbox{dir: dir, appended: make(map[string]int)}, nil
+}
+
+func (b *FileInbox) file(userID string) string {
+	return filepath.Join(b.dir, url.PathEscape(userID)+".jsonl")
+}
+
+// Push implements InboxStore.
+func (b *FileInbox) Push(userID string, item InboxItem) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.append(userID, inboxRecord{Item: &item})
+}
+
+// List implements InboxStore.
+func (b *FileInbox) List(userID string) ([]InboxItem, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.load(userID)
+}
+
+// Remove implements InboxStore.
+func (b *FileInbox) Remove(userID string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, err := os.Stat(b.file(userID)); os.IsNotExist(err) {
+		return nil
+	}
+	if err := b.append(userID, inboxRecord{Remove: ids}); err != nil {
+		return err
+	}
+	if b.appended[userID] >= inboxCompactEvery {
+		_, err := b.load(userID)
+		return err
+	}
+	return nil
+}
+
+// load reads the user's inbox, compacting the file if most of its records
+// are spent. The caller must hold mu.
+func (b *FileInbox) load(userID string) ([]InboxItem, error) {
+	items, records, err := b.read(userID)
+	if err != nil {
+		return nil, err
+	}
+	delete(b.appended, userID)
+	if records > 2*len(items) {
+		if err := b.compact(userID, items); err != nil {
+			log.Println("inbox error:", err)
+		}
+	}
+	return items, nil
+}
+
+// append writes a record to the end of the user's file. The caller must hold mu.
+func (b *FileInbox) append(userID string, record inboxRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(b.file(userID), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	b.appended[userID]++
+	return nil
+}
+
+// read replays the user's file, returning the items left in the inbox and the
+// number of records read. The caller must hold mu.
+func (b *FileInbox) read(userID string) ([]InboxItem, int, error) {
+	f, err := os.Open(b.file(userID))
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var items []InboxItem
+	records := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var record inboxRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// Skip a line torn by a crash mid-write
+			continue
+		}
+		records++
+		if record.Item != nil {
+			items = append(items, *record.Item)
+		}
+		if len(record.Remove) > 0 {
+			items = slices.DeleteFunc(items, func(item InboxItem) bool {
+				return slices.Contains(record.Remove, item.ID)
+			})
+		}
+	}
+	return items, records, scanner.Err()
+}
+
+// compact replaces the user's file with one pushing only items, removing it
+// once the inbox is empty. The caller must hold mu.
+func (b *FileInbox) compact(userID string, items []InboxItem) error {
+	if len(items) == 0 {
+		err := os.Remove(b.file(userID))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a torn inbox
+	tmp, err := os.CreateTemp(b.dir, "inbox-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for i := range items {
+		line, _ := json.Marshal(inboxRecord{Item: &items[i]})
+		w.Write(append(line, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), b.file(userID))
+}
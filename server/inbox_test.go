@@ -0,0 +1,172 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/givensuman/go-sockets"
+)
+
+func testInboxStore(t *testing.T, store InboxStore) {
+	for _, id := range []string{"1", "2", "3"} {
+		if err := store.Push("alice", InboxItem{ID: id, Event: "e", Args: json.RawMessage(`[]`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Remove("alice", "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := store.List("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].ID != "1" || items[1].ID != "3" {
+		t.Errorf("expected items 1 and 3, got %v", items)
+	}
+
+	store.Remove("alice", "1", "3")
+	if items, _ := store.List("alice"); len(items) != 0 {
+		t.Errorf("expected empty inbox, got %v", items)
+	}
+	if items, _ := store.List("bob"); len(items) != 0 {
+		t.Errorf("expected empty inbox, got %v", items)
+	}
+}
+
+func TestMemoryInbox(t *testing.T) {
+	testInboxStore(t, NewMemoryInbox())
+}
+
+func TestFileInbox(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileInbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testInboxStore(t, store)
+
+	// Items survive a restart, and spent records are compacted away
+	for i := range 100 {
+		store.Push("carol", InboxItem{ID: strconv.Itoa(i), Event: "e", Args: json.RawMessage(`[]`)})
+		if i < 99 {
+			store.Remove("carol", strconv.Itoa(i))
+		}
+	}
+	reopened, err := NewFileInbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items, _ := reopened.List("carol"); len(items) != 1 || items[0].ID != "99" {
+		t.Errorf("expected item 99, got %v", items)
+	}
+	data, err := os.ReadFile(reopened.file("carol"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 1 {
+		t.Errorf("expected the file to be compacted to 1 line, got %d", lines)
+	}
+	if _, err := os.Stat(reopened.file("alice")); !os.IsNotExist(err) {
+		t.Errorf("expected the empty inbox's file to be removed, got %v", err)
+	}
+}
+
+func TestInboxDelivery(t *testing.T) {
+	server := NewServer()
+	ns := server.Of("/")
+	store := NewMemoryInbox()
+	ns.SetInbox(store, time.Hour, 2)
+	ns.Use(func(s *Socket) error {
+		s.SetUser(s.Request.URL.Query().Get("user"))
+		return nil
+	})
+	url := startTestServer(t, server)
+
+	ns.ToUser("alice").Persistent().Emit("notify", "one")
+	ns.ToUser("alice").Persistent().Emit("notify", "two")
+	ns.ToUser("alice").Persistent().Emit("notify", "three")
+	ns.ToUser("alice").Emit("notify", "lost")
+
+	conn := dialTest(t, url+"?user=alice")
+	event := readTestEvent(t, conn)
+	if event[0] != "inbox:deliver" {
+		t.Fatalf("expected inbox delivery, got %v", event)
+	}
+	items := event[1].([]any)
+	if len(items) != 2 {
+		t.Fatalf("expected inbox trimmed to 2 items, got %d", len(items))
+	}
+	var ids []string
+	for i, want := range []string{`["two"]`, `["three"]`} {
+		item := items[i].(map[string]any)
+		args, _ := json.Marshal(item["args"])
+		if item["event"] != "notify" || string(args) != want {
+			t.Errorf("expected %s, got %v", want, item)
+		}
+		ids = append(ids, item["id"].(string))
+	}
+
+	// Items stay until acknowledged
+	if pending, _ := store.List("alice"); len(pending) != 2 {
+		t.Errorf("expected 2 pending items, got %d", len(pending))
+	}
+	ack, _ := json.Marshal([]any{"inbox:ack", ids[0], ids[1]})
+	sendTestPacket(t, conn, sockets.Packet{Type: sockets.Event, Data: ack})
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if pending, _ := store.List("alice"); len(pending) == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if pending, _ := store.List("alice"); len(pending) != 0 {
+		t.Errorf("expected acknowledged items to be removed, got %v", pending)
+	}
+
+	// Online users are sent the stored item, which stays until acknowledged
+	ns.ToUser("alice").Persistent().Emit("notify", "live")
+	event = readTestEvent(t, conn)
+	if event[0] != "inbox:deliver" || len(event[1].([]any)) != 1 {
+		t.Fatalf("expected the live item, got %v", event)
+	}
+	item := event[1].([]any)[0].(map[string]any)
+	if args, _ := json.Marshal(item["args"]); string(args) != `["live"]` {
+		t.Errorf("expected the live item, got %v", item)
+	}
+	if pending, _ := store.List("alice"); len(pending) != 1 {
+		t.Errorf("expected the live item to be stored, got %v", pending)
+	}
+	ack, _ = json.Marshal([]any{"inbox:ack", item["id"]})
+	sendTestPacket(t, conn, sockets.Packet{Type: sockets.Event, Data: ack})
+	deadline = time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if pending, _ := store.List("alice"); len(pending) == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("expected the acknowledged live item to be removed")
+}
+
+func TestInboxTTL(t *testing.T) {
+	ns := NewServer().Of("/")
+	store := NewMemoryInbox()
+	ns.SetInbox(store, time.Millisecond, 0)
+
+	ns.ToUser("bob").Persistent().Emit("notify", "stale")
+	time.Sleep(5 * time.Millisecond)
+
+	s := &Socket{ID: "s", Namespace: ns, writeChan: make(chan outgoing, 1)}
+	ns.deliverInbox(s, "bob")
+	if len(s.writeChan) != 0 {
+		t.Error("expected expired item not to be delivered")
+	}
+	if items, _ := store.List("bob"); len(items) != 0 {
+		t.Errorf("expected expired item to be removed, got %v", items)
+	}
+}
@@ -2,6 +2,8 @@ package server
 
 import (
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/givensuman/go-sockets/internal/emitter"
 )
@@ -9,23 +11,208 @@ import (
 // Namespace represents a Socket.IO namespace, managing sockets and rooms within it.
 type Namespace struct {
 	emitter.EventEmitter
-	name    string
-	sockets sync.Map // map[string]*Socket
-	rooms   sync.Map // map[string]sync.Map // roomName -> socketID -> true
+	name     string
+	server   *Server
+	sockets  sync.Map // map[string]*Socket
+	rooms    sync.Map // map[string]sync.Map // roomName -> socketID -> true
+	sessions sync.Map // map[string]*session
+	files    sync.Map // map[string]*fileTransfer
+	services sync.Map // map[string]*service
+	roomMu   sync.Mutex
+	policyMu sync.RWMutex // guards policies, history and the inbox settings
+	policies []roomPolicy
+	history  []roomHistory
+	inbox    InboxStore
+	inboxTTL time.Duration
+	inboxMax int
+	mwMu     sync.RWMutex
+	mw       []Middleware
+	adapter  Adapter // nil when the server has no adapter
+
+	errorHandler atomic.Pointer[ErrorHandler]
+
+	presenceMu       sync.Mutex
+	presencePatterns []string
+	presence         map[string]map[string]*PresenceMember // room -> socketID -> member
+}
+
+// Middleware runs for every new socket before the namespace emits "connection".
+// Returning an error refuses the connection; the error message is sent to the
+// client in an ERROR packet before the connection is closed.
+type Middleware func(s *Socket) error
+
+// Use appends connection middleware to the namespace. Middleware runs in the
+// order it was added and may inspect Socket.Request or call Socket.SetUser.
+func (ns *Namespace) Use(mw ...Middleware) {
+	ns.mwMu.Lock()
+	defer ns.mwMu.Unlock()
+	ns.mw = append(ns.mw, mw...)
+}
+
+// runMiddleware runs the connection middleware for s and returns the first error.
+func (ns *Namespace) runMiddleware(s *Socket) error {
+	ns.mwMu.RLock()
+	mw := ns.mw
+	ns.mwMu.RUnlock()
+
+	for _, fn := range mw {
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // To creates a BroadcastOperator for broadcasting to all sockets in the specified room.
+// Every socket is a member of a room named after its ID, so To(id) reaches a single socket.
 func (ns *Namespace) To(room string) *BroadcastOperator {
-	var targets []string
+	return &BroadcastOperator{
+		namespace: ns,
+		rooms:     []string{room},
+	}
+}
+
+// Broadcast creates a BroadcastOperator targeting every socket in the namespace.
+func (ns *Namespace) Broadcast() *BroadcastOperator {
+	return &BroadcastOperator{namespace: ns}
+}
+
+// Socket returns the connected socket with the given ID.
+func (ns *Namespace) Socket(id string) (*Socket, bool) {
+	if sock, ok := ns.sockets.Load(id); ok {
+		return sock.(*Socket), true
+	}
+	return nil, false
+}
+
+// isPrivateRoom reports whether room is the ID room of a connected socket
+// or a user's room.
+func (ns *Namespace) isPrivateRoom(room string) bool {
+	if isUserRoom(room) {
+		return true
+	}
+	_, ok := ns.sockets.Load(room)
+	return ok
+}
+
+// inRoom reports whether the socket with the given ID is a member of room.
+func (ns *Namespace) inRoom(room, id string) bool {
+	if roomMap, ok := ns.rooms.Load(room); ok {
+		_, member := roomMap.(*sync.Map).Load(id)
+		return member
+	}
+	return false
+}
+
+// roomSize returns the number of sockets in room.
+func (ns *Namespace) roomSize(room string) int {
+	n := 0
 	if roomMap, ok := ns.rooms.Load(room); ok {
 		roomMap.(*sync.Map).Range(func(key, value any) bool {
-			targets = append(targets, key.(string))
+			n++
 			return true
 		})
 	}
+	return n
+}
 
-	return &BroadcastOperator{
-		namespace: ns,
-		targets:   targets,
+// addToRoom adds id to room. The caller must hold roomMu.
+func (ns *Namespace) addToRoom(room, id string) {
+	roomMap, _ := ns.rooms.LoadOrStore(room, &sync.Map{})
+	roomMap.(*sync.Map).Store(id, true)
+	if observer, ok := ns.adapter.(RoomObserver); ok {
+		observer.RoomJoined(id, room)
+	}
+}
+
+// removeFromRoom removes id from room, dropping the room once it is empty.
+// The caller must hold roomMu.
+func (ns *Namespace) removeFromRoom(room, id string) bool {
+	roomMap, ok := ns.rooms.Load(room)
+	if !ok {
+		return false
+	}
+	if _, member := roomMap.(*sync.Map).LoadAndDelete(id); !member {
+		return false
+	}
+
+	empty := true
+	roomMap.(*sync.Map).Range(func(key, value any) bool {
+		empty = false
+		return false
+	})
+	if empty {
+		ns.rooms.Delete(room)
+	}
+	if observer, ok := ns.adapter.(RoomObserver); ok {
+		observer.RoomLeft(id, room)
+	}
+	return true
+}
+
+// addSocket registers a new socket and joins it to the room named after its ID
+// and to its user's room.
+func (ns *Namespace) addSocket(s *Socket) {
+	ns.roomMu.Lock()
+	ns.sockets.Store(s.ID, s)
+	ns.addToRoom(s.ID, s.ID)
+	userID := s.userID
+	online := false
+	if userID != "" {
+		ns.addToRoom(userRoom(userID), s.ID)
+		online = ns.roomSize(userRoom(userID)) == 1
+	}
+	ns.roomMu.Unlock()
+	ns.webhook(WebhookConnect, s, "")
+
+	if online {
+		ns.Emit("user_online", userID)
+		ns.deliverInbox(s, userID)
+	}
+}
+
+// isRegistered reports whether s has been added to the namespace and not yet removed.
+// The caller must hold roomMu.
+func (ns *Namespace) isRegistered(s *Socket) bool {
+	sock, ok := ns.sockets.Load(s.ID)
+	return ok && sock == s
+}
+
+// joined runs after s has joined room.
+func (ns *Namespace) joined(s *Socket, room string) {
+	ns.presenceJoin(s, room)
+	ns.replayHistory(s, room)
+	ns.webhook(WebhookJoin, s, room)
+}
+
+// left runs after s has left room, including when it disconnects.
+func (ns *Namespace) left(s *Socket, room string) {
+	ns.presenceLeave(s, room)
+	ns.webhook(WebhookLeave, s, room)
+}
+
+// removeSocket forgets a closed socket and takes it out of every room it joined.
+func (ns *Namespace) removeSocket(s *Socket) {
+	var left []string
+	ns.roomMu.Lock()
+	ns.sockets.Delete(s.ID)
+	ns.rooms.Range(func(key, value any) bool {
+		room := key.(string)
+		if ns.removeFromRoom(room, s.ID) && room != s.ID && !isUserRoom(room) {
+			left = append(left, room)
+		}
+		return true
+	})
+	userID := s.userID
+	offline := userID != "" && ns.roomSize(userRoom(userID)) == 0
+	ns.roomMu.Unlock()
+
+	for _, room := range left {
+		ns.audit(s.ID, RoomLeave, room, false, nil)
+		ns.left(s, room)
+	}
+	ns.webhook(WebhookDisconnect, s, "")
+	if offline {
+		ns.Emit("user_offline", userID)
 	}
 }
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"errors"
+	"path"
+	"time"
+)
+
+// Option configures a Server created by NewServer.
+type Option func(*Server)
+
+// Errors returned to clients whose room changes are refused by the server options.
+var (
+	// ErrRoomNotAllowed is returned when a room does not match the client
+	// allow-list or is reserved for a single socket or a user ("user:*").
+	ErrRoomNotAllowed = errors.New("room not allowed")
+)
+
+// RoomOp identifies a room membership change.
+type RoomOp string
+
+// Room membership operations.
+const (
+	RoomJoin  RoomOp = "join"
+	RoomLeave RoomOp = "leave"
+)
+
+// RoomAuthorizer decides whether a client may join or leave a room through the
+// built-in "join" and "leave" handlers. It returns a non-nil error to refuse.
+type RoomAuthorizer func(s *Socket, op RoomOp, room string) error
+
+// RoomAuditEvent describes a room membership change, or a refused attempt at one.
+type RoomAuditEvent struct {
+	// Namespace is the name of the namespace the room belongs to.
+	Namespace string
+	// SocketID is the ID of the socket joining or leaving.
+	SocketID string
+	// Op is the operation performed.
+	Op RoomOp
+	// Room is the room name.
+	Room string
+	// Client is true if the change was requested by the client through the
+	// built-in handlers, and false if it was made by server code.
+	Client bool
+	// Err is non-nil if the change was refused.
+	Err error
+	// Time is when the change happened.
+	Time time.Time
+}
+
+// WithoutClientRooms stops the server from registering the built-in "join"
+// and "leave" handlers, so clients can no longer change their own rooms.
+// Applications may still register their own handlers for those events.
+func WithoutClientRooms() Option {
+	return func(s *Server) {
+		s.clientRooms = false
+	}
+}
+
+// WithClientRoomPatterns limits the built-in "join" and "leave" handlers to
+// rooms matching at least one of the given path.Match patterns.
+// Panics if a pattern is malformed.
+func WithClientRoomPatterns(patterns ...string) Option {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			panic("invalid room pattern: " + pattern)
+		}
+	}
+
+	return func(s *Server) {
+		s.clientRoomPatterns = append(s.clientRoomPatterns, patterns...)
+	}
+}
+
+// WithRoomAuthorizer routes every client-driven join and leave through fn.
+// It runs after the allow-list and before the namespace join policies.
+func WithRoomAuthorizer(fn RoomAuthorizer) Option {
+	return func(s *Server) {
+		s.roomAuthorizer = fn
+	}
+}
+
+// WithRoomAudit calls fn for every room membership change in every namespace,
+// including changes made by server code, leaves caused by disconnects,
+// and refused client requests.
+func WithRoomAudit(fn func(RoomAuditEvent)) Option {
+	return func(s *Server) {
+		s.roomAudit = fn
+	}
+}
+
+// authorizeClientRoom checks a client-driven room change against the server options.
+// Rooms named after a socket ID or starting with "user:" are never open to clients.
+func (s *Server) authorizeClientRoom(socket *Socket, op RoomOp, room string) error {
+	if socket.Namespace.isPrivateRoom(room) {
+		return ErrRoomNotAllowed
+	}
+
+	if len(s.clientRoomPatterns) > 0 {
+		allowed := false
+		for _, pattern := range s.clientRoomPatterns {
+			if ok, _ := path.Match(pattern, room); ok {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return ErrRoomNotAllowed
+		}
+	}
+
+	if s.roomAuthorizer != nil {
+		return s.roomAuthorizer(socket, op, room)
+	}
+	return nil
+}
+
+// audit reports a room change to the audit hook, if one is set.
+func (ns *Namespace) audit(id string, op RoomOp, room string, client bool, err error) {
+	if ns.server == nil || ns.server.roomAudit == nil {
+		return
+	}
+
+	ns.server.roomAudit(RoomAuditEvent{
+		Namespace: ns.name,
+		SocketID:  id,
+		Op:        op,
+		Room:      room,
+		Client:    client,
+		Err:       err,
+		Time:      time.Now(),
+	})
+}
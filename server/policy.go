@@ -0,0 +1,167 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"path"
+	"sync"
+)
+
+// Errors returned by the built-in join policies.
+var (
+	// ErrRoomFull is returned when a room has reached its member limit.
+	ErrRoomFull = errors.New("room is full")
+	// ErrInvalidKey is returned when a join request carries a wrong password or token.
+	ErrInvalidKey = errors.New("invalid room key")
+	// ErrNotInvited is returned when a socket is not on a room's invite list.
+	ErrNotInvited = errors.New("not invited to room")
+)
+
+// JoinPolicy decides whether a socket may join a room.
+// Check returns a non-nil error to deny the join. The key is the password or
+// token sent along with the join request, or empty if none was given.
+type JoinPolicy interface {
+	Check(s *Socket, room, key string) error
+}
+
+// JoinPolicyFunc adapts an ordinary function to a JoinPolicy.
+type JoinPolicyFunc func(s *Socket, room string) error
+
+// Check calls f(s, room).
+func (f JoinPolicyFunc) Check(s *Socket, room, key string) error {
+	return f(s, room)
+}
+
+type keyPolicy func(s *Socket, room, key string) error
+
+func (f keyPolicy) Check(s *Socket, room, key string) error {
+	return f(s, room, key)
+}
+
+// MaxMembers limits a room to at most n sockets.
+func MaxMembers(n int) JoinPolicy {
+	return JoinPolicyFunc(func(s *Socket, room string) error {
+		if s.Namespace.roomSize(room) >= n {
+			return ErrRoomFull
+		}
+		return nil
+	})
+}
+
+// Password requires the join request to carry the given password.
+func Password(password string) JoinPolicy {
+	return keyPolicy(func(s *Socket, room, key string) error {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(password)) != 1 {
+			return ErrInvalidKey
+		}
+		return nil
+	})
+}
+
+// Token passes the key sent with the join request to verify, which returns
+// a non-nil error to deny the join.
+func Token(verify func(s *Socket, room, token string) error) JoinPolicy {
+	return keyPolicy(func(s *Socket, room, key string) error {
+		if key == "" {
+			return ErrInvalidKey
+		}
+		return verify(s, room, key)
+	})
+}
+
+// InviteList is a JoinPolicy that only admits invited socket or user IDs.
+// It is safe for concurrent use, so invites may be granted and revoked while
+// the server is running.
+type InviteList struct {
+	mu  sync.RWMutex
+	ids map[string]struct{}
+}
+
+// NewInviteList creates an InviteList containing the given IDs.
+func NewInviteList(ids ...string) *InviteList {
+	l := &InviteList{ids: make(map[string]struct{})}
+	l.Invite(ids...)
+	return l
+}
+
+// Invite adds IDs to the list.
+func (l *InviteList) Invite(ids ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, id := range ids {
+		l.ids[id] = struct{}{}
+	}
+}
+
+// Revoke removes IDs from the list. It does not remove sockets that already joined.
+func (l *InviteList) Revoke(ids ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, id := range ids {
+		delete(l.ids, id)
+	}
+}
+
+// Check implements JoinPolicy.
+func (l *InviteList) Check(s *Socket, room, key string) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if _, ok := l.ids[s.ID]; ok {
+		return nil
+	}
+	if userID := s.User(); userID != "" {
+		if _, ok := l.ids[userID]; ok {
+			return nil
+		}
+	}
+	return ErrNotInvited
+}
+
+type roomPolicy struct {
+	pattern  string
+	policies []JoinPolicy
+}
+
+// SetJoinPolicy sets the policies checked when a socket joins a room whose name
+// matches pattern. Patterns use path.Match syntax, so "game:*" covers every
+// room starting with "game:". Calling it again with the same pattern replaces
+// the previous policies, and calling it with no policies removes them.
+// Panics if pattern is malformed.
+func (ns *Namespace) SetJoinPolicy(pattern string, policies ...JoinPolicy) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		panic("invalid room pattern: " + pattern)
+	}
+
+	ns.policyMu.Lock()
+	defer ns.policyMu.Unlock()
+
+	kept := ns.policies[:0:0]
+	for _, rp := range ns.policies {
+		if rp.pattern != pattern {
+			kept = append(kept, rp)
+		}
+	}
+	if len(policies) > 0 {
+		kept = append(kept, roomPolicy{pattern: pattern, policies: policies})
+	}
+	ns.policies = kept
+}
+
+// checkJoin runs every policy whose pattern matches room, in the order the
+// patterns were set, and returns the first error.
+func (ns *Namespace) checkJoin(s *Socket, room, key string) error {
+	ns.policyMu.RLock()
+	defer ns.policyMu.RUnlock()
+
+	for _, rp := range ns.policies {
+		if ok, _ := path.Match(rp.pattern, room); !ok {
+			continue
+		}
+		for _, p := range rp.policies {
+			if err := p.Check(s, room, key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
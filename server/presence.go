@@ -0,0 +1,128 @@
+package server
+
+import (
+	"errors"
+	"maps"
+	"path"
+)
+
+// ErrPresenceNotTracked is returned when presence is updated for a room that
+// is not tracked or that the socket has not joined.
+var ErrPresenceNotTracked = errors.New("presence not tracked for room")
+
+// PresenceMember is a socket present in a room, with its custom state
+// such as typing, idle or cursor position.
+type PresenceMember struct {
+	ID    string         `json:"id"`
+	User  string         `json:"user,omitempty"`
+	State map[string]any `json:"state"`
+}
+
+// TrackPresence enables presence for rooms matching any of the given path.Match patterns.
+//
+// Members of a tracked room receive "presence:join", "presence:leave" and
+// "presence:update" events carrying the room name and the PresenceMember that
+// changed; an update carries only the fields that changed, with nil marking a
+// removed field. A socket joining the room first receives "presence:snapshot"
+// with the room name and every member. Clients update their own state by
+// emitting "presence:set" with the room name and the fields to change.
+// Panics if a pattern is malformed.
+func (ns *Namespace) TrackPresence(patterns ...string) {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			panic("invalid room pattern: " + pattern)
+		}
+	}
+
+	ns.presenceMu.Lock()
+	defer ns.presenceMu.Unlock()
+	ns.presencePatterns = append(ns.presencePatterns, patterns...)
+}
+
+// Presence returns the members of a tracked room and their state.
+func (ns *Namespace) Presence(room string) []PresenceMember {
+	ns.presenceMu.Lock()
+	defer ns.presenceMu.Unlock()
+
+	members := make([]PresenceMember, 0, len(ns.presence[room]))
+	for _, m := range ns.presence[room] {
+		members = append(members, PresenceMember{ID: m.ID, User: m.User, State: maps.Clone(m.State)})
+	}
+	return members
+}
+
+// SetPresence merges fields into the socket's presence state in room and sends
+// the change to the other members. A nil value removes the field.
+func (s *Socket) SetPresence(room string, fields map[string]any) error {
+	ns := s.Namespace
+	ns.presenceMu.Lock()
+	member, ok := ns.presence[room][s.ID]
+	if !ok {
+		ns.presenceMu.Unlock()
+		return ErrPresenceNotTracked
+	}
+	for k, v := range fields {
+		if v == nil {
+			delete(member.State, k)
+		} else {
+			member.State[k] = v
+		}
+	}
+	diff := PresenceMember{ID: member.ID, User: member.User, State: maps.Clone(fields)}
+	ns.presenceMu.Unlock()
+
+	s.To(room).Emit("presence:update", room, diff)
+	return nil
+}
+
+// tracksPresence reports whether room is tracked. The caller must hold presenceMu.
+func (ns *Namespace) tracksPresence(room string) bool {
+	for _, pattern := range ns.presencePatterns {
+		if ok, _ := path.Match(pattern, room); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// presenceJoin adds s to the presence of room, sends it a snapshot and
+// announces it to the other members.
+func (ns *Namespace) presenceJoin(s *Socket, room string) {
+	userID := s.User()
+
+	ns.presenceMu.Lock()
+	// The socket may have left again before this ran
+	if !ns.tracksPresence(room) || !ns.inRoom(room, s.ID) {
+		ns.presenceMu.Unlock()
+		return
+	}
+	if ns.presence == nil {
+		ns.presence = make(map[string]map[string]*PresenceMember)
+	}
+	if ns.presence[room] == nil {
+		ns.presence[room] = make(map[string]*PresenceMember)
+	}
+	member := &PresenceMember{ID: s.ID, User: userID, State: map[string]any{}}
+	ns.presence[room][s.ID] = member
+	ns.presenceMu.Unlock()
+
+	s.Emit("presence:snapshot", room, ns.Presence(room))
+	s.To(room).Emit("presence:join", room, PresenceMember{ID: member.ID, User: member.User, State: map[string]any{}})
+}
+
+// presenceLeave removes s from the presence of room and announces it to the remaining members.
+func (ns *Namespace) presenceLeave(s *Socket, room string) {
+	ns.presenceMu.Lock()
+	member, ok := ns.presence[room][s.ID]
+	if ok {
+		delete(ns.presence[room], s.ID)
+		if len(ns.presence[room]) == 0 {
+			delete(ns.presence, room)
+		}
+	}
+	ns.presenceMu.Unlock()
+
+	if ok {
+		ns.To(room).Emit("presence:leave", room, PresenceMember{ID: member.ID, User: member.User})
+	}
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits of the publish API.
+const (
+	maxPublishBody  = 1 << 20
+	publishTimeout  = 5 * time.Second
+	signatureMaxAge = 5 * time.Minute
+)
+
+// Headers carrying an HMAC signature for the publish API.
+const (
+	SignatureHeader = "X-Signature" // "sha256=" followed by the hex HMAC
+	TimestampHeader = "X-Timestamp" // Unix seconds, signed with the body
+)
+
+// PublishOptions configures the authentication of Server.PublishHandler.
+// A request is accepted if it matches either method that is configured.
+type PublishOptions struct {
+	// Token is accepted as "Authorization: Bearer <Token>".
+	Token string
+	// Secret signs requests with HMAC-SHA256. The signature covers the
+	// X-Timestamp header and the body as "<timestamp>.<body>" and is sent as
+	// "X-Signature: sha256=<hex>". Requests older than five minutes are refused.
+	Secret []byte
+}
+
+// PublishRequest is the JSON body accepted by Server.PublishHandler.
+type PublishRequest struct {
+	// Namespace defaults to "/".
+	Namespace string `json:"namespace"`
+	// Rooms narrows the targets to sockets in every listed room, as with
+	// chained calls to To. Sockets are in their ID room and user rooms
+	// ("user:<id>"), so single sockets and users can be reached too.
+	Rooms []string `json:"rooms"`
+	// Except lists socket IDs that are skipped.
+	Except []string `json:"except"`
+	Event  string   `json:"event"`
+	Args   []any    `json:"args"`
+	// Persistent keeps the event for an offline user; see
+	// BroadcastOperator.Persistent.
+	Persistent bool `json:"persistent"`
+}
+
+// PublishResponse is the JSON body returned by Server.PublishHandler.
+type PublishResponse struct {
+	// Delivered is the number of sockets the event was sent to, on every node.
+	Delivered int    `json:"delivered"`
+	Error     string `json:"error,omitempty"`
+}
+
+// PublishHandler returns an http.Handler letting services without a socket
+// broadcast events, usually mounted at a path such as "/emit":
+//
+//	mux.Handle("/emit", server.PublishHandler(srv.PublishOptions{Token: token}))
+//
+// It accepts a POST with a PublishRequest body and answers with a
+// PublishResponse. If the server has an adapter, the event reaches sockets on
+// every node and the handler waits for their counts. With neither a token nor
+// a secret configured, every request is refused. Requests for a namespace the
+// server has not created are answered with 404.
+func (s *Server) PublishHandler(opts PublishOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writePublishResponse(w, http.StatusMethodNotAllowed, PublishResponse{Error: "method not allowed"})
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPublishBody))
+		if err != nil {
+			writePublishResponse(w, http.StatusRequestEntityTooLarge, PublishResponse{Error: "body too large"})
+			return
+		}
+		if !opts.authorized(r, body) {
+			writePublishResponse(w, http.StatusUnauthorized, PublishResponse{Error: "unauthorized"})
+			return
+		}
+
+		var req PublishRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			writePublishResponse(w, http.StatusBadRequest, PublishResponse{Error: "invalid body: " + err.Error()})
+			return
+		}
+		if req.Event == "" {
+			writePublishResponse(w, http.StatusBadRequest, PublishResponse{Error: "missing event"})
+			return
+		}
+
+		// Namespaces are only created by the server's own code, as each may
+		// set up an adapter
+		ns, ok := s.namespace(req.Namespace)
+		if !ok {
+			writePublishResponse(w, http.StatusNotFound, PublishResponse{Error: "unknown namespace"})
+			return
+		}
+
+		bo := &BroadcastOperator{
+			namespace:  ns,
+			rooms:      req.Rooms,
+			except:     req.Except,
+			persistent: req.Persistent,
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), publishTimeout)
+		defer cancel()
+		delivered, err := bo.emitCounted(ctx, req.Event, req.Args)
+		if err != nil {
+			// Some nodes did not answer; the count covers those that did
+			writePublishResponse(w, http.StatusGatewayTimeout, PublishResponse{Delivered: delivered, Error: err.Error()})
+			return
+		}
+		writePublishResponse(w, http.StatusOK, PublishResponse{Delivered: delivered})
+	})
+}
+
+// authorized reports whether r carries a valid bearer token or signature.
+func (opts PublishOptions) authorized(r *http.Request, body []byte) bool {
+	if opts.Token != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(token), []byte(opts.Token)) == 1 {
+			return true
+		}
+	}
+
+	if len(opts.Secret) > 0 {
+		timestamp := r.Header.Get(TimestampHeader)
+		sent, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || time.Since(time.Unix(sent, 0)).Abs() > signatureMaxAge {
+			return false
+		}
+		signature, ok := strings.CutPrefix(r.Header.Get(SignatureHeader), "sha256=")
+		if !ok {
+			return false
+		}
+		got, err := hex.DecodeString(signature)
+		if err != nil {
+			return false
+		}
+		return hmac.Equal(got, SignPublish(opts.Secret, timestamp, body))
+	}
+
+	return false
+}
+
+// SignPublish returns the HMAC-SHA256 of a publish request body sent with the
+// given X-Timestamp header. Callers hex-encode it into the X-Signature header.
+func SignPublish(secret []byte, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+func writePublishResponse(w http.ResponseWriter, status int, resp PublishResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPublishHandler(t *testing.T) {
+	server := NewServer()
+	ns := server.Of("/chat")
+	ns.On("connection", func(s *Socket) {
+		s.Join("lobby")
+		s.Emit("id", s.ID)
+	})
+	url := startTestServer(t, server)
+
+	connA := dialTest(t, url+"/chat")
+	idA := readTestEvent(t, connA)[1].(string)
+	connB := dialTest(t, url+"/chat")
+	readTestEvent(t, connB)
+
+	secret := []byte("s3cret")
+	api := httptest.NewServer(server.PublishHandler(PublishOptions{Token: "tok", Secret: secret}))
+	t.Cleanup(api.Close)
+
+	publish := func(body string, header http.Header) (int, PublishResponse) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, api.URL, bytes.NewBufferString(body))
+		req.Header = header
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var out PublishResponse
+		json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	body := `{"namespace":"/chat","rooms":["lobby"],"except":["` + idA + `"],"event":"invoice","args":["paid",42]}`
+	status, resp := publish(body, http.Header{"Authorization": {"Bearer tok"}})
+	if status != http.StatusOK || resp.Delivered != 1 {
+		t.Fatalf("expected 1 delivery, got %d %+v", status, resp)
+	}
+	if event := readTestEvent(t, connB); len(event) != 3 || event[0] != "invoice" || event[1] != "paid" {
+		t.Errorf("expected published event, got %v", event)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := "sha256=" + hex.EncodeToString(SignPublish(secret, timestamp, []byte(body)))
+	status, resp = publish(body, http.Header{SignatureHeader: {signature}, TimestampHeader: {timestamp}})
+	if status != http.StatusOK || resp.Delivered != 1 {
+		t.Errorf("expected signed request to be accepted, got %d %+v", status, resp)
+	}
+
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	staleSignature := "sha256=" + hex.EncodeToString(SignPublish(secret, stale, []byte(body)))
+	for name, header := range map[string]http.Header{
+		"missing":   {},
+		"bad token": {"Authorization": {"Bearer nope"}},
+		"tampered":  {SignatureHeader: {signature}, TimestampHeader: {stale}},
+		"stale":     {SignatureHeader: {staleSignature}, TimestampHeader: {stale}},
+	} {
+		if status, _ := publish(body, header); status != http.StatusUnauthorized {
+			t.Errorf("%s: expected 401, got %d", name, status)
+		}
+	}
+
+	if status, _ := publish(`{"rooms":["lobby"]}`, http.Header{"Authorization": {"Bearer tok"}}); status != http.StatusBadRequest {
+		t.Errorf("expected 400 without an event, got %d", status)
+	}
+	if status, _ := publish(`{"namespace":"/nowhere","event":"x"}`, http.Header{"Authorization": {"Bearer tok"}}); status != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown namespace, got %d", status)
+	}
+	if _, ok := server.namespace("/nowhere"); ok {
+		t.Error("expected the publish handler not to create namespaces")
+	}
+}
+
+func TestPublishHandlerRequiresAuth(t *testing.T) {
+	api := httptest.NewServer(NewServer().PublishHandler(PublishOptions{}))
+	t.Cleanup(api.Close)
+
+	resp, err := http.Post(api.URL, "application/json", bytes.NewBufferString(`{"event":"x"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected unconfigured handler to refuse, got %d", resp.StatusCode)
+	}
+}
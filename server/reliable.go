@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/givensuman/go-sockets/internal/reliable"
+	"github.com/google/uuid"
+)
+
+// reliableEvent is the envelope carrying at-least-once messages as
+// ["reliable", messageID, event, args...]. The receiver acknowledges the
+// envelope once its listeners have run and drops IDs it has already seen.
+const reliableEvent = "reliable"
+
+// sessionEvent gives the client the token resuming its session, as
+// ["reliable:session", token]. The client reconnects with it as the "sid"
+// query parameter.
+const sessionEvent = "reliable:session"
+
+// sessionTTL is how long the session of a disconnected client keeps its
+// unacknowledged messages, waiting for the client to reconnect.
+const sessionTTL = 2 * time.Minute
+
+// session holds reliable delivery state that outlives a single connection.
+// A client connecting with a "sid" query parameter is issued a token of the
+// session's ID and a random secret, and resumes the session by reconnecting
+// with the token as its "sid" once the previous connection is closed.
+type session struct {
+	id     string
+	secret string
+	userID string
+	outbox *reliable.Outbox
+	seen   *reliable.Window
+
+	mu     sync.Mutex
+	owner  *Socket
+	expiry *time.Timer
+}
+
+// EmitReliable sends an event with at-least-once delivery and returns its message ID.
+// The message is resent with exponential backoff until the client acknowledges
+// it, including after the client reconnects with the same session. The Go
+// client drops duplicates, so listeners run once per message. Listeners of
+// reliable events cannot take an acknowledgment callback.
+//
+// Event stream subscribers cannot acknowledge, so they are sent the event once
+// as with Emit, and writing it to the stream counts as delivery.
+func (s *Socket) EmitReliable(event string, args ...any) string {
+	id := uuid.New().String()
+	if s.stream {
+		s.Emit(event, args...)
+		return id
+	}
+	s.session.outbox.Add(id, append([]any{event}, args...))
+	return id
+}
+
+// receiveReliable handles an incoming reliable envelope, emitting the wrapped
+// event unless it was seen before. The envelope is acknowledged and its ID
+// recorded once the listeners have run without failing, so the sender resends
+// it otherwise.
+func (s *Socket) receiveReliable(ctx context.Context, ack func(args ...any), args []any) {
+	if len(args) < 2 {
+		return
+	}
+	id, ok := args[0].(string)
+	if !ok {
+		return
+	}
+	event, ok := args[1].(string)
+	if !ok {
+		return
+	}
+
+	if !s.session.seen.Has(id) {
+		failed := false
+		s.EventEmitter.EmitContext(ctx, event, func(event string, err error, stack []byte) {
+			failed = true
+			s.Namespace.reportError(event, &HandlerError{Err: err}, stack)
+		}, args[2:]...)
+		if failed {
+			return
+		}
+		s.session.seen.Add(id)
+	}
+	if ack != nil {
+		ack()
+	}
+}
+
+// attachSession gives s the session named by the token in its "sid" query
+// parameter if the token is valid, the session belongs to the same user and
+// no other socket holds it, and a new session otherwise. Event streams cannot
+// be told a token, so their sessions end with them.
+func (ns *Namespace) attachSession(s *Socket) {
+	// Clients opt in to sessions by passing "sid", empty for a new session
+	var token string
+	resumable := false
+	if s.Request != nil && !s.stream {
+		query := s.Request.URL.Query()
+		token, resumable = query.Get("sid"), query.Has("sid")
+	}
+
+	var sess *session
+	if id, secret, ok := strings.Cut(token, "."); ok {
+		if existing, ok := ns.sessions.Load(id); ok {
+			sess = existing.(*session)
+			if subtle.ConstantTimeCompare([]byte(sess.secret), []byte(secret)) != 1 || sess.userID != s.User() {
+				sess = nil
+			}
+		}
+	}
+
+	if sess != nil {
+		sess.mu.Lock()
+		if current, _ := ns.sessions.Load(sess.id); current != sess || sess.owner != nil {
+			// The session expired, or is still in use, perhaps by its rightful client
+			sess.mu.Unlock()
+			sess = nil
+		}
+	}
+	if sess == nil {
+		sess = &session{
+			userID: s.User(),
+			outbox: reliable.NewOutbox(),
+			seen:   reliable.NewWindow(reliable.DefaultWindow),
+		}
+		if resumable {
+			sess.id = uuid.New().String()
+			sess.secret = newSecret()
+			ns.sessions.Store(sess.id, sess)
+		}
+		sess.mu.Lock()
+	}
+
+	if sess.expiry != nil {
+		sess.expiry.Stop()
+		sess.expiry = nil
+	}
+	sess.owner = s
+	sess.mu.Unlock()
+
+	s.session = sess
+	if sess.id != "" {
+		s.emit(sessionEvent, sess.id+"."+sess.secret)
+	}
+	outbox := sess.outbox
+	outbox.Attach(func(id string, payload []any) {
+		args := append([]any{id}, payload...)
+		args = append(args, func() { outbox.Ack(id) })
+		s.emit(reliableEvent, args...)
+	})
+}
+
+// newSecret returns a random hex string for a session token.
+func newSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// detachSession releases the session of a closed socket, keeping it for
+// sessionTTL if the client can resume it.
+func (ns *Namespace) detachSession(s *Socket) {
+	sess := s.session
+	if sess == nil {
+		return
+	}
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	// The client may already have resumed the session on a new socket
+	if sess.owner != s {
+		return
+	}
+	sess.owner = nil
+	sess.outbox.Detach()
+
+	if sess.id == "" {
+		sess.outbox.Close()
+		return
+	}
+	sess.expiry = time.AfterFunc(sessionTTL, func() {
+		sess.mu.Lock()
+		defer sess.mu.Unlock()
+		if sess.owner == nil {
+			ns.sessions.CompareAndDelete(sess.id, sess)
+			sess.outbox.Close()
+		}
+	})
+}
@@ -3,29 +3,77 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
+	"log"
 	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/givensuman/go-sockets"
+	"github.com/givensuman/go-sockets/internal/dispatch"
 	"github.com/givensuman/go-sockets/internal/emitter"
+	"github.com/givensuman/go-sockets/internal/mux"
+	"github.com/givensuman/go-sockets/internal/parser"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
+// Subscription is a listener registered with On or Once on a Server,
+// Namespace or Socket. Its Unsubscribe method removes the listener.
+type Subscription = emitter.Subscription
+
 // Server is the main Socket.IO server that handles WebSocket upgrades and manages namespaces.
 type Server struct {
 	emitter.EventEmitter
-	upgrader   websocket.Upgrader
-	namespaces sync.Map // map[string]*Namespace
+	upgrader           websocket.Upgrader
+	namespaces         sync.Map // map[string]*Namespace
+	namespaceMu        sync.Mutex
+	clientRooms        bool
+	clientRoomPatterns []string
+	roomAuthorizer     RoomAuthorizer
+	roomAudit          func(RoomAuditEvent)
+	adapterFactory     AdapterFactory
+	webhooks           *webhookDispatcher
+	dispatcher         *dispatch.Dispatcher
+	errorHandler       atomic.Pointer[ErrorHandler]
+	ackTimeout         time.Duration
+	callTimeout        time.Duration
 }
 
-// NewServer creates a new Socket.IO server with default WebSocket upgrader settings.
-func NewServer() *Server {
-	return &Server{
+// NewServer creates a new Socket.IO server with default WebSocket upgrader settings,
+// modified by the given options.
+func NewServer(opts ...Option) *Server {
+	s := &Server{
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool { return true },
 		},
+		clientRooms: true,
+		ackTimeout:  DefaultAckTimeout,
+		callTimeout: DefaultCallTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.dispatcher == nil {
+		s.dispatcher = dispatch.New(Dispatch{})
+	}
+
+	return s
+}
+
+// namespace returns the namespace for path, if it exists, without creating it.
+func (s *Server) namespace(path string) (*Namespace, bool) {
+	if path == "" {
+		path = "/"
 	}
+	ns, ok := s.namespaces.Load(path)
+	if !ok {
+		return nil, false
+	}
+	return ns.(*Namespace), true
 }
 
 // Of returns the namespace for the given path, creating it if it doesn't exist.
@@ -39,14 +87,56 @@ func (s *Server) Of(path string) *Namespace {
 		return ns.(*Namespace)
 	}
 
+	// Creation is serialized so each namespace gets exactly one adapter
+	s.namespaceMu.Lock()
+	defer s.namespaceMu.Unlock()
+	if ns, ok := s.namespaces.Load(path); ok {
+		return ns.(*Namespace)
+	}
+
 	ns := &Namespace{
-		name: path,
+		name:   path,
+		server: s,
+	}
+	ns.Catch(ns.reportError)
+	if s.adapterFactory != nil {
+		// On error the namespace falls back to reaching local sockets only
+		adapter, err := s.adapterFactory(ns)
+		if err != nil {
+			log.Println("adapter error:", err)
+		} else {
+			ns.adapter = adapter
+		}
 	}
 
 	s.namespaces.Store(path, ns)
 	return ns
 }
 
+// Close disconnects every socket, closes the adapters of all namespaces and
+// flushes pending webhooks.
+func (s *Server) Close() error {
+	var firstErr error
+	s.namespaces.Range(func(key, value any) bool {
+		ns := value.(*Namespace)
+		ns.sockets.Range(func(key, value any) bool {
+			value.(*Socket).Close()
+			return true
+		})
+		if ns.adapter != nil {
+			if err := ns.adapter.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return true
+	})
+	if s.webhooks != nil {
+		s.webhooks.close()
+	}
+	s.dispatcher.Close()
+	return firstErr
+}
+
 // ServeHTTP handles HTTP requests, upgrading them to WebSocket connections for Socket.IO.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	conn, err := s.upgrader.Upgrade(w, r, nil)
@@ -66,21 +156,88 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		EventEmitter: emitter.EventEmitter{},
 		Conn:         conn,
 		ID:           id,
-		writeChan:    make(chan sockets.Packet, 10),
+		writeChan:    make(chan outgoing, 10),
 		Namespace:    ns,
+		Request:      r,
 	}
-	ns.sockets.Store(id, socket)
+	socket.ctx, socket.cancel = context.WithCancel(context.Background())
+	socket.mux = mux.New(socket.ctx, socket.sendFrame, false, 0)
+	if err := ns.runMiddleware(socket); err != nil {
+		errData, _ := json.Marshal(map[string]string{"message": err.Error()})
+		conn.WriteMessage(websocket.TextMessage, parser.Encode(sockets.Packet{
+			Type:      sockets.Error,
+			Namespace: ns.name,
+			Data:      errData,
+		}))
+		conn.Close()
+		return
+	}
+	socket.handlers = s.dispatcher.Queue()
+	socket.Catch(ns.reportError)
+	ns.addSocket(socket)
+	ns.attachSession(socket)
 
 	// Add default handlers for join/leave
-	socket.On("join", func(room string) {
-		socket.Join(room)
+	if s.clientRooms {
+		socket.On("join", func(room string, extra ...any) {
+			key, ack := joinExtras(extra)
+			err := s.authorizeClientRoom(socket, RoomJoin, room)
+			if err != nil {
+				ns.audit(socket.ID, RoomJoin, room, true, err)
+			} else {
+				err = socket.join(room, key, true)
+			}
+			if err != nil {
+				socket.Emit("join_error", room, err.Error())
+			}
+			if ack != nil {
+				if err != nil {
+					ack(err.Error())
+				} else {
+					ack(nil)
+				}
+			}
+		})
+		socket.On("leave", func(room string) {
+			if err := s.authorizeClientRoom(socket, RoomLeave, room); err != nil {
+				ns.audit(socket.ID, RoomLeave, room, true, err)
+				return
+			}
+			socket.leave(room, true)
+		})
+	}
+
+	socket.On("presence:set", func(room string, fields map[string]any) {
+		socket.SetPresence(room, fields)
 	})
-	socket.On("leave", func(room string) {
-		socket.Leave(room)
+
+	socket.On(inboxAckEvent, func(ids ...any) {
+		var itemIDs []string
+		for _, id := range ids {
+			if itemID, ok := id.(string); ok {
+				itemIDs = append(itemIDs, itemID)
+			}
+		}
+		ns.ackInbox(socket, itemIDs)
 	})
 
-	go socket.readLoop()
+	// Run connection handlers before reading so that listeners they
+	// register see the client's first packets
 	go socket.writeLoop()
-
 	ns.Emit("connection", socket)
+	go socket.readLoop()
+}
+
+// joinExtras picks the optional key and ack out of the arguments that follow
+// the room name in a client "join" event.
+func joinExtras(extra []any) (key string, ack func(args ...any)) {
+	for _, arg := range extra {
+		switch v := arg.(type) {
+		case string:
+			key = v
+		case func(args ...any):
+			ack = v
+		}
+	}
+	return key, ack
 }
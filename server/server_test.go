@@ -1,8 +1,15 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -210,3 +217,892 @@ func TestRoomsAndBroadcasting(t *testing.T) {
 	default:
 	}
 }
+
+func startTestServer(t *testing.T, server *Server) string {
+	t.Helper()
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	return "ws" + strings.TrimPrefix(httpServer.URL, "http")
+}
+
+func dialTest(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func sendTestPacket(t *testing.T, conn *websocket.Conn, packet sockets.Packet) {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, parser.Encode(packet)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestPacket(t *testing.T, conn *websocket.Conn) sockets.Packet {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, err := parser.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packet
+}
+
+// readTestEvent reads the next EVENT packet and returns its name and arguments.
+func readTestEvent(t *testing.T, conn *websocket.Conn) []any {
+	t.Helper()
+	for {
+		packet := readTestPacket(t, conn)
+		if packet.Type == sockets.Event {
+			var event []any
+			json.Unmarshal(packet.Data, &event)
+			return event
+		}
+	}
+}
+
+// joinTest sends a join with an ack and returns the ack payload,
+// skipping any events that arrive first.
+func joinTest(t *testing.T, conn *websocket.Conn, id uint64, args string) []any {
+	t.Helper()
+	sendTestPacket(t, conn, sockets.Packet{
+		Type: sockets.Event,
+		Data: json.RawMessage(`["join",` + args + `]`),
+		ID:   &id,
+	})
+	for {
+		packet := readTestPacket(t, conn)
+		if packet.Type == sockets.Ack && packet.ID != nil && *packet.ID == id {
+			var ackArgs []any
+			json.Unmarshal(packet.Data, &ackArgs)
+			return ackArgs
+		}
+	}
+}
+
+func TestJoinPolicies(t *testing.T) {
+	server := NewServer()
+	ns := server.Of("/")
+	ns.SetJoinPolicy("solo", MaxMembers(1))
+	ns.SetJoinPolicy("vault:*", Password("s3cret"))
+	ns.SetJoinPolicy("closed", JoinPolicyFunc(func(s *Socket, room string) error {
+		return errors.New("closed for maintenance")
+	}))
+	url := startTestServer(t, server)
+
+	connA := dialTest(t, url)
+	connB := dialTest(t, url)
+
+	if ack := joinTest(t, connA, 1, `"solo"`); len(ack) != 1 || ack[0] != nil {
+		t.Fatalf("expected successful join, got %v", ack)
+	}
+
+	// A denied join sends join_error before the ack
+	ackID := uint64(1)
+	sendTestPacket(t, connB, sockets.Packet{
+		Type: sockets.Event,
+		Data: json.RawMessage(`["join","solo"]`),
+		ID:   &ackID,
+	})
+	packet := readTestPacket(t, connB)
+	var event []any
+	json.Unmarshal(packet.Data, &event)
+	if packet.Type != sockets.Event || len(event) != 3 || event[0] != "join_error" || event[2] != ErrRoomFull.Error() {
+		t.Fatalf("expected join_error event, got %s", packet.Data)
+	}
+	packet = readTestPacket(t, connB)
+	if packet.Type != sockets.Ack || string(packet.Data) != `["room is full"]` {
+		t.Fatalf("expected error ack, got %s", packet.Data)
+	}
+
+	if ack := joinTest(t, connB, 2, `"vault:1","wrong"`); len(ack) != 1 || ack[0] != ErrInvalidKey.Error() {
+		t.Errorf("expected invalid key, got %v", ack)
+	}
+	if ack := joinTest(t, connB, 3, `"vault:1","s3cret"`); len(ack) != 1 || ack[0] != nil {
+		t.Errorf("expected successful join, got %v", ack)
+	}
+	if ack := joinTest(t, connB, 4, `"closed"`); len(ack) != 1 || ack[0] != "closed for maintenance" {
+		t.Errorf("expected custom policy error, got %v", ack)
+	}
+
+	// Disconnecting frees the slot
+	connA.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for ns.roomSize("solo") != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ack := joinTest(t, connB, 5, `"solo"`); len(ack) != 1 || ack[0] != nil {
+		t.Errorf("expected join after disconnect, got %v", ack)
+	}
+}
+
+func TestInviteList(t *testing.T) {
+	ns := &Namespace{name: "/"}
+	invites := NewInviteList("a")
+	ns.SetJoinPolicy("private", invites)
+
+	a := &Socket{ID: "a", Namespace: ns}
+	b := &Socket{ID: "b", Namespace: ns}
+	if err := a.Join("private"); err != nil {
+		t.Errorf("expected invited socket to join, got %v", err)
+	}
+	if err := b.Join("private"); err != ErrNotInvited {
+		t.Errorf("expected ErrNotInvited, got %v", err)
+	}
+
+	invites.Invite("b")
+	if err := b.Join("private"); err != nil {
+		t.Errorf("expected socket to join after invite, got %v", err)
+	}
+	if err := b.Join("public"); err != nil {
+		t.Errorf("expected unrestricted room to be open, got %v", err)
+	}
+
+	// Policies may look up the socket's user while the room lock is held
+	ns.SetJoinPolicy("staff", JoinPolicyFunc(func(s *Socket, room string) error {
+		if s.User() != "admin" {
+			return ErrNotInvited
+		}
+		return nil
+	}))
+	b.SetUser("admin")
+	if err := b.Join("staff"); err != nil {
+		t.Errorf("expected the user's policy to admit the socket, got %v", err)
+	}
+}
+
+func TestClientRoomOptions(t *testing.T) {
+	var mu sync.Mutex
+	var audits []RoomAuditEvent
+	server := NewServer(
+		WithClientRoomPatterns("public:*"),
+		WithRoomAuthorizer(func(s *Socket, op RoomOp, room string) error {
+			if room == "public:readonly" && op == RoomJoin {
+				return errors.New("read only")
+			}
+			return nil
+		}),
+		WithRoomAudit(func(e RoomAuditEvent) {
+			mu.Lock()
+			audits = append(audits, e)
+			mu.Unlock()
+		}),
+	)
+	ns := server.Of("/")
+	url := startTestServer(t, server)
+	conn := dialTest(t, url)
+
+	if ack := joinTest(t, conn, 1, `"admins"`); len(ack) != 1 || ack[0] != ErrRoomNotAllowed.Error() {
+		t.Errorf("expected room outside allow-list to be refused, got %v", ack)
+	}
+	if ack := joinTest(t, conn, 2, `"public:readonly"`); len(ack) != 1 || ack[0] != "read only" {
+		t.Errorf("expected authorizer to refuse join, got %v", ack)
+	}
+	if ack := joinTest(t, conn, 3, `"public:lobby"`); len(ack) != 1 || ack[0] != nil {
+		t.Errorf("expected join to succeed, got %v", ack)
+	}
+	conn.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for ns.roomSize("public:lobby") != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []struct {
+		op     RoomOp
+		room   string
+		client bool
+		denied bool
+	}{
+		{RoomJoin, "admins", true, true},
+		{RoomJoin, "public:readonly", true, true},
+		{RoomJoin, "public:lobby", true, false},
+		{RoomLeave, "public:lobby", false, false},
+	}
+	if len(audits) != len(want) {
+		t.Fatalf("expected %d audit events, got %d", len(want), len(audits))
+	}
+	for i, w := range want {
+		e := audits[i]
+		if e.Op != w.op || e.Room != w.room || e.Client != w.client || (e.Err != nil) != w.denied {
+			t.Errorf("audit %d: got %+v", i, e)
+		}
+	}
+}
+
+func TestWithoutClientRooms(t *testing.T) {
+	server := NewServer(WithoutClientRooms())
+	ns := server.Of("/")
+	joined := make(chan string, 1)
+	ns.On("connection", func(s *Socket) {
+		s.On("enter", func(room string) {
+			s.Join(room)
+			joined <- room
+		})
+	})
+	url := startTestServer(t, server)
+	conn := dialTest(t, url)
+
+	sendTestPacket(t, conn, sockets.Packet{Type: sockets.Event, Data: json.RawMessage(`["join","lobby"]`)})
+	sendTestPacket(t, conn, sockets.Packet{Type: sockets.Event, Data: json.RawMessage(`["enter","game"]`)})
+
+	select {
+	case <-joined:
+	case <-time.After(2 * time.Second):
+		t.Fatal("server handler not called")
+	}
+	if ns.roomSize("lobby") != 0 {
+		t.Error("client should not be able to join rooms")
+	}
+	if ns.roomSize("game") != 1 {
+		t.Error("server code should still be able to join rooms")
+	}
+}
+
+func TestPrivateMessaging(t *testing.T) {
+	server := NewServer()
+	ns := server.Of("/")
+	ns.On("connection", func(s *Socket) {
+		s.Emit("id", s.ID)
+		s.On("dm", func(to, msg string) {
+			ns.To(to).Emit("dm", s.ID, msg)
+		})
+		s.On("shout", func(room, msg string) {
+			s.To(room).Emit("shout", msg)
+		})
+	})
+	url := startTestServer(t, server)
+
+	connA := dialTest(t, url)
+	idA := readTestEvent(t, connA)[1].(string)
+	connB := dialTest(t, url)
+	idB := readTestEvent(t, connB)[1].(string)
+
+	if sock, ok := ns.Socket(idB); !ok || sock.ID != idB {
+		t.Fatal("expected to look up socket by ID")
+	}
+	if ns.roomSize(idA) != 1 {
+		t.Error("expected socket to be in its own room")
+	}
+
+	sendTestPacket(t, connA, sockets.Packet{Type: sockets.Event, Data: json.RawMessage(`["dm","` + idB + `","hi"]`)})
+	if event := readTestEvent(t, connB); len(event) != 3 || event[0] != "dm" || event[1] != idA || event[2] != "hi" {
+		t.Errorf("expected direct message, got %v", event)
+	}
+
+	if ack := joinTest(t, connA, 1, `"`+idB+`"`); len(ack) != 1 || ack[0] != ErrRoomNotAllowed.Error() {
+		t.Errorf("expected another socket's room to be refused, got %v", ack)
+	}
+
+	joinTest(t, connA, 2, `"r"`)
+	joinTest(t, connB, 1, `"r"`)
+	sendTestPacket(t, connA, sockets.Packet{Type: sockets.Event, Data: json.RawMessage(`["shout","r","hey"]`)})
+	if event := readTestEvent(t, connB); len(event) != 2 || event[0] != "shout" {
+		t.Errorf("expected room broadcast, got %v", event)
+	}
+	connA.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, data, err := connA.ReadMessage(); err == nil {
+		t.Errorf("sender should not receive its own broadcast, got %s", data)
+	}
+
+	connB.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for ns.roomSize(idB) != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := ns.Socket(idB); ok {
+		t.Error("expected disconnected socket to be removed")
+	}
+}
+
+func TestUserBinding(t *testing.T) {
+	server := NewServer()
+	ns := server.Of("/")
+	ns.Use(func(s *Socket) error {
+		user := s.Request.URL.Query().Get("user")
+		if user == "" {
+			return errors.New("unauthorized")
+		}
+		s.SetUser(user)
+		return nil
+	})
+	presence := make(chan string, 10)
+	ns.On("user_online", func(userID string) { presence <- "online:" + userID })
+	ns.On("user_offline", func(userID string) { presence <- "offline:" + userID })
+	url := startTestServer(t, server)
+
+	expectPresence := func(want string) {
+		t.Helper()
+		select {
+		case got := <-presence:
+			if got != want {
+				t.Errorf("expected %s, got %s", want, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected %s", want)
+		}
+	}
+
+	denied := dialTest(t, url)
+	packet := readTestPacket(t, denied)
+	if packet.Type != sockets.Error || string(packet.Data) != `{"message":"unauthorized"}` {
+		t.Errorf("expected connect error, got %d %s", packet.Type, packet.Data)
+	}
+
+	phone := dialTest(t, url+"?user=alice")
+	expectPresence("online:alice")
+	laptop := dialTest(t, url+"?user=alice")
+	dialTest(t, url+"?user=bob")
+	expectPresence("online:bob")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(ns.UserSockets("alice")) != 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := len(ns.UserSockets("alice")); n != 2 {
+		t.Fatalf("expected 2 sockets for alice, got %d", n)
+	}
+	if user := ns.UserSockets("alice")[0].User(); user != "alice" {
+		t.Errorf("expected socket bound to alice, got %q", user)
+	}
+
+	ns.ToUser("alice").Emit("notify", "paid")
+	for _, conn := range []*websocket.Conn{phone, laptop} {
+		packet := readTestPacket(t, conn)
+		if string(packet.Data) != `["notify","paid"]` {
+			t.Errorf("expected notification, got %s", packet.Data)
+		}
+	}
+
+	phone.Close()
+	laptop.Close()
+	expectPresence("offline:alice")
+	select {
+	case got := <-presence:
+		t.Errorf("unexpected presence event %s", got)
+	default:
+	}
+}
+
+func TestPresence(t *testing.T) {
+	server := NewServer()
+	ns := server.Of("/")
+	ns.TrackPresence("doc:*")
+	url := startTestServer(t, server)
+
+	connA := dialTest(t, url)
+	joinTest(t, connA, 1, `"doc:1"`)
+	connB := dialTest(t, url)
+	sendTestPacket(t, connB, sockets.Packet{Type: sockets.Event, Data: json.RawMessage(`["join","doc:1"]`)})
+
+	snapshot := readTestEvent(t, connB)
+	if snapshot[0] != "presence:snapshot" || snapshot[1] != "doc:1" || len(snapshot[2].([]any)) != 2 {
+		t.Fatalf("expected snapshot with 2 members, got %v", snapshot)
+	}
+	joinEvent := readTestEvent(t, connA)
+	if joinEvent[0] != "presence:join" || joinEvent[1] != "doc:1" {
+		t.Fatalf("expected presence:join, got %v", joinEvent)
+	}
+	idB := joinEvent[2].(map[string]any)["id"].(string)
+
+	sendTestPacket(t, connB, sockets.Packet{Type: sockets.Event, Data: json.RawMessage(`["presence:set","doc:1",{"typing":true}]`)})
+	update := readTestEvent(t, connA)
+	if update[0] != "presence:update" || update[2].(map[string]any)["state"].(map[string]any)["typing"] != true {
+		t.Fatalf("expected typing update, got %v", update)
+	}
+	for _, m := range ns.Presence("doc:1") {
+		if m.ID == idB && m.State["typing"] != true {
+			t.Errorf("expected typing state, got %v", m.State)
+		}
+	}
+
+	connB.Close()
+	leave := readTestEvent(t, connA)
+	if leave[0] != "presence:leave" || leave[2].(map[string]any)["id"] != idB {
+		t.Fatalf("expected presence:leave, got %v", leave)
+	}
+	if n := len(ns.Presence("doc:1")); n != 1 {
+		t.Errorf("expected 1 member after disconnect, got %d", n)
+	}
+
+	// Untracked rooms have no presence
+	joinTest(t, connA, 2, `"lobby"`)
+	if len(ns.Presence("lobby")) != 0 {
+		t.Error("expected lobby not to be tracked")
+	}
+}
+
+func TestReliableSessionResume(t *testing.T) {
+	server := NewServer()
+	ns := server.Of("/")
+	received := make(chan string, 10)
+	ns.On("connection", func(s *Socket) {
+		if s.Request.URL.Query().Get("first") != "" {
+			s.EmitReliable("paid", "inv-1")
+		}
+		s.On("charge", func(amount float64) {
+			received <- "charge"
+		})
+	})
+	url := startTestServer(t, server)
+
+	// The first connection is issued a session token, then receives the
+	// message but drops without acknowledging it
+	conn := dialTest(t, url+"?sid=&first=1")
+	issued := readTestEvent(t, conn)
+	if len(issued) != 2 || issued[0] != "reliable:session" {
+		t.Fatalf("expected a session token, got %v", issued)
+	}
+	token := issued[1].(string)
+	id, _, _ := strings.Cut(token, ".")
+	packet := readTestPacket(t, conn)
+	var envelope []any
+	json.Unmarshal(packet.Data, &envelope)
+	if len(envelope) != 4 || envelope[0] != "reliable" || envelope[2] != "paid" || packet.ID == nil {
+		t.Fatalf("expected reliable envelope with ack ID, got %s", packet.Data)
+	}
+	messageID := envelope[1].(string)
+
+	// The session can be resumed neither while it is in use nor without its secret
+	for _, sid := range []string{token, id + ".guess"} {
+		other := dialTest(t, url+"?sid="+sid)
+		if event := readTestEvent(t, other); event[0] != "reliable:session" || event[1] == token {
+			t.Errorf("expected a new session for sid %q, got %v", sid, event)
+		}
+		other.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if _, data, err := other.ReadMessage(); err == nil {
+			t.Errorf("expected no message for sid %q, got %s", sid, data)
+		}
+		other.Close()
+	}
+	conn.Close()
+	waitDetached(t, ns, id)
+
+	// Resuming the session resends it
+	conn = dialTest(t, url+"?sid="+token)
+	if event := readTestEvent(t, conn); event[1] != token {
+		t.Errorf("expected the session's token again, got %v", event)
+	}
+	packet = readTestPacket(t, conn)
+	json.Unmarshal(packet.Data, &envelope)
+	if envelope[1] != messageID {
+		t.Fatalf("expected message %s to be resent, got %s", messageID, packet.Data)
+	}
+	sendTestPacket(t, conn, sockets.Packet{Type: sockets.Ack, ID: packet.ID, Data: json.RawMessage(`[]`)})
+
+	// Incoming duplicates are acknowledged but handled once
+	for i := uint64(1); i <= 2; i++ {
+		sendTestPacket(t, conn, sockets.Packet{Type: sockets.Event, ID: &i, Data: json.RawMessage(`["reliable","m-1","charge",5]`)})
+		if packet := readTestPacket(t, conn); packet.Type != sockets.Ack || *packet.ID != i {
+			t.Fatalf("expected ack %d, got %d %s", i, packet.Type, packet.Data)
+		}
+	}
+	if len(received) != 1 {
+		t.Errorf("expected handler to run once, ran %d times", len(received))
+	}
+	conn.Close()
+	waitDetached(t, ns, id)
+
+	// Once acknowledged, it is not sent again
+	conn = dialTest(t, url+"?sid="+token)
+	readTestEvent(t, conn)
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, data, err := conn.ReadMessage(); err == nil {
+		t.Errorf("expected no resend after ack, got %s", data)
+	}
+}
+
+func TestReliableListenerFailure(t *testing.T) {
+	server := NewServer()
+	ns := server.Of("/")
+	failures := make(chan string, 10)
+	ns.OnError(func(event string, err error, stack []byte) {
+		failures <- event
+	})
+	var calls atomic.Int32
+	ns.On("connection", func(s *Socket) {
+		s.On("charge", func(amount float64) {
+			if calls.Add(1) == 1 {
+				panic("card declined")
+			}
+		})
+	})
+	url := startTestServer(t, server)
+	conn := dialTest(t, url)
+
+	// A failed message is reported and left unacknowledged, so it is resent
+	one := uint64(1)
+	sendTestPacket(t, conn, sockets.Packet{Type: sockets.Event, ID: &one, Data: json.RawMessage(`["reliable","m-1","charge",5]`)})
+	select {
+	case event := <-failures:
+		if event != "charge" {
+			t.Errorf("expected the charge listener to be reported, got %q", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the failure to be reported")
+	}
+
+	// The resend runs the listener again, and later duplicates are dropped.
+	// The first ack read must be the resend's, as the failed message has none.
+	for i := uint64(2); i <= 3; i++ {
+		sendTestPacket(t, conn, sockets.Packet{Type: sockets.Event, ID: &i, Data: json.RawMessage(`["reliable","m-1","charge",5]`)})
+		if packet := readTestPacket(t, conn); packet.Type != sockets.Ack || *packet.ID != i {
+			t.Fatalf("expected ack %d, got %d %s", i, packet.Type, packet.Data)
+		}
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("expected the listener to run twice, ran %d times", n)
+	}
+}
+
+// waitDetached waits until the session id has no socket.
+func waitDetached(t *testing.T, ns *Namespace, id string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		value, ok := ns.sessions.Load(id)
+		if !ok {
+			t.Fatal("session expired")
+		}
+		sess := value.(*session)
+		sess.mu.Lock()
+		owner := sess.owner
+		sess.mu.Unlock()
+		if owner == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("session still in use")
+}
+
+// BenchmarkReadLoop measures how fast a socket decodes and dispatches the
+// events a client sends.
+func BenchmarkReadLoop(b *testing.B) {
+	server := NewServer()
+	received := make(chan struct{}, 1)
+	var count int
+	server.Of("/").On("connection", func(s *Socket) {
+		s.On("message", func(room string, body map[string]any) {
+			count++
+			if count == b.N {
+				received <- struct{}{}
+			}
+		})
+	})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	data := parser.Encode(sockets.Packet{
+		Type: sockets.Event,
+		Data: json.RawMessage(`["message","lobby",{"text":"hello","n":1}]`),
+	})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			b.Fatal(err)
+		}
+	}
+	<-received
+}
+
+func TestWildcardEvents(t *testing.T) {
+	server := NewServer()
+	incoming := make(chan string, 10)
+	outgoing := make(chan string, 10)
+	server.Of("/").On("connection", func(s *Socket) {
+		s.On("chat:*", func(event string, msg string, ack func(string)) {
+			ack(event + " " + msg)
+		})
+		s.OnAny(func(event string, args ...any) {
+			incoming <- event
+		})
+		s.OnAnyOutgoing(func(event string, args ...any) {
+			outgoing <- fmt.Sprint(event, args)
+		})
+		s.Emit("welcome", "hi")
+	})
+	conn := dialTest(t, startTestServer(t, server))
+
+	readTestEvent(t, conn)
+	id := uint64(1)
+	sendTestPacket(t, conn, sockets.Packet{Type: sockets.Event, Data: json.RawMessage(`["chat:message","hello"]`), ID: &id})
+	if ack := readTestPacket(t, conn); ack.Type != sockets.Ack || string(ack.Data) != `["chat:message hello"]` {
+		t.Errorf("expected pattern listener ack, got %v %s", ack.Type, ack.Data)
+	}
+
+	if got := <-outgoing; got != "welcome[hi]" {
+		t.Errorf("expected outgoing welcome, got %q", got)
+	}
+	if got := <-incoming; got != "chat:message" {
+		t.Errorf("expected incoming chat:message, got %q", got)
+	}
+}
+
+func TestSerialDispatch(t *testing.T) {
+	server := NewServer(WithDispatch(Dispatch{Mode: DispatchSerial}))
+	started := make(chan struct{})
+	release := make(chan struct{})
+	connected := make(chan *Socket, 1)
+	server.Of("/").On("connection", func(s *Socket) {
+		s.On("slow", func() {
+			close(started)
+			<-release
+		})
+		s.On("after", func() {})
+		connected <- s
+	})
+	conn := dialTest(t, startTestServer(t, server))
+	sock := <-connected
+
+	sendTestPacket(t, conn, sockets.Packet{Type: sockets.Event, Data: json.RawMessage(`["slow"]`)})
+	sendTestPacket(t, conn, sockets.Packet{Type: sockets.Event, Data: json.RawMessage(`["after"]`)})
+	<-started
+
+	// The ack is handled while the slow listener still runs
+	answered := make(chan string, 1)
+	sock.Emit("ask", func(answer string) { answered <- answer })
+	ask := readTestPacket(t, conn)
+	sendTestPacket(t, conn, sockets.Packet{Type: sockets.Ack, Data: json.RawMessage(`["yes"]`), ID: ask.ID})
+	select {
+	case answer := <-answered:
+		if answer != "yes" {
+			t.Errorf("expected yes, got %q", answer)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("ack held up by a slow listener")
+	}
+	if depth := server.DispatchStats().QueueDepth; depth != 1 {
+		t.Errorf("expected 1 waiting event, got %d", depth)
+	}
+
+	close(release)
+	deadline := time.Now().Add(1 * time.Second)
+	for server.DispatchStats().Handled < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if stats := server.DispatchStats(); stats.Handled != 2 || stats.QueueDepth != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	server := NewServer()
+	type failure struct {
+		event string
+		err   error
+		stack []byte
+	}
+	nsErrors := make(chan failure, 10)
+	serverErrors := make(chan failure, 10)
+	server.OnError(func(event string, err error, stack []byte) {
+		serverErrors <- failure{event, err, stack}
+	})
+
+	ns := server.Of("/")
+	ns.OnError(func(event string, err error, stack []byte) {
+		var he *HandlerError
+		if errors.As(err, &he) {
+			he.Ack(map[string]any{"error": err.Error()})
+		}
+		nsErrors <- failure{event, err, stack}
+	})
+	ns.On("connection", func(s *Socket) {
+		s.On("explode", func(ack func(any)) {
+			var m map[string]int
+			m["boom"]++
+		})
+		s.On("count", func(n float64) {})
+		s.On("ask", func() {
+			s.Emit("question", func(answer string) {
+				if answer == "" {
+					panic("no answer")
+				}
+			})
+		})
+	})
+	server.Of("/other").On("connection", func(s *Socket) {
+		s.On("explode", func() { panic("other") })
+	})
+	url := startTestServer(t, server)
+	conn := dialTest(t, url)
+
+	id := uint64(7)
+	sendTestPacket(t, conn, sockets.Packet{Type: sockets.Event, Data: json.RawMessage(`["explode"]`), ID: &id})
+	ack := readTestPacket(t, conn)
+	if ack.Type != sockets.Ack || !strings.Contains(string(ack.Data), "nil map") {
+		t.Errorf("expected an error ack, got %v %s", ack.Type, ack.Data)
+	}
+	f := <-nsErrors
+	var panicErr *PanicError
+	if f.event != "explode" || !errors.As(f.err, &panicErr) || len(f.stack) == 0 {
+		t.Errorf("expected a panic with its stack, got %+v", f)
+	}
+
+	sendTestPacket(t, conn, sockets.Packet{Type: sockets.Event, Data: json.RawMessage(`["count","many"]`)})
+	if f := <-nsErrors; f.event != "count" || !errors.Is(f.err, ErrListenerArguments) || f.stack != nil {
+		t.Errorf("expected an argument error, got %+v", f)
+	}
+
+	// Acks from the client must not crash the read loop whatever their arguments
+	for _, reply := range []string{`[1]`, `[]`, `[""]`} {
+		sendTestPacket(t, conn, sockets.Packet{Type: sockets.Event, Data: json.RawMessage(`["ask"]`)})
+		question := readTestPacket(t, conn)
+		sendTestPacket(t, conn, sockets.Packet{Type: sockets.Ack, Data: json.RawMessage(reply), ID: question.ID})
+		f := <-nsErrors
+		if reply == `[""]` {
+			if f.event != "question" || !errors.As(f.err, &panicErr) || panicErr.Value != "no answer" {
+				t.Errorf("expected the ack callback's panic, got %+v", f)
+			}
+		} else if f.event != "question" || !errors.Is(f.err, ErrListenerArguments) {
+			t.Errorf("expected an argument error for ack %s, got %+v", reply, f)
+		}
+	}
+
+	other := dialTest(t, url+"/other")
+	sendTestPacket(t, other, sockets.Packet{Type: sockets.Event, Data: json.RawMessage(`["explode"]`)})
+	select {
+	case f := <-serverErrors:
+		if f.event != "explode" || !errors.As(f.err, &panicErr) || panicErr.Value != "other" {
+			t.Errorf("expected the server handler to get the panic, got %+v", f)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("server error handler not called")
+	}
+}
+
+func TestContextHandlers(t *testing.T) {
+	server := NewServer(WithDispatch(Dispatch{Mode: DispatchSerial}), WithAckTimeout(100*time.Millisecond))
+	cancelled := make(chan error, 3)
+	server.Of("/").On("connection", func(s *Socket) {
+		s.On("work", func(ctx context.Context, ack func(string)) {
+			<-ctx.Done()
+			cancelled <- ctx.Err()
+		})
+		s.On("quick", func(ctx context.Context, ack func(string)) {
+			ack("done")
+			cancelled <- ctx.Err()
+		})
+		s.On("watch", func(ctx context.Context) {
+			<-ctx.Done()
+			cancelled <- ctx.Err()
+		})
+	})
+	url := startTestServer(t, server)
+	conn := dialTest(t, url)
+
+	expect := func(want error, what string) {
+		t.Helper()
+		select {
+		case err := <-cancelled:
+			if err != want {
+				t.Errorf("%s: expected %v, got %v", what, want, err)
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatalf("%s: context not cancelled", what)
+		}
+	}
+
+	id := uint64(3)
+	sendTestPacket(t, conn, sockets.Packet{Type: sockets.Event, Data: json.RawMessage(`["work"]`), ID: &id})
+	sendTestPacket(t, conn, sockets.Packet{Type: sockets.Event, Data: json.RawMessage(`["ack:cancel",3]`)})
+	expect(context.Canceled, "client cancel")
+
+	sendTestPacket(t, conn, sockets.Packet{Type: sockets.Event, Data: json.RawMessage(`["work"]`), ID: &id})
+	expect(context.DeadlineExceeded, "ack timeout")
+
+	sendTestPacket(t, conn, sockets.Packet{Type: sockets.Event, Data: json.RawMessage(`["quick"]`), ID: &id})
+	if ack := readTestPacket(t, conn); ack.Type != sockets.Ack || string(ack.Data) != `["done"]` {
+		t.Errorf("expected an ack, got %v %s", ack.Type, ack.Data)
+	}
+	expect(context.Canceled, "acknowledged")
+
+	sendTestPacket(t, conn, sockets.Packet{Type: sockets.Event, Data: json.RawMessage(`["watch"]`)})
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case err := <-cancelled:
+		t.Fatalf("context without an ack cancelled early: %v", err)
+	default:
+	}
+	conn.Close()
+	expect(context.Canceled, "disconnect")
+}
+
+func TestStream(t *testing.T) {
+	server := NewServer(WithDispatch(Dispatch{Mode: DispatchSerial}))
+	var sent atomic.Int32
+	done := make(chan error, 1)
+	server.Of("/").On("connection", func(s *Socket) {
+		s.On("count", func(ctx context.Context, n float64, w *StreamWriter) {
+			for i := range int(n) {
+				if err := w.Send(i); err != nil {
+					done <- err
+					return
+				}
+				sent.Add(1)
+			}
+			done <- w.Close()
+		})
+		s.On("forever", func(w *StreamWriter) {
+			for {
+				if err := w.Send("tick"); err != nil {
+					done <- err
+					return
+				}
+			}
+		})
+	})
+	conn := dialTest(t, startTestServer(t, server))
+
+	sendTestPacket(t, conn, sockets.Packet{Type: sockets.Event, Data: json.RawMessage(`["stream:open",1,2,"count",5]`)})
+	for i := range 2 {
+		if event := readTestEvent(t, conn); fmt.Sprint(event) != fmt.Sprint([]any{"stream:chunk", 1.0, float64(i)}) {
+			t.Errorf("unexpected chunk %v", event)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := sent.Load(); n != 2 {
+		t.Errorf("expected the writer to wait for credit after 2 chunks, sent %d", n)
+	}
+	sendTestPacket(t, conn, sockets.Packet{Type: sockets.Event, Data: json.RawMessage(`["stream:credit",1,3]`)})
+	for i := 2; i < 5; i++ {
+		if event := readTestEvent(t, conn); fmt.Sprint(event) != fmt.Sprint([]any{"stream:chunk", 1.0, float64(i)}) {
+			t.Errorf("unexpected chunk %v", event)
+		}
+	}
+	if event := readTestEvent(t, conn); fmt.Sprint(event) != "[stream:end 1]" {
+		t.Errorf("expected the stream to end, got %v", event)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("unexpected Close error %v", err)
+	}
+
+	sendTestPacket(t, conn, sockets.Packet{Type: sockets.Event, Data: json.RawMessage(`["stream:open",2,1,"forever"]`)})
+	readTestEvent(t, conn)
+	sendTestPacket(t, conn, sockets.Packet{Type: sockets.Event, Data: json.RawMessage(`["stream:cancel",2]`)})
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected the stream to be cancelled, got %v", err)
+	}
+
+	sendTestPacket(t, conn, sockets.Packet{Type: sockets.Event, Data: json.RawMessage(`["stream:open",3,1,"missing"]`)})
+	if event := readTestEvent(t, conn); event[0] != "stream:error" || event[2] != "no stream handler for missing" {
+		t.Errorf("expected a stream error, got %v", event)
+	}
+}
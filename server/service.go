@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/givensuman/go-sockets/internal/rpc"
+)
+
+// RPCError is the error of a service call sent to the client. A method or
+// middleware may return one to choose its code; other errors are sent as
+// CodeInternal with their message.
+type RPCError = rpc.Error
+
+// RPCCode classifies an RPCError.
+type RPCCode = rpc.Code
+
+// RPC error codes.
+const (
+	CodeInvalidArgument  = rpc.InvalidArgument
+	CodeNotFound         = rpc.NotFound
+	CodePermissionDenied = rpc.PermissionDenied
+	CodeDeadlineExceeded = rpc.DeadlineExceeded
+	CodeCanceled         = rpc.Canceled
+	CodeInternal         = rpc.Internal
+)
+
+// DefaultCallTimeout is how long a service method may run by default.
+const DefaultCallTimeout = time.Minute
+
+// WithCallTimeout sets how long a service method may run before its context
+// is cancelled, whatever deadline the client asks for.
+func WithCallTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.callTimeout = d
+	}
+}
+
+// Call is a call to a service method.
+type Call struct {
+	Socket *Socket
+	// Method is the full name of the method, such as "math.Add".
+	Method string
+	// Request is the decoded request, of the method's request type.
+	Request any
+}
+
+// ServiceHandler handles a call, returning the method's response.
+type ServiceHandler func(ctx context.Context, call *Call) (any, error)
+
+// ServiceMiddleware wraps the calls to the methods of a service, for example
+// to check permissions or record metrics per method.
+type ServiceMiddleware func(next ServiceHandler) ServiceHandler
+
+// service is a registered service, read only once registered.
+type service struct {
+	methods map[string]*serviceMethod
+}
+
+type serviceMethod struct {
+	reqType reflect.Type
+	handler ServiceHandler
+}
+
+var (
+	contextType = reflect.TypeFor[context.Context]()
+	errorType   = reflect.TypeFor[error]()
+)
+
+// RegisterService exposes the exported methods of svc of the form
+//
+//	func(ctx context.Context, req Req) (Resp, error)
+//
+// to clients of the namespace as the events "name.Method", which the client
+// package calls with Socket.Call. Requests and responses are encoded as JSON.
+// Middleware runs around every call, the first given outermost, once the
+// request is decoded. It returns an error if svc is nil or has no methods of
+// that form.
+//
+// The context of a call is cancelled when the client's deadline or the
+// server's call timeout passes, when the client cancels the call, or when
+// the socket closes. A method's event is not passed to the socket's listeners.
+func (ns *Namespace) RegisterService(name string, svc any, mw ...ServiceMiddleware) error {
+	if name == "" || strings.Contains(name, ".") {
+		return fmt.Errorf("invalid service name %q", name)
+	}
+
+	v := reflect.ValueOf(svc)
+	if !v.IsValid() || v.Kind() == reflect.Pointer && v.IsNil() {
+		return fmt.Errorf("service %q is nil", name)
+	}
+	srv := &service{methods: make(map[string]*serviceMethod)}
+	for i := range v.NumMethod() {
+		m := v.Type().Method(i)
+		t := m.Type
+		// m.Type includes the receiver
+		if t.NumIn() != 3 || t.In(1) != contextType || t.NumOut() != 2 || t.Out(1) != errorType {
+			continue
+		}
+
+		fn := v.Method(i)
+		handler := func(ctx context.Context, call *Call) (any, error) {
+			out := fn.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(call.Request)})
+			err, _ := out[1].Interface().(error)
+			return out[0].Interface(), err
+		}
+		srv.methods[m.Name] = &serviceMethod{reqType: t.In(2), handler: chain(handler, mw)}
+	}
+	if len(srv.methods) == 0 {
+		return fmt.Errorf("service %q has no methods of the form func(context.Context, Req) (Resp, error)", name)
+	}
+
+	ns.services.Store(name, srv)
+	return nil
+}
+
+// chain wraps handler in mw, the first outermost.
+func chain(handler ServiceHandler, mw []ServiceMiddleware) ServiceHandler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		handler = mw[i](handler)
+	}
+	return handler
+}
+
+// serviceMethod returns the method named by event, and whether event names a
+// registered service at all.
+func (ns *Namespace) serviceMethod(event string) (*serviceMethod, bool) {
+	name, method, ok := strings.Cut(event, ".")
+	if !ok {
+		return nil, false
+	}
+	srv, ok := ns.services.Load(name)
+	if !ok {
+		return nil, false
+	}
+	return srv.(*service).methods[method], true
+}
+
+// callMethod answers a call to a service method with ack, if the client
+// asked for one, as [response, null] or [null, error].
+func (s *Socket) callMethod(ctx context.Context, method string, m *serviceMethod, args []any, ack func(args ...any)) {
+	if ack == nil {
+		ack = func(args ...any) {}
+	}
+	if m == nil {
+		ack(nil, &RPCError{Code: CodeNotFound, Message: "unknown method " + method})
+		return
+	}
+
+	// The client passes its deadline after the request
+	if len(args) > 1 {
+		if meta, ok := args[1].(map[string]any); ok {
+			if ms, ok := meta["timeout"].(float64); ok {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, time.Duration(ms)*time.Millisecond)
+				defer cancel()
+			}
+		}
+	}
+
+	req := reflect.New(m.reqType)
+	if len(args) > 0 {
+		data, _ := json.Marshal(args[0])
+		if err := json.Unmarshal(data, req.Interface()); err != nil {
+			ack(nil, &RPCError{Code: CodeInvalidArgument, Message: err.Error()})
+			return
+		}
+	}
+
+	resp, err := s.runMethod(ctx, m, &Call{Socket: s, Method: method, Request: req.Elem().Interface()})
+	if err != nil {
+		ack(nil, rpcError(ctx, err))
+		return
+	}
+	ack(resp, nil)
+}
+
+// runMethod runs a call, reporting a panic to the namespace's error handler.
+func (s *Socket) runMethod(ctx context.Context, m *serviceMethod, call *Call) (resp any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			p := &PanicError{Value: r, Stack: debug.Stack()}
+			s.Namespace.reportError(call.Method, p, p.Stack)
+			resp, err = nil, &RPCError{Code: CodeInternal, Message: "method panicked"}
+		}
+	}()
+	return m.handler(ctx, call)
+}
+
+// rpcError converts the error of a call for the client.
+func rpcError(ctx context.Context, err error) *RPCError {
+	var rpcErr *RPCError
+	switch {
+	case errors.As(err, &rpcErr):
+		return rpcErr
+	case errors.Is(err, context.DeadlineExceeded):
+		return &RPCError{Code: CodeDeadlineExceeded, Message: err.Error()}
+	case errors.Is(err, context.Canceled) || ctx.Err() == context.Canceled:
+		return &RPCError{Code: CodeCanceled, Message: err.Error()}
+	}
+	return &RPCError{Code: CodeInternal, Message: err.Error()}
+}
@@ -1,41 +1,85 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"io"
 	"log"
+	"net/http"
 	"reflect"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/givensuman/go-sockets"
+	"github.com/givensuman/go-sockets/internal/dispatch"
 	"github.com/givensuman/go-sockets/internal/emitter"
+	"github.com/givensuman/go-sockets/internal/mux"
 	"github.com/givensuman/go-sockets/internal/parser"
 	"github.com/gorilla/websocket"
 )
 
+// outgoing is a packet queued for the write loop.
+type outgoing struct {
+	packet sockets.Packet
+	// recorded is when a broadcast was recorded in room history, or zero.
+	recorded time.Time
+	// frame is a byte stream frame, written as a binary message instead of packet.
+	frame []byte
+}
+
 // Socket represents a server-side WebSocket connection to a client.
 // It embeds EventEmitter for event handling and manages acknowledgments.
 type Socket struct {
 	emitter.EventEmitter
 	Conn       *websocket.Conn
 	ID         string
-	writeChan  chan sockets.Packet
+	Request    *http.Request // the HTTP request that opened the connection
+	writeChan  chan outgoing
 	closeOnce  sync.Once
+	closeMu    sync.RWMutex
+	closed     bool
 	Namespace  *Namespace
 	ackCounter uint64
-	ackMap     sync.Map // uint64 -> reflect.Value
+	ackMap     sync.Map // uint64 -> *emitter.Callback
+	userMu     sync.RWMutex
+	userID     string // written under Namespace.roomMu and userMu, read under either
+	session    *session
+	stream     bool // an event stream subscriber; see Server.EventStreamHandler
+	relay      RelayFunc
+	outgoing   emitter.EventEmitter // OnAnyOutgoing listeners
+	handlers   *dispatch.Queue      // runs the listeners of incoming events
+	ctx        context.Context      // cancelled by Close
+	cancel     context.CancelFunc
+	inflight   sync.Map     // ack ID -> context.CancelFunc of an event being handled
+	streams    sync.Map     // stream ID -> *StreamWriter
+	mux        *mux.Session // byte streams, nil for event stream subscribers
 }
 
 func (s *Socket) readLoop() {
 	defer s.Close()
+	defer s.handlers.Close()
 
 	for {
-		_, data, err := s.Conn.ReadMessage()
+		messageType, data, err := s.Conn.ReadMessage()
 		if err != nil {
+			s.closeMu.RLock()
+			reason := "transport close"
+			if s.closed {
+				reason = "server disconnect"
+			}
+			s.closeMu.RUnlock()
+			s.handlers.Run(func() {
+				s.EventEmitter.Emit("disconnect", reason)
+			})
 			return
 		}
 
+		if messageType == websocket.BinaryMessage {
+			s.mux.Receive(data)
+			continue
+		}
+
 		packet, err := parser.Decode(data)
 		if err != nil {
 			log.Println("decode error:", err)
@@ -44,14 +88,14 @@ func (s *Socket) readLoop() {
 
 		switch packet.Type {
 		case sockets.Connect:
-			s.EventEmitter.Emit("connect")
+			s.handlers.Run(func() {
+				s.EventEmitter.Emit("connect")
+			})
 			connectPacket := sockets.Packet{
 				Type:      sockets.Connect,
 				Namespace: packet.Namespace,
 			}
-			select {
-			case s.writeChan <- connectPacket:
-			default:
+			if !s.send(connectPacket) {
 				s.Close()
 			}
 
@@ -66,92 +110,214 @@ func (s *Socket) readLoop() {
 				continue
 			}
 
-			if packet.ID != nil {
-				ackFuncValue := reflect.ValueOf(func(args ...any) {
-					ackData, _ := json.Marshal(args)
-					ackPacket := sockets.Packet{
-						Type:      sockets.Ack,
-						Data:      json.RawMessage(ackData),
-						Namespace: packet.Namespace,
-						ID:        packet.ID,
-					}
-
-					select {
-					case s.writeChan <- ackPacket:
-					default:
-						s.Close()
-					}
-				})
-
-				callbackType := s.GetCallbackType(*eventName)
-				if callbackType != nil && callbackType.NumIn() > 1 {
-					ackType := callbackType.In(callbackType.NumIn() - 1)
-					ackValue := reflect.MakeFunc(ackType, func(in []reflect.Value) []reflect.Value {
-						args := make([]any, len(in))
-						for i, v := range in {
-							args[i] = v.Interface()
-						}
-						callArgs := make([]reflect.Value, len(args))
-						for i, arg := range args {
-							callArgs[i] = reflect.ValueOf(arg)
-						}
-						ackFuncValue.Call(callArgs)
-						return nil
+			// Cancellations are handled here so that they reach listeners
+			// still running or waiting in the queue
+			if *eventName == cancelEvent {
+				s.cancelAck(eventArgs)
+				continue
+			}
+			// A relaying socket passes streams on like other events
+			if s.relay == nil && s.streamControl(*eventName, eventArgs) {
+				continue
+			}
+			if *eventName == streamOpen && s.relay == nil {
+				w, event, args, ok := s.openStream(eventArgs)
+				if !ok {
+					continue
+				}
+				s.Namespace.webhookEvent(s, event, args)
+				if s.handlers.Inline() {
+					// The listener would wait for credit the reader cannot read
+					go s.handleStream(w, event, args)
+				} else {
+					s.handlers.Run(func() {
+						s.handleStream(w, event, args)
 					})
-
-					eventArgs = append(eventArgs, ackValue.Interface())
 				}
+				continue
 			}
 
-			s.EventEmitter.Emit(*eventName, eventArgs...)
+			s.Namespace.webhookEvent(s, *eventName, eventArgs)
+			timeout := s.Namespace.server.ackTimeout
+			if _, ok := s.Namespace.serviceMethod(*eventName); ok && s.relay == nil {
+				// Calls carry the client's deadline, which the call timeout caps
+				timeout = s.Namespace.server.callTimeout
+			}
+			ctx, cancel := s.eventContext(packet.ID, timeout)
+			s.handlers.Run(func() {
+				s.handleEvent(ctx, cancel, packet, *eventName, eventArgs)
+			})
 
 		case sockets.Ack:
+			// Acks are handled here rather than dispatched, so that they are
+			// not held up by slow event handlers
 			if packet.ID != nil {
 				if callback, ok := s.ackMap.Load(*packet.ID); ok {
 					s.ackMap.Delete(*packet.ID)
 
 					var ackArgs []any
 					json.Unmarshal(packet.Data, &ackArgs)
-
-					var callArgs []reflect.Value
-					for _, arg := range ackArgs {
-						callArgs = append(callArgs, reflect.ValueOf(arg))
-					}
-
-					callback.(reflect.Value).Call(callArgs)
+					s.callAck(callback.(*emitter.Callback), ackArgs)
 				}
 			}
 
 		case sockets.Disconnect:
-			s.EventEmitter.Emit("disconnect", "client request")
+			s.handlers.Run(func() {
+				s.EventEmitter.Emit("disconnect", "client request")
+			})
 			return
 		}
 	}
 }
 
+// handleEvent runs the listeners of an event received from the client with
+// ctx, which cancel cancels once the event is acknowledged.
+func (s *Socket) handleEvent(ctx context.Context, cancel context.CancelFunc, packet sockets.Packet, event string, args []any) {
+	var ack func(args ...any)
+	if packet.ID != nil {
+		reply := s.ackFunc(packet)
+		ack = func(args ...any) {
+			cancel()
+			reply(args...)
+		}
+	}
+
+	if s.relay != nil {
+		s.relay(event, args, ack)
+		return
+	}
+
+	if event == reliableEvent {
+		s.receiveReliable(ctx, ack, args)
+		return
+	}
+
+	if m, ok := s.Namespace.serviceMethod(event); ok {
+		s.callMethod(ctx, event, m, args, ack)
+		return
+	}
+
+	if ack != nil {
+		if ackArg, ok := s.ackArg(event, ack); ok {
+			args = append(args, ackArg)
+		}
+	}
+
+	s.EventEmitter.EmitContext(ctx, event, func(event string, err error, stack []byte) {
+		s.Namespace.reportError(event, &HandlerError{Err: err, ack: ack}, stack)
+	}, args...)
+}
+
+// ackFunc returns a function that answers packet with an ACK carrying its arguments.
+func (s *Socket) ackFunc(packet sockets.Packet) func(args ...any) {
+	return func(args ...any) {
+		ackData, _ := json.Marshal(args)
+		ackPacket := sockets.Packet{
+			Type:      sockets.Ack,
+			Data:      json.RawMessage(ackData),
+			Namespace: packet.Namespace,
+			ID:        packet.ID,
+		}
+
+		if !s.send(ackPacket) {
+			s.Close()
+		}
+	}
+}
+
+// ackArg adapts ack to the last parameter of the listener registered for event.
+// A func-typed last parameter receives an ack of that exact type, while a
+// variadic ...any listener receives ack itself as its final argument.
+func (s *Socket) ackArg(event string, ack func(args ...any)) (any, bool) {
+	callbackType := s.GetCallbackType(event)
+	if callbackType == nil || callbackType.NumIn() == 0 {
+		return nil, false
+	}
+
+	lastType := callbackType.In(callbackType.NumIn() - 1)
+	if callbackType.IsVariadic() {
+		if lastType.Elem().Kind() != reflect.Interface {
+			return nil, false
+		}
+		return ack, true
+	}
+	if lastType.Kind() != reflect.Func {
+		return nil, false
+	}
+
+	ackValue := reflect.MakeFunc(lastType, func(in []reflect.Value) []reflect.Value {
+		args := make([]any, len(in))
+		for i, v := range in {
+			args[i] = v.Interface()
+		}
+		ack(args...)
+		return nil
+	})
+	return ackValue.Interface(), true
+}
+
 func (s *Socket) writeLoop() {
-	for packet := range s.writeChan {
+	for out := range s.writeChan {
+		if out.frame != nil {
+			if err := s.Conn.WriteMessage(websocket.BinaryMessage, out.frame); err != nil {
+				log.Println("write error:", err)
+				return
+			}
+			continue
+		}
+		packet := out.packet
+		s.sent(packet)
 		data := parser.Encode(packet)
 		err := s.Conn.WriteMessage(websocket.TextMessage, data)
 		if err != nil {
 			log.Println("write error:", err)
 			return
 		}
+		if packet.Type == sockets.Disconnect {
+			s.Close()
+			return
+		}
 	}
 }
 
+// OnAnyOutgoing registers a callback called with the name and arguments of
+// every event written to the client, including broadcasts and built-in events,
+// as they were encoded. It runs on the socket's writer before each write.
+func (s *Socket) OnAnyOutgoing(callback func(event string, args ...any)) *Subscription {
+	return s.outgoing.OnAny(callback)
+}
+
+// sent hands an event packet about to be written to the OnAnyOutgoing listeners.
+func (s *Socket) sent(packet sockets.Packet) {
+	if packet.Type != sockets.Event || s.outgoing.AnyListenerCount() == 0 {
+		return
+	}
+	event, ok := packet.GetEventName()
+	if !ok {
+		return
+	}
+	args, _ := packet.GetEventArgs()
+	s.outgoing.Emit(*event, args...)
+}
+
 // Emit sends an event to the client with optional arguments.
 // If the last argument is a function, it sets up an acknowledgment callback.
 func (s *Socket) Emit(event string, args ...any) {
+	if !s.emit(event, args...) {
+		s.Close()
+	}
+}
+
+// emit is Emit without closing the socket when its write buffer is full.
+func (s *Socket) emit(event string, args ...any) bool {
 	var ackID *uint64
 	if len(args) > 0 {
 		lastArg := args[len(args)-1]
-		if reflect.TypeOf(lastArg).Kind() == reflect.Func {
+		if lastArg != nil && reflect.TypeOf(lastArg).Kind() == reflect.Func {
 			id := atomic.AddUint64(&s.ackCounter, 1)
 			ackID = &id
-			s.ackMap.Store(id, reflect.ValueOf(lastArg))
-			// Timeout after 10 seconds
-			time.AfterFunc(10*time.Second, func() {
+			s.ackMap.Store(id, emitter.NewCallback(event, lastArg))
+			time.AfterFunc(s.Namespace.server.ackTimeout, func() {
 				s.ackMap.Delete(id)
 			})
 			args = args[:len(args)-1]
@@ -165,53 +331,159 @@ func (s *Socket) Emit(event string, args ...any) {
 		Namespace: s.Namespace.name,
 		ID:        ackID,
 	}
+	return s.send(packet)
+}
+
+// send queues packet for the write loop.
+// It returns false if the socket is closed or its write buffer is full.
+func (s *Socket) send(packet sockets.Packet) bool {
+	return s.queue(outgoing{packet: packet})
+}
+
+// sendWait is send, waiting while the write buffer is full until ctx is done.
+func (s *Socket) sendWait(ctx context.Context, packet sockets.Packet) error {
+	return s.queueWait(ctx, outgoing{packet: packet})
+}
+
+// queueWait is queue, waiting while the write buffer is full until ctx is done.
+func (s *Socket) queueWait(ctx context.Context, out outgoing) error {
+	s.closeMu.RLock()
+	defer s.closeMu.RUnlock()
+
+	if s.closed {
+		return ErrStreamClosed
+	}
+
 	select {
-	case s.writeChan <- packet:
-		return
+	case s.writeChan <- out:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// queue is send with the packet's history time.
+func (s *Socket) queue(out outgoing) bool {
+	s.closeMu.RLock()
+	defer s.closeMu.RUnlock()
+
+	if s.closed {
+		return false
+	}
+
+	select {
+	case s.writeChan <- out:
+		return true
 	default:
-		s.Close()
+		return false
 	}
 }
 
 // Join adds the socket to the specified room in its namespace.
-func (s *Socket) Join(room string) {
-	if roomMap, ok := s.Namespace.rooms.Load(room); ok {
-		roomMap.(*sync.Map).Store(s.ID, true)
-	} else {
-		roomMap := &sync.Map{}
-		roomMap.Store(s.ID, true)
-		s.Namespace.rooms.Store(room, roomMap)
+// It returns an error if a join policy set on the namespace denies the join.
+// Rooms starting with "user:" hold the sockets bound to a user, so a socket
+// joined to "user:<id>" is reached by Namespace.ToUser(id).
+func (s *Socket) Join(room string) error {
+	return s.JoinWithKey(room, "")
+}
+
+// JoinWithKey is like Join but passes key to the room's join policies,
+// for rooms protected by a password or token.
+func (s *Socket) JoinWithKey(room, key string) error {
+	return s.join(room, key, false)
+}
+
+func (s *Socket) join(room, key string, client bool) error {
+	ns := s.Namespace
+	ns.roomMu.Lock()
+	if ns.inRoom(room, s.ID) {
+		ns.roomMu.Unlock()
+		return nil
 	}
+	err := ns.checkJoin(s, room, key)
+	if err == nil {
+		ns.addToRoom(room, s.ID)
+	}
+	ns.roomMu.Unlock()
+
+	ns.audit(s.ID, RoomJoin, room, client, err)
+	if err == nil {
+		ns.joined(s, room)
+	}
+	return err
 }
 
 // Leave removes the socket from the specified room in its namespace.
 func (s *Socket) Leave(room string) {
-	if roomMap, ok := s.Namespace.rooms.Load(room); ok {
-		roomMap.(*sync.Map).Delete(s.ID)
+	s.leave(room, false)
+}
+
+func (s *Socket) leave(room string, client bool) {
+	ns := s.Namespace
+	ns.roomMu.Lock()
+	left := ns.removeFromRoom(room, s.ID)
+	ns.roomMu.Unlock()
+
+	if left {
+		ns.audit(s.ID, RoomLeave, room, client, nil)
+		ns.left(s, room)
 	}
 }
 
 // Broadcast returns a BroadcastOperator for sending events to other sockets in the namespace.
 func (s *Socket) Broadcast() *BroadcastOperator {
-	var targets []string
-	s.Namespace.sockets.Range(func(key, value any) bool {
-		id := key.(string)
-		if id != s.ID {
-			targets = append(targets, id)
-		}
-		return true
-	})
-
 	return &BroadcastOperator{
 		namespace: s.Namespace,
-		targets:   targets,
+		except:    []string{s.ID},
+	}
+}
+
+// To returns a BroadcastOperator for sending events to the other sockets in room.
+// Like Broadcast, it excludes the sender.
+func (s *Socket) To(room string) *BroadcastOperator {
+	return s.Broadcast().To(room)
+}
+
+// RelayFunc receives the events relayed by a socket. Ack is nil unless the
+// sender asked for an acknowledgment.
+type RelayFunc func(event string, args []any, ack func(args ...any))
+
+// Relay hands every event the client sends to fn instead of the socket's
+// listeners, including the built-in "join" and "leave" events, so that a proxy
+// can pass them on unchanged. It must be called from a "connection" listener,
+// before the first packet is read.
+func (s *Socket) Relay(fn RelayFunc) {
+	s.relay = fn
+}
+
+// Disconnect sends a DISCONNECT packet to the client and closes the socket
+// once the packets queued before it have been written.
+func (s *Socket) Disconnect() {
+	if !s.send(sockets.Packet{Type: sockets.Disconnect, Namespace: s.Namespace.name}) {
+		s.Close()
 	}
 }
 
 // Close closes the WebSocket connection and cleans up resources.
+// The socket is removed from its namespace and from every room it joined.
 func (s *Socket) Close() {
 	s.closeOnce.Do(func() {
-		s.Conn.Close()
+		// Cancel first, releasing writers waiting on a full write buffer
+		if s.cancel != nil {
+			s.cancel()
+		}
+		if s.mux != nil {
+			s.mux.Reset(io.ErrUnexpectedEOF)
+		}
+		s.Namespace.removeSocket(s)
+		s.Namespace.detachSession(s)
+		if s.Conn != nil {
+			s.Conn.Close()
+		}
+
+		s.closeMu.Lock()
+		s.closed = true
 		close(s.writeChan)
+		s.closeMu.Unlock()
 	})
 }
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/givensuman/go-sockets"
+	"github.com/google/uuid"
+)
+
+// Event stream settings.
+const (
+	streamBuffer    = 256
+	streamKeepAlive = 15 * time.Second
+)
+
+// EventStreamHandler returns an http.Handler streaming events to read-only
+// subscribers as Server-Sent Events, for clients that cannot open a WebSocket:
+//
+//	mux.Handle("/events", server.EventStreamHandler())
+//	// new EventSource("/events?namespace=/chat&room=lobby")
+//
+// Each subscriber is a socket in the namespace given by the "namespace" query
+// parameter, so connection middleware and "connection" listeners run for it,
+// and it receives whatever is emitted to it or broadcast to its rooms. A
+// namespace that does not exist yet ends the request with 404 Not Found. The
+// socket's Conn is nil and it never sends events or acknowledgments, so
+// EmitReliable sends it events only once. It joins the rooms listed in "room"
+// parameters as if it had sent "join", with the "key" parameter as the join
+// key, and any refusal ends the request with 403 Forbidden.
+//
+// Every event is sent as an SSE event named after it, whose data is the JSON
+// array of its arguments. Events recorded in room history carry their history
+// time as the SSE ID; a subscriber reconnecting with Last-Event-ID, or the
+// "lastEventId" parameter, is first sent the history of its rooms since then.
+// Otherwise it is sent the usual replay configured with SetHistory.
+func (s *Server) EventStreamHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		query := r.URL.Query()
+		// Looking the namespace up rather than creating it keeps
+		// unauthenticated requests from creating namespaces and adapters
+		ns, ok := s.namespace(query.Get("namespace"))
+		if !ok {
+			http.Error(w, "unknown namespace", http.StatusNotFound)
+			return
+		}
+		socket := &Socket{
+			ID:        uuid.New().String(),
+			Request:   r,
+			writeChan: make(chan outgoing, streamBuffer),
+			Namespace: ns,
+			stream:    true,
+		}
+		socket.ctx, socket.cancel = context.WithCancel(context.Background())
+		socket.Catch(ns.reportError)
+
+		if err := ns.runMiddleware(socket); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		ns.addSocket(socket)
+		ns.attachSession(socket)
+		defer socket.Close()
+
+		rooms := query["room"]
+		for _, room := range rooms {
+			err := ErrRoomNotAllowed
+			if s.clientRooms {
+				err = s.authorizeClientRoom(socket, RoomJoin, room)
+			}
+			if err != nil {
+				ns.audit(socket.ID, RoomJoin, room, true, err)
+			} else {
+				err = socket.join(room, query.Get("key"), true)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		ns.Emit("connection", socket)
+
+		// Events recorded up to the end of the replay are skipped when they
+		// also arrive live
+		lastEventID := r.Header.Get("Last-Event-ID")
+		if lastEventID == "" {
+			lastEventID = query.Get("lastEventId")
+		}
+		var replayed time.Time
+		for _, m := range ns.streamReplay(rooms, lastEventID) {
+			writeStreamEvent(w, m.Event, m.Args, m.Time)
+			replayed = m.Time
+		}
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(streamKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case out, ok := <-socket.writeChan:
+				if !ok || out.packet.Type == sockets.Disconnect {
+					return
+				}
+				if out.packet.Type != sockets.Event || (!out.recorded.IsZero() && !out.recorded.After(replayed)) {
+					continue
+				}
+				socket.sent(out.packet)
+				event, ok := out.packet.GetEventName()
+				if !ok {
+					continue
+				}
+				args := json.RawMessage("[]")
+				if eventArgs, ok := out.packet.GetEventArgs(); ok && len(eventArgs) > 0 {
+					args, _ = json.Marshal(eventArgs)
+				}
+				writeStreamEvent(w, *event, args, out.recorded)
+				flusher.Flush()
+
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+				flusher.Flush()
+
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+}
+
+// streamReplay returns the history of rooms for a new event stream, oldest
+// first: every message since lastEventID if it is set, or the configured
+// replay otherwise. A message recorded in several rooms is returned once.
+func (ns *Namespace) streamReplay(rooms []string, lastEventID string) []HistoryMessage {
+	var since time.Time
+	resume := false
+	if nanos, err := strconv.ParseInt(lastEventID, 10, 64); err == nil {
+		since, resume = time.Unix(0, nanos), true
+	}
+
+	var messages []HistoryMessage
+	for _, room := range rooms {
+		store, replay := ns.historyFor(room)
+		if store == nil || (!resume && replay <= 0) {
+			continue
+		}
+		if resume {
+			replay = 0
+		}
+		found, err := store.Since(room, since, replay)
+		if err != nil {
+			continue
+		}
+		messages = append(messages, found...)
+	}
+
+	slices.SortStableFunc(messages, func(a, b HistoryMessage) int {
+		return a.Time.Compare(b.Time)
+	})
+	return slices.CompactFunc(messages, func(a, b HistoryMessage) bool {
+		return a.Time.Equal(b.Time) && a.Event == b.Event
+	})
+}
+
+// streamEventName replaces the line endings of SSE in event names, which
+// would otherwise let an event name add fields of its own.
+var streamEventName = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// writeStreamEvent writes one SSE event. Its ID is the history time, if any.
+func writeStreamEvent(w http.ResponseWriter, event string, args json.RawMessage, recorded time.Time) {
+	if !recorded.IsZero() {
+		fmt.Fprintf(w, "id: %d\n", recorded.UnixNano())
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", streamEventName.Replace(event), args)
+}
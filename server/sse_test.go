@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type streamEvent struct {
+	id, event, data string
+}
+
+// openStream opens an event stream and returns a reader over its body.
+func openStream(t *testing.T, url, lastEventID string) (*http.Response, *bufio.Reader) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	t.Cleanup(cancel)
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp, bufio.NewReader(resp.Body)
+}
+
+// readStreamEvent reads the next event, skipping comments.
+func readStreamEvent(t *testing.T, r *bufio.Reader) streamEvent {
+	t.Helper()
+	var ev streamEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && ev.event != "":
+			return ev
+		case strings.HasPrefix(line, "id: "):
+			ev.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			ev.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			ev.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	server := NewServer()
+	ns := server.Of("/feed")
+	ns.SetHistory("lobby", NewMemoryHistory(10), 2)
+	ns.Use(func(s *Socket) error {
+		if s.Request.URL.Query().Get("token") != "ok" {
+			return errors.New("unauthorized")
+		}
+		return nil
+	})
+	connected := make(chan *Socket, 2)
+	ns.On("connection", func(s *Socket) {
+		connected <- s
+	})
+
+	httpServer := httptest.NewServer(server.EventStreamHandler())
+	t.Cleanup(httpServer.Close)
+	url := httpServer.URL + "?namespace=/feed&room=lobby"
+
+	if resp, _ := openStream(t, url, ""); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected middleware to refuse, got %d", resp.StatusCode)
+	}
+	if resp, _ := openStream(t, httpServer.URL+"?namespace=/unknown&token=ok", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected an unknown namespace to be refused, got %d", resp.StatusCode)
+	}
+	if _, ok := server.namespace("/unknown"); ok {
+		t.Error("expected the unknown namespace not to be created")
+	}
+
+	for _, msg := range []string{"one", "two", "three"} {
+		ns.To("lobby").Emit("post", msg)
+	}
+
+	resp, stream := openStream(t, url+"&token=ok", "")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected response %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	two := readStreamEvent(t, stream)
+	if two.event != "post" || two.data != `["two"]` || two.id == "" {
+		t.Errorf("expected replay of two, got %+v", two)
+	}
+	if three := readStreamEvent(t, stream); three.data != `["three"]` {
+		t.Errorf("expected replay of three, got %+v", three)
+	}
+
+	sub := <-connected
+	if !ns.inRoom("lobby", sub.ID) {
+		t.Error("expected the subscriber to be in the room")
+	}
+	ns.To("lobby").Emit("post", "four", 4)
+	if four := readStreamEvent(t, stream); four.data != `["four",4]` || four.id == "" {
+		t.Errorf("expected live event, got %+v", four)
+	}
+	sub.Emit("direct")
+	if direct := readStreamEvent(t, stream); direct.event != "direct" || direct.data != "[]" || direct.id != "" {
+		t.Errorf("expected direct event without ID, got %+v", direct)
+	}
+	sub.EmitReliable("receipt", "r-1")
+	if receipt := readStreamEvent(t, stream); receipt.event != "receipt" || receipt.data != `["r-1"]` {
+		t.Errorf("expected the reliable event unwrapped, got %+v", receipt)
+	}
+	if n := sub.session.outbox.Len(); n != 0 {
+		t.Errorf("expected nothing left to resend to the stream, got %d", n)
+	}
+	sub.Emit("evil\rid: 1\r\ndata: x")
+	if evil := readStreamEvent(t, stream); evil.event != "evil id: 1 data: x" || evil.id != "" || evil.data != "[]" {
+		t.Errorf("expected line endings in the name to be replaced, got %+v", evil)
+	}
+
+	// Resuming after "two" sends everything since
+	_, resumed := openStream(t, url+"&token=ok", two.id)
+	for _, want := range []string{`["three"]`, `["four",4]`} {
+		if ev := readStreamEvent(t, resumed); ev.data != want {
+			t.Errorf("expected resumed %s, got %+v", want, ev)
+		}
+	}
+
+	if resp, _ := openStream(t, httpServer.URL+"?namespace=/feed&token=ok&room="+sub.ID, ""); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected private room to be refused, got %d", resp.StatusCode)
+	}
+}
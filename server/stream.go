@@ -0,0 +1,216 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+
+	"github.com/givensuman/go-sockets"
+)
+
+// Stream messages. A client opens a stream with
+//
+//	["stream:open", id, window, event, args...]
+//
+// and the server answers with any number of ["stream:chunk", id, chunk]
+// followed by ["stream:end", id] or ["stream:error", id, message]. The server
+// sends at most window chunks until the client grants more with
+// ["stream:credit", id, n]. ["stream:cancel", id] ends the stream early.
+const (
+	streamOpen   = "stream:open"
+	streamChunk  = "stream:chunk"
+	streamEnd    = "stream:end"
+	streamError  = "stream:error"
+	streamCredit = "stream:credit"
+	streamCancel = "stream:cancel"
+)
+
+// ErrStreamClosed is returned when writing to a stream that has ended.
+var ErrStreamClosed = errors.New("stream closed")
+
+// StreamWriter sends the partial replies of a stream opened by a client.
+// A listener receives it as its last parameter:
+//
+//	socket.On("generate", func(ctx context.Context, prompt string, w *server.StreamWriter) {
+//		for token := range tokens(ctx, prompt) {
+//			if err := w.Send(token); err != nil {
+//				return
+//			}
+//		}
+//		w.Close()
+//	})
+//
+// The stream stays open until Close or Error is called, so the writer may be
+// handed to another goroutine. It ends without either if the socket closes.
+type StreamWriter struct {
+	socket *Socket
+	id     uint64
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	credits int
+	ended   bool
+	wake    chan struct{} // signalled when credits are granted
+}
+
+var streamWriterType = reflect.TypeFor[*StreamWriter]()
+
+// Context returns a context cancelled when the stream ends, the client
+// cancels it, or the socket closes.
+func (w *StreamWriter) Context() context.Context {
+	return w.ctx
+}
+
+// Send sends a chunk, waiting while the client has not granted credit for it.
+// It returns ErrStreamClosed once the stream has ended, or the context's error
+// if the stream is cancelled while waiting.
+func (w *StreamWriter) Send(chunk any) error {
+	for {
+		w.mu.Lock()
+		if w.ended {
+			w.mu.Unlock()
+			return ErrStreamClosed
+		}
+		if w.credits > 0 {
+			w.credits--
+			w.mu.Unlock()
+			break
+		}
+		w.mu.Unlock()
+
+		select {
+		case <-w.wake:
+		case <-w.ctx.Done():
+			return w.ctx.Err()
+		}
+	}
+	return w.socket.sendWait(w.ctx, w.packet(streamChunk, chunk))
+}
+
+// Close ends the stream successfully.
+func (w *StreamWriter) Close() error {
+	return w.end(w.packet(streamEnd))
+}
+
+// Error ends the stream with err, whose message the client receives.
+func (w *StreamWriter) Error(err error) error {
+	return w.end(w.packet(streamError, err.Error()))
+}
+
+// end sends the last message of the stream and forgets it.
+func (w *StreamWriter) end(packet sockets.Packet) error {
+	w.mu.Lock()
+	if w.ended {
+		w.mu.Unlock()
+		return ErrStreamClosed
+	}
+	w.ended = true
+	w.mu.Unlock()
+
+	defer w.close()
+	return w.socket.sendWait(w.ctx, packet)
+}
+
+// close cancels the stream's context and forgets it.
+func (w *StreamWriter) close() {
+	w.cancel()
+	w.socket.streams.Delete(w.id)
+}
+
+// grant adds credit for n more chunks.
+func (w *StreamWriter) grant(n int) {
+	w.mu.Lock()
+	w.credits += n
+	w.mu.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *StreamWriter) packet(event string, args ...any) sockets.Packet {
+	data, _ := json.Marshal(append([]any{event, w.id}, args...))
+	return sockets.Packet{
+		Type:      sockets.Event,
+		Data:      json.RawMessage(data),
+		Namespace: w.socket.Namespace.name,
+	}
+}
+
+// streamControl handles the stream messages that must not wait for the
+// listeners, as those may be blocked sending. It reports whether event was one.
+func (s *Socket) streamControl(event string, args []any) bool {
+	switch event {
+	case streamCredit, streamCancel:
+	default:
+		return false
+	}
+	if len(args) == 0 {
+		return true
+	}
+	id, _ := args[0].(float64)
+	value, ok := s.streams.Load(uint64(id))
+	if !ok {
+		return true
+	}
+	w := value.(*StreamWriter)
+
+	if event == streamCancel {
+		w.mu.Lock()
+		w.ended = true
+		w.mu.Unlock()
+		w.close()
+		return true
+	}
+	if len(args) > 1 {
+		if n, ok := args[1].(float64); ok && n > 0 {
+			w.grant(int(n))
+		}
+	}
+	return true
+}
+
+// openStream registers the stream opened by args, returning its writer and the
+// event and arguments of the request.
+func (s *Socket) openStream(args []any) (*StreamWriter, string, []any, bool) {
+	if len(args) < 3 {
+		return nil, "", nil, false
+	}
+	id, ok1 := args[0].(float64)
+	window, ok2 := args[1].(float64)
+	event, ok3 := args[2].(string)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, "", nil, false
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	w := &StreamWriter{
+		socket:  s,
+		id:      uint64(id),
+		ctx:     ctx,
+		cancel:  cancel,
+		credits: int(window),
+		wake:    make(chan struct{}, 1),
+	}
+	s.streams.Store(w.id, w)
+	return w, event, args[3:], true
+}
+
+// handleStream runs the listeners of a stream request. The stream ends with an
+// error if no listener takes a *StreamWriter, or if one fails.
+func (s *Socket) handleStream(w *StreamWriter, event string, args []any) {
+	callbackType := s.GetCallbackType(event)
+	if callbackType == nil || callbackType.NumIn() == 0 || callbackType.In(callbackType.NumIn()-1) != streamWriterType {
+		w.Error(errors.New("no stream handler for " + event))
+		return
+	}
+
+	s.EventEmitter.EmitContext(w.ctx, event, func(event string, err error, stack []byte) {
+		s.Namespace.reportError(event, &HandlerError{Err: err}, stack)
+		w.Error(errors.New("stream handler failed"))
+	}, append(args, w)...)
+}
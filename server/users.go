@@ -0,0 +1,72 @@
+package server
+
+import "strings"
+
+// userRoomPrefix prefixes the room shared by all sockets bound to a user.
+// Every room with the prefix is reserved: clients cannot join or leave one
+// through the built-in handlers.
+const userRoomPrefix = "user:"
+
+func userRoom(userID string) string {
+	return userRoomPrefix + userID
+}
+
+// SetUser binds the socket to a user ID, usually from connection middleware.
+// All sockets bound to the same user share the room "user:<userID>" and can
+// then be reached with Namespace.ToUser.
+// The namespace emits "user_online" with the user ID when the user's first socket
+// is bound, and "user_offline" when the last one disconnects or is rebound.
+// An empty userID unbinds the socket.
+func (s *Socket) SetUser(userID string) {
+	ns := s.Namespace
+
+	ns.roomMu.Lock()
+	s.userMu.Lock()
+	previous := s.userID
+	s.userID = userID
+	s.userMu.Unlock()
+	registered := ns.isRegistered(s)
+	var online, offline bool
+	if registered && previous != userID {
+		if previous != "" {
+			ns.removeFromRoom(userRoom(previous), s.ID)
+			offline = ns.roomSize(userRoom(previous)) == 0
+		}
+		if userID != "" {
+			ns.addToRoom(userRoom(userID), s.ID)
+			online = ns.roomSize(userRoom(userID)) == 1
+		}
+	}
+	ns.roomMu.Unlock()
+
+	if offline {
+		ns.Emit("user_offline", previous)
+	}
+	if online {
+		ns.Emit("user_online", userID)
+		ns.deliverInbox(s, userID)
+	}
+}
+
+// User returns the user ID bound to the socket, or an empty string if none.
+// It does not take the namespace's room lock, so join policies may call it.
+func (s *Socket) User() string {
+	s.userMu.RLock()
+	defer s.userMu.RUnlock()
+	return s.userID
+}
+
+// ToUser creates a BroadcastOperator for broadcasting to every socket bound to userID.
+func (ns *Namespace) ToUser(userID string) *BroadcastOperator {
+	return ns.To(userRoom(userID))
+}
+
+// UserSockets returns the connected sockets bound to userID.
+func (ns *Namespace) UserSockets(userID string) []*Socket {
+	return ns.ToUser(userID).targets()
+}
+
+// isUserRoom reports whether room is reserved for a user's sockets.
+func isUserRoom(room string) bool {
+	return strings.HasPrefix(room, userRoomPrefix)
+}
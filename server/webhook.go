@@ -0,0 +1,385 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WebhookType identifies what a WebhookPayload reports.
+type WebhookType string
+
+// Webhook event types.
+const (
+	WebhookConnect    WebhookType = "connect"
+	WebhookDisconnect WebhookType = "disconnect"
+	WebhookJoin       WebhookType = "join"
+	WebhookLeave      WebhookType = "leave"
+	// WebhookEvent reports an event received from a client.
+	WebhookEvent WebhookType = "event"
+)
+
+// Default webhook settings.
+const (
+	DefaultWebhookQueueSize     = 1000
+	DefaultWebhookBatchSize     = 100
+	DefaultWebhookBatchInterval = 1 * time.Second
+	DefaultWebhookRetries       = 5
+	DefaultWebhookMinBackoff    = 500 * time.Millisecond
+	DefaultWebhookMaxBackoff    = 30 * time.Second
+	DefaultWebhookTimeout       = 10 * time.Second
+)
+
+// WebhookPayload is one entry of a webhook batch.
+type WebhookPayload struct {
+	Type      WebhookType `json:"type"`
+	Namespace string      `json:"namespace"`
+	SocketID  string      `json:"socketId"`
+	User      string      `json:"user,omitempty"`
+	// Room is set for join and leave.
+	Room string `json:"room,omitempty"`
+	// Event and Args are set for client events.
+	Event string          `json:"event,omitempty"`
+	Args  json.RawMessage `json:"args,omitempty"`
+	Time  time.Time       `json:"time"`
+}
+
+// WebhookBatch is the JSON body posted to webhook endpoints.
+type WebhookBatch struct {
+	Events []WebhookPayload `json:"events"`
+}
+
+// WebhookEndpoint is a URL receiving webhook batches, with the filters
+// selecting what it receives.
+type WebhookEndpoint struct {
+	URL string
+	// Secret signs each body like a publish request: the X-Signature header
+	// carries the HMAC-SHA256 of "<X-Timestamp>.<body>"; see SignPublish.
+	Secret []byte
+	// Types selects the lifecycle types delivered; empty means connect,
+	// disconnect, join and leave. Client events are selected with Events.
+	Types []WebhookType
+	// Events lists path.Match patterns of client event names to deliver.
+	Events []string
+	// Namespaces lists path.Match patterns of namespaces; empty means all.
+	Namespaces []string
+}
+
+// WebhookOptions configures the webhook dispatcher.
+type WebhookOptions struct {
+	Endpoints []WebhookEndpoint
+	// QueueSize bounds the events waiting for each endpoint; further events
+	// are dropped and counted by Server.WebhookDrops. Zero means
+	// DefaultWebhookQueueSize.
+	QueueSize int
+	// BatchSize is the most events posted at once. Zero means
+	// DefaultWebhookBatchSize.
+	BatchSize int
+	// BatchInterval is the longest an event waits for its batch to fill.
+	// Zero means DefaultWebhookBatchInterval.
+	BatchInterval time.Duration
+	// Retries is how many times a failed batch is retried, with exponential
+	// backoff from MinBackoff to MaxBackoff. Batches refused with a 4xx
+	// status other than 429 are not retried. Zero means
+	// DefaultWebhookRetries, and a negative value disables retries.
+	Retries int
+	// MinBackoff is the wait before the first retry. Zero means
+	// DefaultWebhookMinBackoff.
+	MinBackoff time.Duration
+	// MaxBackoff caps the wait between retries. Zero means
+	// DefaultWebhookMaxBackoff.
+	MaxBackoff time.Duration
+	// Client posts the batches; it defaults to a client with a 10 second timeout.
+	Client *http.Client
+}
+
+// WithWebhooks posts connection, room and selected client events to HTTP
+// endpoints in signed JSON batches. Each endpoint has its own queue, so a slow
+// endpoint does not hold up the others. Server.Close flushes the queues.
+func WithWebhooks(opts WebhookOptions) Option {
+	return func(s *Server) {
+		s.webhooks = newWebhookDispatcher(opts)
+	}
+}
+
+// WebhookDrops returns the number of webhook events dropped because an
+// endpoint's queue was full or its batch failed every retry.
+func (s *Server) WebhookDrops() uint64 {
+	if s.webhooks == nil {
+		return 0
+	}
+	return s.webhooks.drops.Load()
+}
+
+type webhookDispatcher struct {
+	opts    WebhookOptions
+	targets []*webhookTarget
+	drops   atomic.Uint64
+	closed  chan struct{}
+	wg      sync.WaitGroup
+}
+
+type webhookTarget struct {
+	WebhookEndpoint
+	queue chan WebhookPayload
+}
+
+func newWebhookDispatcher(opts WebhookOptions) *webhookDispatcher {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultWebhookQueueSize
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultWebhookBatchSize
+	}
+	if opts.BatchInterval <= 0 {
+		opts.BatchInterval = DefaultWebhookBatchInterval
+	}
+	if opts.Retries < 0 {
+		opts.Retries = 0
+	} else if opts.Retries == 0 {
+		opts.Retries = DefaultWebhookRetries
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultWebhookMinBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultWebhookMaxBackoff
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: DefaultWebhookTimeout}
+	}
+
+	d := &webhookDispatcher{opts: opts, closed: make(chan struct{})}
+	for _, endpoint := range opts.Endpoints {
+		t := &webhookTarget{
+			WebhookEndpoint: endpoint,
+			queue:           make(chan WebhookPayload, opts.QueueSize),
+		}
+		d.targets = append(d.targets, t)
+		d.wg.Add(1)
+		go d.run(t)
+	}
+	return d
+}
+
+// wants reports whether the endpoint receives p.
+func (t *webhookTarget) wants(p WebhookPayload) bool {
+	if len(t.Namespaces) > 0 && !matchAny(t.Namespaces, p.Namespace) {
+		return false
+	}
+	if p.Type == WebhookEvent {
+		return matchAny(t.Events, p.Event)
+	}
+	return len(t.Types) == 0 || slices.Contains(t.Types, p.Type)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// wantsEvent reports whether any endpoint receives the client event, so its
+// arguments are only encoded when needed.
+func (d *webhookDispatcher) wantsEvent(namespace, event string) bool {
+	for _, t := range d.targets {
+		if t.wants(WebhookPayload{Type: WebhookEvent, Namespace: namespace, Event: event}) {
+			return true
+		}
+	}
+	return false
+}
+
+// dispatch queues p for every endpoint that receives it.
+func (d *webhookDispatcher) dispatch(p WebhookPayload) {
+	select {
+	case <-d.closed:
+		return
+	default:
+	}
+
+	for _, t := range d.targets {
+		if !t.wants(p) {
+			continue
+		}
+		select {
+		case t.queue <- p:
+		default:
+			d.drops.Add(1)
+		}
+	}
+}
+
+// run posts the endpoint's queue in batches until the dispatcher is closed,
+// then flushes what is left.
+func (d *webhookDispatcher) run(t *webhookTarget) {
+	defer d.wg.Done()
+
+	for {
+		var batch []WebhookPayload
+		select {
+		case p := <-t.queue:
+			batch = append(batch, p)
+		case <-d.closed:
+			d.flush(t)
+			return
+		}
+
+		timer := time.NewTimer(d.opts.BatchInterval)
+	fill:
+		for len(batch) < d.opts.BatchSize {
+			select {
+			case p := <-t.queue:
+				batch = append(batch, p)
+			case <-timer.C:
+				break fill
+			case <-d.closed:
+				break fill
+			}
+		}
+		timer.Stop()
+
+		d.deliver(t, batch, true)
+	}
+}
+
+// flush posts every queued event once, without retries.
+func (d *webhookDispatcher) flush(t *webhookTarget) {
+	for {
+		var batch []WebhookPayload
+	drain:
+		for len(batch) < d.opts.BatchSize {
+			select {
+			case p := <-t.queue:
+				batch = append(batch, p)
+			default:
+				break drain
+			}
+		}
+		if len(batch) == 0 {
+			return
+		}
+		d.deliver(t, batch, false)
+	}
+}
+
+// deliver posts a batch, retrying with backoff if retry is set.
+func (d *webhookDispatcher) deliver(t *webhookTarget, batch []WebhookPayload, retry bool) {
+	body, err := json.Marshal(WebhookBatch{Events: batch})
+	if err != nil {
+		d.drops.Add(uint64(len(batch)))
+		return
+	}
+
+	backoff := d.opts.MinBackoff
+	for attempt := 0; ; attempt++ {
+		again, err := d.post(t, body)
+		if err == nil {
+			return
+		}
+		if !again || !retry || attempt >= d.opts.Retries {
+			log.Println("webhook error:", err)
+			d.drops.Add(uint64(len(batch)))
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-d.closed:
+			// Shutting down; one last attempt without waiting
+			retry = false
+		}
+		backoff = min(backoff*2, d.opts.MaxBackoff)
+	}
+}
+
+// post sends body to the endpoint, reporting whether a failure is worth retrying.
+func (d *webhookDispatcher) post(t *webhookTarget, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(t.Secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(SignPublish(t.Secret, timestamp, body)))
+	}
+
+	resp, err := d.opts.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = &webhookStatusError{url: t.URL, status: resp.StatusCode}
+	retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retryable, err
+}
+
+// close stops accepting events and waits for the queues to be flushed.
+func (d *webhookDispatcher) close() {
+	select {
+	case <-d.closed:
+		return
+	default:
+		close(d.closed)
+	}
+	d.wg.Wait()
+}
+
+type webhookStatusError struct {
+	url    string
+	status int
+}
+
+func (e *webhookStatusError) Error() string {
+	return e.url + " answered " + strconv.Itoa(e.status)
+}
+
+// webhook reports a socket lifecycle change to the webhook dispatcher, if any.
+func (ns *Namespace) webhook(typ WebhookType, s *Socket, room string) {
+	if ns.server == nil || ns.server.webhooks == nil {
+		return
+	}
+	ns.server.webhooks.dispatch(WebhookPayload{
+		Type:      typ,
+		Namespace: ns.name,
+		SocketID:  s.ID,
+		User:      s.User(),
+		Room:      room,
+		Time:      time.Now(),
+	})
+}
+
+// webhookEvent reports an event received from a client to the webhook
+// dispatcher, if an endpoint selects it.
+func (ns *Namespace) webhookEvent(s *Socket, event string, args []any) {
+	if ns.server == nil || ns.server.webhooks == nil || !ns.server.webhooks.wantsEvent(ns.name, event) {
+		return
+	}
+	data, _ := json.Marshal(args)
+	ns.server.webhooks.dispatch(WebhookPayload{
+		Type:      WebhookEvent,
+		Namespace: ns.name,
+		SocketID:  s.ID,
+		User:      s.User(),
+		Event:     event,
+		Args:      data,
+		Time:      time.Now(),
+	})
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/givensuman/go-sockets"
+)
+
+func TestWebhooks(t *testing.T) {
+	secret := []byte("hook")
+	var (
+		mu       sync.Mutex
+		attempts int
+		received []WebhookPayload
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		want := "sha256=" + hex.EncodeToString(SignPublish(secret, r.Header.Get(TimestampHeader), body))
+		if r.Header.Get(SignatureHeader) != want {
+			t.Error("bad webhook signature")
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			// The first batch is retried
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var batch WebhookBatch
+		if err := json.Unmarshal(body, &batch); err != nil {
+			t.Error(err)
+		}
+		received = append(received, batch.Events...)
+	}))
+	t.Cleanup(receiver.Close)
+
+	server := NewServer(WithWebhooks(WebhookOptions{
+		Endpoints: []WebhookEndpoint{{
+			URL:    receiver.URL,
+			Secret: secret,
+			Events: []string{"chat:*"},
+		}},
+		BatchInterval: 20 * time.Millisecond,
+		MinBackoff:    10 * time.Millisecond,
+	}))
+	t.Cleanup(func() { server.Close() })
+	url := startTestServer(t, server)
+
+	conn := dialTest(t, url)
+	joinTest(t, conn, 1, `"lobby"`)
+	sendTestPacket(t, conn, sockets.Packet{Type: sockets.Event, Data: json.RawMessage(`["typing"]`)})
+	sendTestPacket(t, conn, sockets.Packet{Type: sockets.Event, Data: json.RawMessage(`["chat:message","hi"]`)})
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := len(received)
+		mu.Unlock()
+		if n >= 5 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []WebhookPayload{
+		{Type: WebhookConnect},
+		{Type: WebhookJoin, Room: "lobby"},
+		{Type: WebhookEvent, Event: "chat:message", Args: json.RawMessage(`["hi"]`)},
+		{Type: WebhookLeave, Room: "lobby"},
+		{Type: WebhookDisconnect},
+	}
+	if len(received) != len(want) {
+		t.Fatalf("expected %d webhook events, got %+v", len(want), received)
+	}
+	for i, got := range received {
+		if got.Type != want[i].Type || got.Room != want[i].Room || got.Event != want[i].Event ||
+			string(got.Args) != string(want[i].Args) || got.Namespace != "/" || got.SocketID == "" {
+			t.Errorf("event %d: expected %+v, got %+v", i, want[i], got)
+		}
+	}
+	if attempts < 2 {
+		t.Error("expected the failed batch to be retried")
+	}
+	if drops := server.WebhookDrops(); drops != 0 {
+		t.Errorf("expected no drops, got %d", drops)
+	}
+}
+
+func TestWebhookQueueBound(t *testing.T) {
+	entered := make(chan struct{}, 10)
+	release := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+	}))
+	t.Cleanup(receiver.Close)
+
+	d := newWebhookDispatcher(WebhookOptions{
+		Endpoints: []WebhookEndpoint{{URL: receiver.URL, Types: []WebhookType{WebhookConnect}}},
+		QueueSize: 1,
+		BatchSize: 1,
+	})
+
+	d.dispatch(WebhookPayload{Type: WebhookConnect})
+	<-entered
+	// Filtered out, so neither queued nor dropped
+	d.dispatch(WebhookPayload{Type: WebhookJoin})
+	for range 3 {
+		d.dispatch(WebhookPayload{Type: WebhookConnect})
+	}
+	if drops := d.drops.Load(); drops != 2 {
+		t.Errorf("expected 2 drops, got %d", drops)
+	}
+
+	close(release)
+	d.close()
+	if len(entered) != 1 {
+		t.Errorf("expected the queued event to be flushed, got %d more posts", len(entered))
+	}
+}